@@ -0,0 +1,360 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// specAnnotation keeps the v1beta1 spec and status fields that v1alpha1
+// cannot express so that a v1beta1 -> v1alpha1 -> v1beta1 round trip is lossless.
+const specAnnotation = "dns.dnspolicies.io/v1beta1-spec"
+
+// savedSpec is the content of specAnnotation.
+type savedSpec struct {
+	MatchExpressions []metav1.LabelSelectorRequirement   `json:"matchExpressions,omitempty"`
+	BlockList        []dnsv1beta1.DomainRule             `json:"blockList,omitempty"`
+	AllowList        []dnsv1beta1.DomainRule             `json:"allowList,omitempty"`
+	Priority         int32                               `json:"priority,omitempty"`
+	MergeMode        dnsv1beta1.MergeMode                `json:"mergeMode,omitempty"`
+	Subject          *dnsv1beta1.Subject                 `json:"subject,omitempty"`
+	BlockListRefs    []dnsv1beta1.DomainListReference    `json:"blockListRefs,omitempty"`
+	AllowListRefs    []dnsv1beta1.DomainListReference    `json:"allowListRefs,omitempty"`
+	Export           *dnsv1beta1.PolicyExport            `json:"export,omitempty"`
+	NetworkPolicy    *dnsv1beta1.NetworkPolicyGeneration `json:"networkPolicy,omitempty"`
+	NetworkPolicies  []dnsv1beta1.GeneratedObject        `json:"networkPolicies,omitempty"`
+}
+
+// Subject keys of a v1alpha1 subject map that v1beta1 understands.
+const (
+	subjectServiceAccount = "serviceAccount"
+	subjectNamespace      = "namespace"
+)
+
+// ConvertTo converts this DnsPolicy to the Hub version (v1beta1).
+// Every bare BlockList and AllowList string becomes a DomainRule, and
+// RuleActions are folded into the matching BlockList rules. Subject keys
+// without a v1beta1 field are kept in the IgnoredSubjectKeysAnnotation.
+func (src *DnsPolicy) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*dnsv1beta1.DnsPolicy)
+
+	dst.ObjectMeta = src.ObjectMeta
+
+	var saved savedSpec
+	if data, ok := src.Annotations[specAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &saved); err != nil {
+			return fmt.Errorf("failed to decode %s annotation: %w", specAnnotation, err)
+		}
+		dst.Annotations = maps.Clone(src.Annotations)
+		delete(dst.Annotations, specAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	// Spec
+	dst.Spec.TargetSelector = nil
+	if len(src.Spec.TargetSelector) > 0 || len(saved.MatchExpressions) > 0 {
+		dst.Spec.TargetSelector = &metav1.LabelSelector{
+			MatchLabels:      src.Spec.TargetSelector,
+			MatchExpressions: saved.MatchExpressions,
+		}
+	}
+	dst.Spec.Subject = convertSubjectTo(src.Spec.Subject)
+	if saved.Subject != nil {
+		dst.Spec.Subject = saved.Subject
+	}
+	if err := saveIgnoredSubjectKeys(src.Spec.Subject, &dst.ObjectMeta); err != nil {
+		return err
+	}
+	dst.Spec.DryRun = src.Spec.DryRun
+	dst.Spec.DefaultAction = dnsv1beta1.DefaultAction(src.Spec.DefaultAction)
+	dst.Spec.Action = convertActionTo(src.Spec.Action)
+	dst.Spec.Priority = saved.Priority
+	dst.Spec.MergeMode = saved.MergeMode
+	dst.Spec.BlockListRefs = saved.BlockListRefs
+	dst.Spec.AllowListRefs = saved.AllowListRefs
+	dst.Spec.Export = saved.Export
+	dst.Spec.NetworkPolicy = saved.NetworkPolicy
+
+	ruleActions := make(map[string]*BlockAction, len(src.Spec.RuleActions))
+	for i := range src.Spec.RuleActions {
+		ruleActions[ruleKey(src.Spec.RuleActions[i].Pattern)] = &src.Spec.RuleActions[i].Action
+	}
+	dst.Spec.BlockList = nil
+	for _, pattern := range src.Spec.BlockList {
+		rule := restoreRule(pattern, saved.BlockList)
+		rule.Action = convertActionTo(ruleActions[ruleKey(pattern)])
+		dst.Spec.BlockList = append(dst.Spec.BlockList, rule)
+	}
+	dst.Spec.AllowList = nil
+	for _, pattern := range src.Spec.AllowList {
+		dst.Spec.AllowList = append(dst.Spec.AllowList, restoreRule(pattern, saved.AllowList))
+	}
+
+	// Status
+	dst.Status.SelectorHash = src.Status.SelectorHash
+	dst.Status.SpecHash = src.Status.SpecHash
+	dst.Status.OverlappingRules = src.Status.OverlappingRules
+	dst.Status.ResolvedAction = convertActionTo(src.Status.ResolvedAction)
+	dst.Status.ObservedGeneration = src.Status.ObservedGeneration
+	dst.Status.Conditions = src.Status.Conditions
+	dst.Status.NetworkPolicies = saved.NetworkPolicies
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+// TargetSelector matchExpressions, priority, a non-default mergeMode,
+// subjects with several service accounts or a namespaceSelector, DomainList
+// references, exports, and rule fields without a v1alpha1 equivalent (matchType,
+// qtypes, description and expiresAt) are kept in the specAnnotation, as are
+// the generated NetworkPolicies listed in the status.
+func (dst *DnsPolicy) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*dnsv1beta1.DnsPolicy)
+
+	dst.ObjectMeta = src.ObjectMeta
+
+	var matchExpressions []metav1.LabelSelectorRequirement
+	if src.Spec.TargetSelector != nil {
+		matchExpressions = src.Spec.TargetSelector.MatchExpressions
+	}
+	saved := savedSpec{
+		MatchExpressions: matchExpressions,
+		Priority:         src.Spec.Priority,
+		BlockListRefs:    src.Spec.BlockListRefs,
+		AllowListRefs:    src.Spec.AllowListRefs,
+		Export:           src.Spec.Export,
+		NetworkPolicy:    src.Spec.NetworkPolicy,
+		NetworkPolicies:  src.Status.NetworkPolicies,
+	}
+	if src.Spec.MergeMode != dnsv1beta1.MergeModeAppend {
+		saved.MergeMode = src.Spec.MergeMode
+	}
+	if subject := src.Spec.Subject; subject != nil && (len(subject.ServiceAccounts) > 0 || subject.NamespaceSelector != nil) {
+		saved.Subject = subject
+	}
+	if needsSavedRules(src.Spec.BlockList, true) || needsSavedRules(src.Spec.AllowList, false) {
+		saved.BlockList = src.Spec.BlockList
+		saved.AllowList = src.Spec.AllowList
+	}
+	if len(saved.MatchExpressions) > 0 || saved.Priority != 0 || saved.MergeMode != "" || saved.Subject != nil ||
+		saved.BlockList != nil || saved.AllowList != nil || saved.BlockListRefs != nil || saved.AllowListRefs != nil ||
+		saved.Export != nil || saved.NetworkPolicy != nil || saved.NetworkPolicies != nil {
+		data, err := json.Marshal(saved)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s annotation: %w", specAnnotation, err)
+		}
+		dst.Annotations = maps.Clone(src.Annotations)
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[specAnnotation] = string(data)
+	}
+
+	// Spec
+	dst.Spec.TargetSelector = nil
+	if src.Spec.TargetSelector != nil {
+		dst.Spec.TargetSelector = src.Spec.TargetSelector.MatchLabels
+	}
+	dst.Spec.Subject = convertSubjectFrom(src.Spec.Subject)
+	if err := restoreIgnoredSubjectKeys(&dst.ObjectMeta, &dst.Spec.Subject); err != nil {
+		return err
+	}
+	dst.Spec.DryRun = src.Spec.DryRun
+	dst.Spec.DefaultAction = DefaultAction(src.Spec.DefaultAction)
+	dst.Spec.Action = convertActionFrom(src.Spec.Action)
+
+	dst.Spec.BlockList = nil
+	dst.Spec.RuleActions = nil
+	for _, rule := range src.Spec.BlockList {
+		dst.Spec.BlockList = append(dst.Spec.BlockList, rule.Pattern)
+		if rule.Action != nil {
+			dst.Spec.RuleActions = append(dst.Spec.RuleActions, RuleAction{
+				Pattern: rule.Pattern,
+				Action:  *convertActionFrom(rule.Action),
+			})
+		}
+	}
+	dst.Spec.AllowList = nil
+	for _, rule := range src.Spec.AllowList {
+		dst.Spec.AllowList = append(dst.Spec.AllowList, rule.Pattern)
+	}
+
+	// Status
+	dst.Status.SelectorHash = src.Status.SelectorHash
+	dst.Status.SpecHash = src.Status.SpecHash
+	dst.Status.OverlappingRules = src.Status.OverlappingRules
+	dst.Status.ResolvedAction = convertActionFrom(src.Status.ResolvedAction)
+	dst.Status.ObservedGeneration = src.Status.ObservedGeneration
+	dst.Status.Conditions = src.Status.Conditions
+
+	return nil
+}
+
+// needsSavedRules reports whether any rule carries fields that a bare
+// v1alpha1 pattern string cannot express. Actions on BlockList rules are
+// carried by RuleActions and do not count.
+func needsSavedRules(rules []dnsv1beta1.DomainRule, isBlockList bool) bool {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.MatchType != "" || len(rule.QTypes) > 0 || rule.Description != "" || rule.ExpiresAt != nil {
+			return true
+		}
+		if !isBlockList && rule.Action != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreRule builds the v1beta1 rule for a v1alpha1 pattern, taking the
+// extra fields from the first saved rule with the same pattern. The saved
+// rule is consumed so duplicate patterns restore in order.
+func restoreRule(pattern string, saved []dnsv1beta1.DomainRule) dnsv1beta1.DomainRule {
+	for i := range saved {
+		if saved[i].Pattern == pattern {
+			rule := *saved[i].DeepCopy()
+			saved[i].Pattern = ""
+			return rule
+		}
+	}
+	return dnsv1beta1.DomainRule{Pattern: pattern}
+}
+
+// ruleKey matches RuleActions to BlockList entries case-insensitively and
+// regardless of a trailing dot.
+func ruleKey(pattern string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), ".")
+}
+
+// convertSubjectTo converts a v1alpha1 subject map. Keys other than
+// serviceAccount and namespace never matched a pod; saveIgnoredSubjectKeys
+// keeps them.
+func convertSubjectTo(src map[string]string) *dnsv1beta1.Subject {
+	subject := &dnsv1beta1.Subject{
+		ServiceAccount: src[subjectServiceAccount],
+		Namespace:      src[subjectNamespace],
+	}
+	if subject.IsEmpty() {
+		return nil
+	}
+	return subject
+}
+
+// saveIgnoredSubjectKeys records the subject keys that convertSubjectTo
+// drops in the IgnoredSubjectKeysAnnotation of the converted object.
+func saveIgnoredSubjectKeys(src map[string]string, dst *metav1.ObjectMeta) error {
+	ignored := maps.Clone(src)
+	delete(ignored, subjectServiceAccount)
+	delete(ignored, subjectNamespace)
+	if _, ok := dst.Annotations[dnsv1beta1.IgnoredSubjectKeysAnnotation]; !ok && len(ignored) == 0 {
+		return nil
+	}
+
+	dst.Annotations = maps.Clone(dst.Annotations)
+	delete(dst.Annotations, dnsv1beta1.IgnoredSubjectKeysAnnotation)
+	if len(ignored) > 0 {
+		data, err := json.Marshal(ignored)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s annotation: %w", dnsv1beta1.IgnoredSubjectKeysAnnotation, err)
+		}
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[dnsv1beta1.IgnoredSubjectKeysAnnotation] = string(data)
+	}
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+	return nil
+}
+
+// restoreIgnoredSubjectKeys moves the IgnoredSubjectKeysAnnotation of a
+// converted object back into its subject map.
+func restoreIgnoredSubjectKeys(dst *metav1.ObjectMeta, subject *map[string]string) error {
+	data, ok := dst.Annotations[dnsv1beta1.IgnoredSubjectKeysAnnotation]
+	if !ok {
+		return nil
+	}
+	var ignored map[string]string
+	if err := json.Unmarshal([]byte(data), &ignored); err != nil {
+		return fmt.Errorf("failed to decode %s annotation: %w", dnsv1beta1.IgnoredSubjectKeysAnnotation, err)
+	}
+
+	dst.Annotations = maps.Clone(dst.Annotations)
+	delete(dst.Annotations, dnsv1beta1.IgnoredSubjectKeysAnnotation)
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+	for key, value := range ignored {
+		if *subject == nil {
+			*subject = map[string]string{}
+		}
+		(*subject)[key] = value
+	}
+	return nil
+}
+
+// convertSubjectFrom converts a v1beta1 subject to a map. Only the first
+// service account fits; the full subject is kept in the specAnnotation.
+func convertSubjectFrom(src *dnsv1beta1.Subject) map[string]string {
+	if src == nil {
+		return nil
+	}
+	subject := map[string]string{}
+	if names := src.ServiceAccountNames(); len(names) > 0 {
+		subject[subjectServiceAccount] = names[0]
+	}
+	if src.Namespace != "" {
+		subject[subjectNamespace] = src.Namespace
+	}
+	if len(subject) == 0 {
+		return nil
+	}
+	return subject
+}
+
+func convertActionTo(src *BlockAction) *dnsv1beta1.BlockAction {
+	if src == nil {
+		return nil
+	}
+	return &dnsv1beta1.BlockAction{
+		Type:         dnsv1beta1.BlockActionType(src.Type),
+		SinkholeIPv4: src.SinkholeIPv4,
+		SinkholeIPv6: src.SinkholeIPv6,
+	}
+}
+
+func convertActionFrom(src *dnsv1beta1.BlockAction) *BlockAction {
+	if src == nil {
+		return nil
+	}
+	return &BlockAction{
+		Type:         BlockActionType(src.Type),
+		SinkholeIPv4: src.SinkholeIPv4,
+		SinkholeIPv6: src.SinkholeIPv6,
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("DnsPolicy conversion", func() {
+	var status DnsPolicyStatus
+
+	BeforeEach(func() {
+		status = DnsPolicyStatus{
+			SelectorHash:       "selector",
+			SpecHash:           "spec",
+			OverlappingRules:   []string{"api.example.com"},
+			ResolvedAction:     &BlockAction{Type: BlockActionRefused},
+			ObservedGeneration: 3,
+			Conditions: []metav1.Condition{{
+				Type:               "Ready",
+				Status:             metav1.ConditionTrue,
+				Reason:             "Reconciled",
+				Message:            "DnsPolicy successfully reconciled",
+				LastTransitionTime: metav1.NewTime(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)),
+			}},
+		}
+	})
+
+	It("should round-trip every v1alpha1 field through v1beta1", func() {
+		original := &DnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "payments",
+				Namespace:   "default",
+				Annotations: map[string]string{"team": "payments"},
+			},
+			Spec: DnsPolicySpec{
+				TargetSelector: map[string]string{"app": "payments"},
+				Subject:        map[string]string{"serviceAccount": "payments"},
+				DryRun:         true,
+				BlockList:      []string{"*.ads.com", "tracking.ads.net"},
+				AllowList:      []string{"api.stripe.com"},
+				DefaultAction:  DefaultActionDeny,
+				Action:         &BlockAction{Type: BlockActionRefused},
+				RuleActions: []RuleAction{{
+					Pattern: "*.ads.com",
+					Action:  BlockAction{Type: BlockActionSinkhole, SinkholeIPv4: "10.0.0.53"},
+				}},
+			},
+			Status: status,
+		}
+
+		hub := &dnsv1beta1.DnsPolicy{}
+		Expect(original.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Spec.DryRun).To(BeTrue())
+		Expect(hub.Spec.Subject).To(Equal(&dnsv1beta1.Subject{ServiceAccount: "payments"}))
+		Expect(hub.Spec.BlockList).To(HaveLen(2))
+		Expect(hub.Spec.BlockList[0].Action).To(Equal(&dnsv1beta1.BlockAction{
+			Type: dnsv1beta1.BlockActionSinkhole, SinkholeIPv4: "10.0.0.53",
+		}))
+		Expect(hub.Spec.BlockList[1].Action).To(BeNil())
+
+		converted := &DnsPolicy{}
+		Expect(converted.ConvertFrom(hub)).To(Succeed())
+		Expect(converted).To(BeComparableTo(original))
+	})
+
+	It("should round-trip every v1beta1 field through v1alpha1", func() {
+		expiry := metav1.NewTime(time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC))
+		original := &dnsv1beta1.DnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "payments",
+				Namespace:   "default",
+				Annotations: map[string]string{"team": "payments"},
+			},
+			Spec: dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "payments"},
+					MatchExpressions: []metav1.LabelSelectorRequirement{{
+						Key:      "tier",
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   []string{"web", "edge"},
+					}},
+				},
+				Subject: &dnsv1beta1.Subject{ServiceAccount: "payments", ServiceAccounts: []string{"payments-batch"}},
+				DryRun:  true,
+				BlockList: []dnsv1beta1.DomainRule{
+					{
+						Pattern:     "ads.example.com",
+						MatchType:   dnsv1beta1.MatchTypeSuffix,
+						QTypes:      []string{"A", "AAAA"},
+						Action:      &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionNoData},
+						Description: "ad network",
+						ExpiresAt:   &expiry,
+					},
+					{Pattern: `^tracker[0-9]+\.io$`, MatchType: dnsv1beta1.MatchTypeRegex},
+					{Pattern: "*.ads.net"},
+				},
+				AllowList: []dnsv1beta1.DomainRule{
+					{Pattern: "api.stripe.com", Description: "card processing"},
+				},
+				BlockListRefs: []dnsv1beta1.DomainListReference{{Name: "ads"}},
+				AllowListRefs: []dnsv1beta1.DomainListReference{{Kind: dnsv1beta1.DomainListKindCluster, Name: "partners"}},
+				DefaultAction: dnsv1beta1.DefaultActionDeny,
+				Action:        &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionRefused},
+				Priority:      100,
+				MergeMode:     dnsv1beta1.MergeModeOverride,
+				Export: &dnsv1beta1.PolicyExport{
+					RPZ: &dnsv1beta1.RPZExport{Zone: "rpz.example.com", ConfigMapName: "payments-rpz"},
+				},
+				NetworkPolicy: &dnsv1beta1.NetworkPolicyGeneration{
+					Providers: []dnsv1beta1.NetworkPolicyProvider{dnsv1beta1.NetworkPolicyProviderCilium},
+				},
+			},
+		}
+		hubStatus := &dnsv1beta1.DnsPolicy{}
+		Expect((&DnsPolicy{Status: status}).ConvertTo(hubStatus)).To(Succeed())
+		original.Status = hubStatus.Status
+		original.Status.NetworkPolicies = []dnsv1beta1.GeneratedObject{
+			{APIVersion: "cilium.io/v2", Kind: "CiliumNetworkPolicy", Name: "dnspolicy-payments"},
+		}
+
+		spoke := &DnsPolicy{}
+		Expect(spoke.ConvertFrom(original)).To(Succeed())
+		Expect(spoke.Spec.BlockList).To(Equal([]string{"ads.example.com", `^tracker[0-9]+\.io$`, "*.ads.net"}))
+		Expect(spoke.Spec.RuleActions).To(HaveLen(1))
+		Expect(spoke.Annotations).To(HaveKey(specAnnotation))
+		Expect(original.Annotations).NotTo(HaveKey(specAnnotation))
+
+		converted := &dnsv1beta1.DnsPolicy{}
+		Expect(spoke.ConvertTo(converted)).To(Succeed())
+		Expect(converted).To(BeComparableTo(original))
+	})
+
+	It("should keep subject keys that v1beta1 cannot express", func() {
+		original := &DnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "payments", Namespace: "default"},
+			Spec: DnsPolicySpec{
+				Subject: map[string]string{"serviceAccount": "payments", "team": "billing", "tier": "web"},
+			},
+		}
+
+		hub := &dnsv1beta1.DnsPolicy{}
+		Expect(original.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Spec.Subject).To(Equal(&dnsv1beta1.Subject{ServiceAccount: "payments"}))
+		Expect(hub.Annotations).To(HaveKeyWithValue(dnsv1beta1.IgnoredSubjectKeysAnnotation,
+			`{"team":"billing","tier":"web"}`))
+		Expect(original.Annotations).To(BeNil())
+
+		converted := &DnsPolicy{}
+		Expect(converted.ConvertFrom(hub)).To(Succeed())
+		Expect(converted).To(BeComparableTo(original))
+		Expect(hub.Annotations).To(HaveKey(dnsv1beta1.IgnoredSubjectKeysAnnotation))
+
+		By("dropping the annotation once the keys are removed")
+		converted.Spec.Subject = map[string]string{"serviceAccount": "payments"}
+		converted.Annotations = hub.Annotations
+		Expect(converted.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Annotations).To(BeNil())
+	})
+
+	It("should not add the rules annotation when bare strings are enough", func() {
+		hub := &dnsv1beta1.DnsPolicy{
+			Spec: dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				BlockList:      []dnsv1beta1.DomainRule{{Pattern: "*.malicious-site.com"}},
+			},
+		}
+
+		spoke := &DnsPolicy{}
+		Expect(spoke.ConvertFrom(hub)).To(Succeed())
+		Expect(spoke.Annotations).To(BeEmpty())
+		Expect(spoke.Spec.BlockList).To(Equal([]string{"*.malicious-site.com"}))
+	})
+})
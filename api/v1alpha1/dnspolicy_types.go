@@ -23,6 +23,55 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// DefaultAction is the verdict applied to queries that match neither the
+// AllowList nor the BlockList of a policy.
+// +kubebuilder:validation:Enum=Allow;Deny
+type DefaultAction string
+
+const (
+	// DefaultActionAllow resolves every name that is not blocked.
+	DefaultActionAllow DefaultAction = "Allow"
+	// DefaultActionDeny blocks every name that is not explicitly allowed.
+	DefaultActionDeny DefaultAction = "Deny"
+)
+
+// BlockActionType is the kind of answer returned for a blocked query.
+// +kubebuilder:validation:Enum=NXDOMAIN;REFUSED;NODATA;Sinkhole
+type BlockActionType string
+
+const (
+	// BlockActionNXDomain answers blocked queries with NXDOMAIN.
+	BlockActionNXDomain BlockActionType = "NXDOMAIN"
+	// BlockActionRefused answers blocked queries with REFUSED.
+	BlockActionRefused BlockActionType = "REFUSED"
+	// BlockActionNoData answers blocked queries with NOERROR and an empty answer section.
+	BlockActionNoData BlockActionType = "NODATA"
+	// BlockActionSinkhole answers blocked queries with the configured sinkhole addresses.
+	BlockActionSinkhole BlockActionType = "Sinkhole"
+)
+
+// BlockAction describes how the sidecar answers a blocked query.
+type BlockAction struct {
+	// Type is the kind of answer returned for a blocked query.
+	// +kubebuilder:default=NXDOMAIN
+	// +optional
+	Type BlockActionType `json:"type,omitempty"`
+	// SinkholeIPv4 is returned for A queries when Type is Sinkhole.
+	// +optional
+	SinkholeIPv4 string `json:"sinkholeIPv4,omitempty"`
+	// SinkholeIPv6 is returned for AAAA queries when Type is Sinkhole.
+	// +optional
+	SinkholeIPv6 string `json:"sinkholeIPv6,omitempty"`
+}
+
+// RuleAction overrides the policy action for a single BlockList pattern.
+type RuleAction struct {
+	// Pattern is the BlockList entry this action applies to.
+	Pattern string `json:"pattern"`
+	// Action is how queries matching Pattern are answered.
+	Action BlockAction `json:"action"`
+}
+
 // DnsPolicySpec defines the desired state of DnsPolicy.
 type DnsPolicySpec struct {
 	// TargetSelector specifies the labels to match pods this policy applies to.
@@ -32,6 +81,21 @@ type DnsPolicySpec struct {
 	// BlockList contains domain patterns that are blocked from DNS resolution.
 	// +optional
 	BlockList []string `json:"blockList,omitempty"`
+	// AllowList contains domain patterns that are allowed for DNS resolution.
+	// A name present in both lists is blocked: BlockList takes precedence.
+	// +optional
+	AllowList []string `json:"allowList,omitempty"`
+	// DefaultAction decides queries that match neither AllowList nor BlockList.
+	// Use Deny to lock a workload to the names in AllowList.
+	// +kubebuilder:default=Allow
+	// +optional
+	DefaultAction DefaultAction `json:"defaultAction,omitempty"`
+	// Action is how blocked queries are answered. Defaults to NXDOMAIN.
+	// +optional
+	Action *BlockAction `json:"action,omitempty"`
+	// RuleActions overrides Action for individual BlockList patterns.
+	// +optional
+	RuleActions []RuleAction `json:"ruleActions,omitempty"`
 
 	Subject map[string]string `json:"subject,omitempty"`
 	// +optional
@@ -50,6 +114,16 @@ type DnsPolicyStatus struct {
 	// +optional
 	SpecHash string `json:"specHash,omitempty"`
 
+	// OverlappingRules lists patterns that appear in both AllowList and BlockList.
+	// BlockList takes precedence, so these names are always blocked.
+	// +optional
+	OverlappingRules []string `json:"overlappingRules,omitempty"`
+
+	// ResolvedAction is the policy-wide block action after defaulting.
+	// Sidecars apply it to blocked names without an entry in RuleActions.
+	// +optional
+	ResolvedAction *BlockAction `json:"resolvedAction,omitempty"`
+
 	// ObservedGeneration is the generation observed by the controller.
 	// +optional
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
@@ -63,6 +137,7 @@ type DnsPolicyStatus struct {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:deprecatedversion:warning="dns.dnspolicies.io/v1alpha1 DnsPolicy is deprecated; use dns.dnspolicies.io/v1beta1"
 
 // DnsPolicy is the Schema for the dnspolicies API.
 type DnsPolicy struct {
@@ -25,6 +25,21 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlockAction) DeepCopyInto(out *BlockAction) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlockAction.
+func (in *BlockAction) DeepCopy() *BlockAction {
+	if in == nil {
+		return nil
+	}
+	out := new(BlockAction)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DnsPolicy) DeepCopyInto(out *DnsPolicy) {
 	*out = *in
@@ -99,6 +114,21 @@ func (in *DnsPolicySpec) DeepCopyInto(out *DnsPolicySpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.AllowList != nil {
+		in, out := &in.AllowList, &out.AllowList
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Action != nil {
+		in, out := &in.Action, &out.Action
+		*out = new(BlockAction)
+		**out = **in
+	}
+	if in.RuleActions != nil {
+		in, out := &in.RuleActions, &out.RuleActions
+		*out = make([]RuleAction, len(*in))
+		copy(*out, *in)
+	}
 	if in.Subject != nil {
 		in, out := &in.Subject, &out.Subject
 		*out = make(map[string]string, len(*in))
@@ -121,6 +151,16 @@ func (in *DnsPolicySpec) DeepCopy() *DnsPolicySpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DnsPolicyStatus) DeepCopyInto(out *DnsPolicyStatus) {
 	*out = *in
+	if in.OverlappingRules != nil {
+		in, out := &in.OverlappingRules, &out.OverlappingRules
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ResolvedAction != nil {
+		in, out := &in.ResolvedAction, &out.ResolvedAction
+		*out = new(BlockAction)
+		**out = **in
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]v1.Condition, len(*in))
@@ -139,3 +179,19 @@ func (in *DnsPolicyStatus) DeepCopy() *DnsPolicyStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RuleAction) DeepCopyInto(out *RuleAction) {
+	*out = *in
+	out.Action = in.Action
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuleAction.
+func (in *RuleAction) DeepCopy() *RuleAction {
+	if in == nil {
+		return nil
+	}
+	out := new(RuleAction)
+	in.DeepCopyInto(out)
+	return out
+}
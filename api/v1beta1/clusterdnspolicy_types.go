@@ -0,0 +1,119 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterDnsPolicySpec defines the desired state of ClusterDnsPolicy.
+type ClusterDnsPolicySpec struct {
+	// NamespaceSelector selects the namespaces this policy applies to.
+	// When unset the policy applies to every namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// TargetSelector selects the pods this policy applies to within the
+	// selected namespaces. When unset the policy applies to every pod.
+	// +optional
+	TargetSelector *metav1.LabelSelector `json:"targetSelector,omitempty"`
+	// Subject narrows the policy to pods with the given identity. Its
+	// namespace and namespaceSelector further restrict NamespaceSelector.
+	// +optional
+	Subject *Subject `json:"subject,omitempty"`
+	// BlockList contains rules for names that are blocked from DNS resolution.
+	// +optional
+	BlockList []DomainRule `json:"blockList,omitempty"`
+	// AllowList contains rules for names that are allowed for DNS resolution.
+	// A name matching both lists is blocked: BlockList takes precedence.
+	// +optional
+	AllowList []DomainRule `json:"allowList,omitempty"`
+	// DefaultAction decides queries that match neither AllowList nor BlockList.
+	// +kubebuilder:default=Allow
+	// +optional
+	DefaultAction DefaultAction `json:"defaultAction,omitempty"`
+	// Action is how blocked queries are answered. Defaults to NXDOMAIN.
+	// +optional
+	Action *BlockAction `json:"action,omitempty"`
+	// +optional
+	DryRun bool `json:"dryrun,omitempty"`
+	// Priority orders policies that apply to the same pods. Higher values
+	// are merged first and win conflicting rules.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+	// MergeMode is how this policy combines with lower-priority policies.
+	// Override ignores them entirely. Defaults to Append.
+	// +kubebuilder:default=Append
+	// +optional
+	MergeMode MergeMode `json:"mergeMode,omitempty"`
+}
+
+// ClusterDnsPolicyStatus defines the observed state of ClusterDnsPolicy.
+type ClusterDnsPolicyStatus struct {
+	// SpecHash is the hash of the entire Spec for change detection.
+	// +optional
+	SpecHash string `json:"specHash,omitempty"`
+
+	// MatchedNamespaces is the number of namespaces selected by NamespaceSelector.
+	// +optional
+	MatchedNamespaces int32 `json:"matchedNamespaces,omitempty"`
+
+	// OverlappingRules lists patterns that appear in both AllowList and BlockList.
+	// BlockList takes precedence, so these names are always blocked.
+	// +optional
+	OverlappingRules []string `json:"overlappingRules,omitempty"`
+
+	// ResolvedAction is the policy-wide block action after defaulting.
+	// +optional
+	ResolvedAction *BlockAction `json:"resolvedAction,omitempty"`
+
+	// ObservedGeneration is the generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the ClusterDnsPolicy's state.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+
+// ClusterDnsPolicy is the Schema for the clusterdnspolicies API.
+// It applies a baseline policy to pods across namespaces.
+type ClusterDnsPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterDnsPolicySpec   `json:"spec,omitempty"`
+	Status ClusterDnsPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterDnsPolicyList contains a list of ClusterDnsPolicy.
+type ClusterDnsPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterDnsPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterDnsPolicy{}, &ClusterDnsPolicyList{})
+}
@@ -0,0 +1,375 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultAction is the verdict applied to queries that match neither the
+// AllowList nor the BlockList of a policy.
+// +kubebuilder:validation:Enum=Allow;Deny
+type DefaultAction string
+
+const (
+	// DefaultActionAllow resolves every name that is not blocked.
+	DefaultActionAllow DefaultAction = "Allow"
+	// DefaultActionDeny blocks every name that is not explicitly allowed.
+	DefaultActionDeny DefaultAction = "Deny"
+)
+
+// BlockActionType is the kind of answer returned for a blocked query.
+// +kubebuilder:validation:Enum=NXDOMAIN;REFUSED;NODATA;Sinkhole
+type BlockActionType string
+
+const (
+	// BlockActionNXDomain answers blocked queries with NXDOMAIN.
+	BlockActionNXDomain BlockActionType = "NXDOMAIN"
+	// BlockActionRefused answers blocked queries with REFUSED.
+	BlockActionRefused BlockActionType = "REFUSED"
+	// BlockActionNoData answers blocked queries with NOERROR and an empty answer section.
+	BlockActionNoData BlockActionType = "NODATA"
+	// BlockActionSinkhole answers blocked queries with the configured sinkhole addresses.
+	BlockActionSinkhole BlockActionType = "Sinkhole"
+)
+
+// BlockAction describes how the sidecar answers a blocked query.
+type BlockAction struct {
+	// Type is the kind of answer returned for a blocked query.
+	// +kubebuilder:default=NXDOMAIN
+	// +optional
+	Type BlockActionType `json:"type,omitempty"`
+	// SinkholeIPv4 is returned for A queries when Type is Sinkhole.
+	// +optional
+	SinkholeIPv4 string `json:"sinkholeIPv4,omitempty"`
+	// SinkholeIPv6 is returned for AAAA queries when Type is Sinkhole.
+	// +optional
+	SinkholeIPv6 string `json:"sinkholeIPv6,omitempty"`
+}
+
+// MergeMode is how a policy combines with lower-priority policies that
+// apply to the same pods.
+// +kubebuilder:validation:Enum=Append;Override
+type MergeMode string
+
+const (
+	// MergeModeAppend adds the policy's rules to those of lower-priority policies.
+	MergeModeAppend MergeMode = "Append"
+	// MergeModeOverride replaces lower-priority policies, which are then shadowed.
+	MergeModeOverride MergeMode = "Override"
+)
+
+// MatchType is how a rule pattern is compared to a query name.
+// +kubebuilder:validation:Enum=Exact;Suffix;Wildcard;Regex
+type MatchType string
+
+const (
+	// MatchTypeExact matches the pattern itself only.
+	MatchTypeExact MatchType = "Exact"
+	// MatchTypeSuffix matches the pattern and every name below it.
+	MatchTypeSuffix MatchType = "Suffix"
+	// MatchTypeWildcard matches the pattern with '*' standing for any labels.
+	MatchTypeWildcard MatchType = "Wildcard"
+	// MatchTypeRegex matches names against the pattern as an RE2 expression.
+	MatchTypeRegex MatchType = "Regex"
+)
+
+// DomainRule is a single allow or block entry of a policy.
+type DomainRule struct {
+	// Pattern is the domain, wildcard or expression to match.
+	// +kubebuilder:validation:MinLength=1
+	Pattern string `json:"pattern"`
+	// MatchType is how Pattern is compared to the query name.
+	// When empty it is Wildcard for patterns containing '*' and Exact otherwise.
+	// +optional
+	MatchType MatchType `json:"matchType,omitempty"`
+	// QTypes restricts the rule to these query types, e.g. A, AAAA or TXT.
+	// An empty list matches every query type.
+	// +kubebuilder:validation:items:Pattern=`^[A-Z][A-Z0-9]*$`
+	// +optional
+	QTypes []string `json:"qtypes,omitempty"`
+	// Action overrides the policy action for blocked names matching this rule.
+	// Only valid on BlockList entries.
+	// +optional
+	Action *BlockAction `json:"action,omitempty"`
+	// Description is a free-form note explaining why the rule exists.
+	// +optional
+	Description string `json:"description,omitempty"`
+	// ExpiresAt is the time after which the rule no longer applies.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+}
+
+// EffectiveMatchType returns the rule's MatchType, inferring it from the
+// pattern when unset.
+func (r *DomainRule) EffectiveMatchType() MatchType {
+	if r.MatchType != "" {
+		return r.MatchType
+	}
+	return InferMatchType(r.Pattern)
+}
+
+// InferMatchType returns the match type implied by a bare pattern string.
+func InferMatchType(pattern string) MatchType {
+	if strings.Contains(pattern, "*") {
+		return MatchTypeWildcard
+	}
+	return MatchTypeExact
+}
+
+// DomainListKind is the kind of a list referenced by a policy.
+// +kubebuilder:validation:Enum=DomainList;ClusterDomainList
+type DomainListKind string
+
+const (
+	// DomainListKindNamespaced refers to a DomainList in the policy's namespace.
+	DomainListKindNamespaced DomainListKind = "DomainList"
+	// DomainListKindCluster refers to a ClusterDomainList.
+	DomainListKindCluster DomainListKind = "ClusterDomainList"
+)
+
+// DomainListReference names a DomainList or ClusterDomainList whose rules
+// are added to a policy.
+type DomainListReference struct {
+	// Kind is DomainList for a list in the policy's namespace or
+	// ClusterDomainList. Defaults to DomainList.
+	// +kubebuilder:default=DomainList
+	// +optional
+	Kind DomainListKind `json:"kind,omitempty"`
+	// Name is the name of the list.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// EffectiveKind returns the reference's Kind, defaulting to DomainList.
+func (r *DomainListReference) EffectiveKind() DomainListKind {
+	if r.Kind != "" {
+		return r.Kind
+	}
+	return DomainListKindNamespaced
+}
+
+// Subject selects pods by identity. Every field that is set must match;
+// a pod matches the service accounts if it runs as any of them.
+type Subject struct {
+	// ServiceAccount selects pods running as this service account.
+	// +optional
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	// ServiceAccounts selects pods running as any of these service accounts.
+	// +optional
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"`
+	// Namespace selects pods in this namespace.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// NamespaceSelector selects pods in namespaces whose labels match.
+	// Only supported in ClusterDnsPolicy; a DnsPolicy never applies outside
+	// its own namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// IgnoredSubjectKeysAnnotation holds, as a JSON object, the entries of a
+// v1alpha1 subject map whose keys have no Subject field. They never matched
+// a pod; the annotation keeps them for v1alpha1 clients and lets the
+// controller report them.
+const IgnoredSubjectKeysAnnotation = "dns.dnspolicies.io/v1alpha1-subject-keys"
+
+// IsEmpty reports whether the subject is unset or selects nothing by identity.
+func (s *Subject) IsEmpty() bool {
+	return s == nil || (s.ServiceAccount == "" && len(s.ServiceAccounts) == 0 &&
+		s.Namespace == "" && s.NamespaceSelector == nil)
+}
+
+// ServiceAccountNames returns ServiceAccount and ServiceAccounts as one
+// list. An empty list means the subject does not restrict service accounts.
+func (s *Subject) ServiceAccountNames() []string {
+	if s == nil {
+		return nil
+	}
+	var names []string
+	if s.ServiceAccount != "" {
+		names = append(names, s.ServiceAccount)
+	}
+	return append(names, s.ServiceAccounts...)
+}
+
+// PolicyExport renders a policy for resolvers that do not run the sidecar.
+type PolicyExport struct {
+	// RPZ writes the policy as a Response Policy Zone into a ConfigMap.
+	// +optional
+	RPZ *RPZExport `json:"rpz,omitempty"`
+}
+
+// RPZExport writes a policy as an RPZ zone file into a ConfigMap of the
+// policy's namespace, for CoreDNS or BIND servers that load it.
+type RPZExport struct {
+	// Zone is the origin of the response policy zone. Defaults to rpz.local.
+	// +optional
+	Zone string `json:"zone,omitempty"`
+	// ConfigMapName names the ConfigMap the zone file is written to. The
+	// controller creates it and deletes it with the policy.
+	// +kubebuilder:validation:MinLength=1
+	ConfigMapName string `json:"configMapName"`
+	// Key is the ConfigMap key holding the zone file. Defaults to db.rpz.
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// NetworkPolicyProvider is a CNI that enforces FQDN egress policies.
+// +kubebuilder:validation:Enum=Cilium;Calico
+type NetworkPolicyProvider string
+
+const (
+	// NetworkPolicyProviderCilium generates a CiliumNetworkPolicy.
+	NetworkPolicyProviderCilium NetworkPolicyProvider = "Cilium"
+	// NetworkPolicyProviderCalico generates a projectcalico.org/v3 NetworkPolicy.
+	// Its domain rules require Calico Enterprise.
+	NetworkPolicyProviderCalico NetworkPolicyProvider = "Calico"
+)
+
+// NetworkPolicyGeneration generates CNI network policies that only let the
+// selected pods connect to the allowed names, so that connecting to a
+// hard-coded address does not bypass the policy.
+type NetworkPolicyGeneration struct {
+	// Providers lists the CNIs to generate network policies for.
+	// +kubebuilder:validation:MinItems=1
+	// +listType=set
+	Providers []NetworkPolicyProvider `json:"providers"`
+}
+
+// GeneratedObject identifies an object the controller generated from a policy.
+type GeneratedObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// DnsPolicySpec defines the desired state of DnsPolicy.
+type DnsPolicySpec struct {
+	// TargetSelector selects the pods this policy applies to.
+	// Both matchLabels and matchExpressions are supported.
+	// +optional
+	TargetSelector *metav1.LabelSelector `json:"targetSelector,omitempty"`
+	// Subject selects pods by identity, e.g. serviceAccount. When both
+	// TargetSelector and Subject are set, pods must match both.
+	// +optional
+	Subject *Subject `json:"subject,omitempty"`
+	// BlockList contains rules for names that are blocked from DNS resolution.
+	// +optional
+	BlockList []DomainRule `json:"blockList,omitempty"`
+	// AllowList contains rules for names that are allowed for DNS resolution.
+	// A name matching both lists is blocked: BlockList takes precedence.
+	// +optional
+	AllowList []DomainRule `json:"allowList,omitempty"`
+	// BlockListRefs name shared lists whose rules are added to BlockList.
+	// +optional
+	BlockListRefs []DomainListReference `json:"blockListRefs,omitempty"`
+	// AllowListRefs name shared lists whose rules are added to AllowList.
+	// +optional
+	AllowListRefs []DomainListReference `json:"allowListRefs,omitempty"`
+	// DefaultAction decides queries that match neither AllowList nor BlockList.
+	// Use Deny to lock a workload to the names in AllowList.
+	// +kubebuilder:default=Allow
+	// +optional
+	DefaultAction DefaultAction `json:"defaultAction,omitempty"`
+	// Action is how blocked queries are answered. Defaults to NXDOMAIN.
+	// +optional
+	Action *BlockAction `json:"action,omitempty"`
+	// +optional
+	DryRun bool `json:"dryrun,omitempty"`
+	// Priority orders policies that apply to the same pods. Higher values
+	// are merged first and win conflicting rules.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+	// MergeMode is how this policy combines with lower-priority policies.
+	// Override ignores them entirely. Defaults to Append.
+	// +kubebuilder:default=Append
+	// +optional
+	MergeMode MergeMode `json:"mergeMode,omitempty"`
+	// Export renders the policy in other formats, such as an RPZ zone.
+	// +optional
+	Export *PolicyExport `json:"export,omitempty"`
+	// NetworkPolicy generates CNI network policies from the AllowList. It
+	// requires DefaultAction Deny.
+	// +optional
+	NetworkPolicy *NetworkPolicyGeneration `json:"networkPolicy,omitempty"`
+}
+
+// DnsPolicyStatus defines the observed state of DnsPolicy.
+type DnsPolicyStatus struct {
+	// SelectorHash is the hash of the TargetSelector for efficient client lookups.
+	// Clients compute hash of their labels and query policies by this hash.
+	// +optional
+	SelectorHash string `json:"selectorHash,omitempty"`
+
+	// SpecHash is the hash of the entire Spec for change detection.
+	// Clients use this to detect if policy configuration has changed.
+	// +optional
+	SpecHash string `json:"specHash,omitempty"`
+
+	// OverlappingRules lists patterns that appear in both AllowList and BlockList.
+	// BlockList takes precedence, so these names are always blocked.
+	// +optional
+	OverlappingRules []string `json:"overlappingRules,omitempty"`
+
+	// ResolvedAction is the policy-wide block action after defaulting.
+	// Sidecars apply it to blocked names whose rule has no Action.
+	// +optional
+	ResolvedAction *BlockAction `json:"resolvedAction,omitempty"`
+
+	// ObservedGeneration is the generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// NetworkPolicies lists the network policies generated for spec.networkPolicy.
+	// +optional
+	NetworkPolicies []GeneratedObject `json:"networkPolicies,omitempty"`
+
+	// Conditions represent the latest available observations of the DnsPolicy's state.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+
+// DnsPolicy is the Schema for the dnspolicies API.
+type DnsPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DnsPolicySpec   `json:"spec,omitempty"`
+	Status DnsPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DnsPolicyList contains a list of DnsPolicy.
+type DnsPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DnsPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DnsPolicy{}, &DnsPolicyList{})
+}
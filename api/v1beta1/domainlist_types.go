@@ -0,0 +1,201 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DomainListFormat is the syntax of a domain list source.
+// +kubebuilder:validation:Enum=Plain;Hosts;AdBlock;Dnsmasq
+type DomainListFormat string
+
+const (
+	// DomainListFormatPlain is one domain or wildcard pattern per line.
+	DomainListFormatPlain DomainListFormat = "Plain"
+	// DomainListFormatHosts is a hosts file, e.g. "0.0.0.0 ads.example.com".
+	DomainListFormatHosts DomainListFormat = "Hosts"
+	// DomainListFormatAdBlock is AdBlock network rules, e.g. "||ads.example.com^".
+	DomainListFormatAdBlock DomainListFormat = "AdBlock"
+	// DomainListFormatDnsmasq is dnsmasq configuration, e.g. "address=/ads.example.com/0.0.0.0".
+	DomainListFormatDnsmasq DomainListFormat = "Dnsmasq"
+)
+
+// SourceKeyReference selects a key of a ConfigMap or Secret.
+type SourceKeyReference struct {
+	// Name is the name of the object.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Namespace is the namespace of the object. It is required in a
+	// ClusterDomainList and not allowed in a DomainList, which always reads
+	// from its own namespace.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// Key is the data key holding the list.
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+}
+
+// HTTPSource periodically downloads a list from an HTTP(S) URL.
+type HTTPSource struct {
+	// URL is the address of the list.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+	// Interval is the time between downloads. Defaults to one hour; the
+	// minimum is one minute.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// SHA256 is the hex SHA-256 checksum the content must have. Use it to
+	// pin a list that does not change.
+	// +optional
+	SHA256 string `json:"sha256,omitempty"`
+	// SHA256URL is the address of a checksum file, as written by sha256sum,
+	// that is downloaded with the list to verify it.
+	// +optional
+	SHA256URL string `json:"sha256URL,omitempty"`
+	// MaxSize is the largest download accepted. Defaults to 10Mi.
+	// +optional
+	MaxSize *resource.Quantity `json:"maxSize,omitempty"`
+}
+
+// DomainListSource loads rules from a ConfigMap key, a Secret key or an
+// HTTP(S) URL. Exactly one of ConfigMapKeyRef, SecretKeyRef and HTTP must
+// be set.
+type DomainListSource struct {
+	// Format is the syntax of the source. Hosts and Plain lines become
+	// Exact rules, AdBlock and Dnsmasq lines become Suffix rules.
+	// +kubebuilder:default=Plain
+	// +optional
+	Format DomainListFormat `json:"format,omitempty"`
+	// ConfigMapKeyRef selects a ConfigMap key holding the list.
+	// +optional
+	ConfigMapKeyRef *SourceKeyReference `json:"configMapKeyRef,omitempty"`
+	// SecretKeyRef selects a Secret key holding the list. The Secret must
+	// be labeled dns.dnspolicies.io/domain-list=enabled.
+	// +optional
+	SecretKeyRef *SourceKeyReference `json:"secretKeyRef,omitempty"`
+	// HTTP downloads the list from a URL. Only a ClusterDomainList may
+	// download lists.
+	// +optional
+	HTTP *HTTPSource `json:"http,omitempty"`
+}
+
+// EffectiveFormat returns the source's Format, defaulting to Plain.
+func (s *DomainListSource) EffectiveFormat() DomainListFormat {
+	if s.Format != "" {
+		return s.Format
+	}
+	return DomainListFormatPlain
+}
+
+// DomainListSpec defines the desired state of DomainList.
+type DomainListSpec struct {
+	// Rules are the entries of the list. A policy referencing the list from
+	// blockListRefs or allowListRefs adds them to its BlockList or AllowList.
+	// +optional
+	Rules []DomainRule `json:"rules,omitempty"`
+	// Sources load more rules from ConfigMaps or Secrets. They are added to
+	// Rules when a policy references the list.
+	// +optional
+	Sources []DomainListSource `json:"sources,omitempty"`
+}
+
+// ParseError is a line of a source that could not be parsed.
+type ParseError struct {
+	// Line is the 1-based line number.
+	Line int32 `json:"line"`
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+// SourceStatus is the result of loading one source.
+type SourceStatus struct {
+	// RuleCount is the number of rules parsed from the source.
+	// +optional
+	RuleCount int32 `json:"ruleCount,omitempty"`
+	// ParseErrorCount is the number of lines that could not be parsed.
+	// +optional
+	ParseErrorCount int32 `json:"parseErrorCount,omitempty"`
+	// ParseErrors lists the first lines that could not be parsed.
+	// +optional
+	ParseErrors []ParseError `json:"parseErrors,omitempty"`
+	// Error is why the source could not be read, if it could not. An HTTP
+	// source that fails to download keeps its last good content.
+	// +optional
+	Error string `json:"error,omitempty"`
+	// SHA256 is the hex SHA-256 checksum of the content of an HTTP source.
+	// +optional
+	SHA256 string `json:"sha256,omitempty"`
+	// LastSyncTime is when an HTTP source was last downloaded or found
+	// unchanged.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// DomainListStatus defines the observed state of DomainList.
+type DomainListStatus struct {
+	// RuleCount is the number of rules loaded from Sources.
+	// +optional
+	RuleCount int32 `json:"ruleCount,omitempty"`
+
+	// RulesHash is the hash of the rules loaded from Sources. It changes
+	// whenever their content does, so policies referencing the list are
+	// re-indexed.
+	// +optional
+	RulesHash string `json:"rulesHash,omitempty"`
+
+	// Sources reports the result of loading each source, in spec order.
+	// +optional
+	Sources []SourceStatus `json:"sources,omitempty"`
+
+	// ObservedGeneration is the generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the list's state.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// DomainList is the Schema for the domainlists API.
+// It holds rules shared by the DnsPolicies of its namespace.
+type DomainList struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DomainListSpec   `json:"spec,omitempty"`
+	Status DomainListStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DomainListList contains a list of DomainList.
+type DomainListList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DomainList `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DomainList{}, &DomainListList{})
+}
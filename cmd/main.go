@@ -21,16 +21,24 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -38,7 +46,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	dnsv1alpha1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1alpha1"
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
 	"github.com/WoodProgrammer/dns-mesh-controller/internal/controller"
+	webhookdnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/internal/webhook/v1beta1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -51,6 +61,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(dnsv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(dnsv1beta1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -62,6 +73,12 @@ func main() {
 	var enableLeaderElection bool
 	var probeAddr string
 	var apiAddr string
+	var grpcAddr string
+	var enableAPIAuth bool
+	var apiTokenAudience string
+	var apiCertPath, apiCertName, apiCertKey string
+	var coreDNSConfigMap, coreDNSKey, coreDNSClusterDomain, coreDNSUpstream string
+	var enableNetworkPolicies bool
 	var secureMetrics bool
 	var enableHTTP2 bool
 	var tlsOpts []func(*tls.Config)
@@ -69,6 +86,31 @@ func main() {
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&apiAddr, "api-bind-address", ":5959", "The address the DNS policy API endpoint binds to.")
+	flag.StringVar(&grpcAddr, "grpc-bind-address", ":5960", "The address the gRPC policy stream binds to. "+
+		"Leave as 0 to disable the policy stream.")
+	flag.BoolVar(&enableAPIAuth, "api-auth", false,
+		"If set, the DNS policy API and the policy stream require the ServiceAccount token of a pod, "+
+			"verified with the TokenReview API, and only serve the policies that apply to that pod. "+
+			"Set --api-cert-path so that the tokens are not sent in plaintext.")
+	flag.StringVar(&apiTokenAudience, "api-token-audience", controller.DefaultTokenAudience,
+		"The audience ServiceAccount tokens must be issued for. It must not be empty, so that the tokens "+
+			"sidecars send are not accepted by the Kubernetes API.")
+	flag.StringVar(&apiCertPath, "api-cert-path", "",
+		"The directory that contains the certificate of the DNS policy API and the policy stream. "+
+			"If set, both are served over TLS.")
+	flag.StringVar(&apiCertName, "api-cert-name", "tls.crt", "The name of the API certificate file.")
+	flag.StringVar(&apiCertKey, "api-cert-key", "tls.key", "The name of the API key file.")
+	flag.StringVar(&coreDNSConfigMap, "coredns-configmap", "",
+		"The namespace/name of the ConfigMap the CoreDNS server blocks are written to. "+
+			"Leave empty to disable rendering policies into CoreDNS.")
+	flag.StringVar(&coreDNSKey, "coredns-key", controller.DefaultCoreDNSKey,
+		"The ConfigMap key holding the CoreDNS server blocks.")
+	flag.StringVar(&coreDNSClusterDomain, "coredns-cluster-domain", controller.DefaultCoreDNSClusterDomain,
+		"The cluster domain served by the kubernetes plugin of the CoreDNS server blocks.")
+	flag.StringVar(&coreDNSUpstream, "coredns-upstream", controller.DefaultCoreDNSUpstream,
+		"Where the CoreDNS server blocks forward queries that are not blocked.")
+	flag.BoolVar(&enableNetworkPolicies, "enable-network-policies", false,
+		"If set, Cilium and Calico network policies are generated for DnsPolicies that set spec.networkPolicy.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -185,8 +227,13 @@ func main() {
 		Metrics:                metricsServerOptions,
 		WebhookServer:          webhookServer,
 		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "afd794be.dnspolicies.io",
+		// Only cache the Secrets that DomainLists may read, rather than
+		// every Secret in the cluster
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}: {Label: labels.SelectorFromSet(labels.Set{controller.DomainListSecretLabel: "enabled"})},
+		}},
+		LeaderElection:   enableLeaderElection,
+		LeaderElectionID: "afd794be.dnspolicies.io",
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -208,18 +255,141 @@ func main() {
 	policyIndex := controller.NewPolicyIndex()
 	setupLog.Info("Created policy index")
 
+	// Rules loaded from DomainList sources, shared by the list and policy controllers
+	domainLists := controller.NewDomainListStore()
+
 	// Setup DnsPolicy controller with index
 	if err := (&controller.DnsPolicyReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Index:       policyIndex,
+		DomainLists: domainLists,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DnsPolicy")
+		os.Exit(1)
+	}
+	if err := (&controller.ClusterDnsPolicyReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 		Index:  policyIndex,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "DnsPolicy")
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterDnsPolicy")
 		os.Exit(1)
 	}
+	if err := (&controller.DomainListReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+		Store:  domainLists,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DomainList")
+		os.Exit(1)
+	}
+	if err := (&controller.ClusterDomainListReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+		Store:  domainLists,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterDomainList")
+		os.Exit(1)
+	}
+
+	// Render policies into CoreDNS for namespaces that cannot run the sidecar
+	if coreDNSConfigMap != "" {
+		namespace, name, ok := strings.Cut(coreDNSConfigMap, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "--coredns-configmap must be namespace/name", "value", coreDNSConfigMap)
+			os.Exit(1)
+		}
+		if err := (&controller.CoreDNSReconciler{
+			Client:    mgr.GetClient(),
+			Scheme:    mgr.GetScheme(),
+			Index:     policyIndex,
+			ConfigMap: types.NamespacedName{Namespace: namespace, Name: name},
+			Key:       coreDNSKey,
+			Options: controller.CoreDNSOptions{
+				ClusterDomain: coreDNSClusterDomain,
+				Upstream:      coreDNSUpstream,
+			},
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CoreDNS")
+			os.Exit(1)
+		}
+	}
+
+	// Generate CNI network policies so hard-coded addresses cannot bypass DNS policies
+	if enableNetworkPolicies {
+		if err := (&controller.NetworkPolicyReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+			Index:  policyIndex,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "NetworkPolicy")
+			os.Exit(1)
+		}
+	}
+
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err := webhookdnsv1beta1.SetupDnsPolicyWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "DnsPolicy")
+			os.Exit(1)
+		}
+		if err := webhookdnsv1beta1.SetupClusterDnsPolicyWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterDnsPolicy")
+			os.Exit(1)
+		}
+		if err := webhookdnsv1beta1.SetupDomainListWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "DomainList")
+			os.Exit(1)
+		}
+		if err := webhookdnsv1beta1.SetupClusterDomainListWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterDomainList")
+			os.Exit(1)
+		}
+	}
 
 	// Create and add API server to manager
 	apiServer := controller.NewAPIServer(policyIndex, apiAddr)
+	if enableAPIAuth {
+		if apiTokenAudience == "" {
+			setupLog.Error(nil, "--api-token-audience must not be empty when --api-auth is set")
+			os.Exit(1)
+		}
+		apiServer.Auth = &controller.PodAuthenticator{
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Audiences: []string{apiTokenAudience},
+		}
+	}
+	var streamOpts []grpc.ServerOption
+	if len(apiCertPath) > 0 {
+		setupLog.Info("Serving the API and policy stream over TLS",
+			"api-cert-path", apiCertPath, "api-cert-name", apiCertName, "api-cert-key", apiCertKey)
+		apiCertWatcher, err := certwatcher.New(
+			filepath.Join(apiCertPath, apiCertName),
+			filepath.Join(apiCertPath, apiCertKey),
+		)
+		if err != nil {
+			setupLog.Error(err, "Failed to initialize API certificate watcher")
+			os.Exit(1)
+		}
+		if err := mgr.Add(apiCertWatcher); err != nil {
+			setupLog.Error(err, "unable to add API certificate watcher to manager")
+			os.Exit(1)
+		}
+		apiTLSConfig := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: apiCertWatcher.GetCertificate}
+		apiServer.Server.TLSConfig = apiTLSConfig
+		streamOpts = append(streamOpts, grpc.Creds(credentials.NewTLS(apiTLSConfig)))
+	}
+	if grpcAddr != "0" {
+		apiServer.Stream = controller.NewPolicyStreamServer(policyIndex, grpcAddr, streamOpts...)
+		apiServer.Stream.Auth = apiServer.Auth
+		if err := mgr.Add(apiServer.Stream); err != nil {
+			setupLog.Error(err, "unable to add policy stream server to manager")
+			os.Exit(1)
+		}
+		setupLog.Info("Added policy stream server to manager", "address", grpcAddr)
+	}
 	if err := mgr.Add(apiServer); err != nil {
 		setupLog.Error(err, "unable to add API server to manager")
 		os.Exit(1)
@@ -0,0 +1,304 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultTokenReviewTTL is how long the result of a TokenReview is reused
+// for the same token.
+const DefaultTokenReviewTTL = time.Minute
+
+// DefaultTokenAudience is the audience tokens must be issued for when
+// PodAuthenticator.Audiences is empty. Requiring an audience of its own
+// keeps the tokens sidecars send from being accepted by the Kubernetes API.
+const DefaultTokenAudience = "dns-mesh-controller"
+
+// Claims of a pod-bound ServiceAccount token, as reported by a TokenReview.
+const (
+	podNameClaim = "authentication.kubernetes.io/pod-name"
+	podUIDClaim  = "authentication.kubernetes.io/pod-uid"
+)
+
+var (
+	// errUnauthenticated is returned for missing or invalid tokens.
+	errUnauthenticated = errors.New("unauthenticated")
+	// errForbidden is returned when the caller may not see a policy.
+	errForbidden = errors.New("forbidden")
+)
+
+// Caller is the pod an authenticated request comes from.
+type Caller struct {
+	// Pod is the name of the calling pod.
+	Pod string
+	// Identity is what policies are resolved against for the pod.
+	Identity ResolveRequest
+}
+
+// PodAuthenticator identifies the pod calling the API server or the policy
+// stream by the ServiceAccount token it presents. The token is verified
+// with the TokenReview API and must be bound to a pod, as projected tokens
+// are; the pod's labels are then read from the cluster. Both results are
+// cached per token, so a polling sidecar costs one review and one pod read
+// per TTL.
+type PodAuthenticator struct {
+	// Client creates TokenReviews.
+	Client client.Client
+	// APIReader reads the calling pod from the API server, so that pods
+	// that were just created are found.
+	APIReader client.Reader
+	// Audiences the token must be issued for, DefaultTokenAudience when
+	// empty.
+	Audiences []string
+	// TTL is how long a review is cached, DefaultTokenReviewTTL when zero.
+	// A review never outlives the token's expiry.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	reviews map[[sha256.Size]byte]tokenReview
+}
+
+// tokenReview is the cached outcome of a TokenReview.
+type tokenReview struct {
+	pod     types.NamespacedName
+	podUID  string
+	account string
+	expires time.Time
+	// caller is the identity read from the pod, once it was found.
+	caller *Caller
+}
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get
+
+// Authenticate returns the pod that presented token. Errors wrap
+// errUnauthenticated when the token is not a valid pod-bound token and
+// errForbidden when the pod does not exist.
+func (a *PodAuthenticator) Authenticate(ctx context.Context, token string) (*Caller, error) {
+	if token == "" {
+		return nil, fmt.Errorf("%w: missing bearer token", errUnauthenticated)
+	}
+	key := sha256.Sum256([]byte(token))
+	review, err := a.review(ctx, key, token)
+	if err != nil {
+		return nil, err
+	}
+	if review.caller != nil {
+		return review.caller, nil
+	}
+
+	// The claims name the pod; its labels and account come from the cluster
+	var pod corev1.Pod
+	if err := a.APIReader.Get(ctx, review.pod, &pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: pod %s not found", errForbidden, review.pod)
+		}
+		return nil, err
+	}
+	if string(pod.UID) != review.podUID {
+		return nil, fmt.Errorf("%w: token is bound to a deleted pod %s", errForbidden, review.pod)
+	}
+	account := pod.Spec.ServiceAccountName
+	if account == "" {
+		account = "default"
+	}
+	if account != review.account {
+		return nil, fmt.Errorf("%w: pod %s does not run as %s", errForbidden, review.pod, review.account)
+	}
+	caller := &Caller{
+		Pod: pod.Name,
+		Identity: ResolveRequest{
+			Namespace:      pod.Namespace,
+			ServiceAccount: account,
+			Labels:         pod.Labels,
+		},
+	}
+	a.mu.Lock()
+	if cached, ok := a.reviews[key]; ok {
+		cached.caller = caller
+		a.reviews[key] = cached
+	}
+	a.mu.Unlock()
+	return caller, nil
+}
+
+// review verifies token with a TokenReview, reusing recent results.
+func (a *PodAuthenticator) review(ctx context.Context, key [sha256.Size]byte, token string) (tokenReview, error) {
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.reviews[key]
+	a.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached, nil
+	}
+
+	audiences := a.Audiences
+	if len(audiences) == 0 {
+		audiences = []string{DefaultTokenAudience}
+	}
+	tr := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: audiences},
+	}
+	if err := a.Client.Create(ctx, tr); err != nil {
+		return tokenReview{}, fmt.Errorf("failed to review token: %w", err)
+	}
+	if !tr.Status.Authenticated {
+		return tokenReview{}, fmt.Errorf("%w: %s", errUnauthenticated, tr.Status.Error)
+	}
+	namespace, account, err := serviceaccount.SplitUsername(tr.Status.User.Username)
+	if err != nil {
+		return tokenReview{}, fmt.Errorf("%w: %s is not a ServiceAccount", errUnauthenticated, tr.Status.User.Username)
+	}
+	podName, podUID := tr.Status.User.Extra[podNameClaim], tr.Status.User.Extra[podUIDClaim]
+	if len(podName) != 1 || len(podUID) != 1 {
+		return tokenReview{}, fmt.Errorf("%w: token is not bound to a pod", errUnauthenticated)
+	}
+
+	ttl := a.TTL
+	if ttl == 0 {
+		ttl = DefaultTokenReviewTTL
+	}
+	expires := now.Add(ttl)
+	if exp, ok := tokenExpiry(token); ok && exp.Before(expires) {
+		expires = exp
+	}
+	review := tokenReview{
+		pod:     types.NamespacedName{Namespace: namespace, Name: podName[0]},
+		podUID:  podUID[0],
+		account: account,
+		expires: expires,
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.reviews == nil {
+		a.reviews = map[[sha256.Size]byte]tokenReview{}
+	}
+	for k, r := range a.reviews {
+		if now.After(r.expires) {
+			delete(a.reviews, k)
+		}
+	}
+	a.reviews[key] = review
+	return review, nil
+}
+
+// tokenExpiry returns the exp claim of a JWT. The token is not verified;
+// the TokenReview has done that.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// Authorize checks that req only describes the caller and returns the
+// caller's full identity, which is what policies must be resolved against.
+func (c *Caller) Authorize(req ResolveRequest) (ResolveRequest, error) {
+	if req.Namespace != c.Identity.Namespace {
+		return req, fmt.Errorf("%w: pod %s/%s may not resolve policies of namespace %q",
+			errForbidden, c.Identity.Namespace, c.Pod, req.Namespace)
+	}
+	if req.ServiceAccount != "" && req.ServiceAccount != c.Identity.ServiceAccount {
+		return req, fmt.Errorf("%w: pod %s/%s does not run as %s",
+			errForbidden, c.Identity.Namespace, c.Pod, req.ServiceAccount)
+	}
+	for key, value := range req.Labels {
+		if actual, ok := c.Identity.Labels[key]; !ok || actual != value {
+			return req, fmt.Errorf("%w: pod %s/%s does not have the label %s=%s",
+				errForbidden, c.Identity.Namespace, c.Pod, key, value)
+		}
+	}
+	return c.Identity, nil
+}
+
+// callerKey is the context key of the authenticated Caller.
+type callerKey struct{}
+
+// callerFrom returns the authenticated caller of a request, or nil when
+// authentication is disabled.
+func callerFrom(ctx context.Context) *Caller {
+	caller, _ := ctx.Value(callerKey{}).(*Caller)
+	return caller
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(header string) string {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// authenticate requires a pod ServiceAccount token on every request but
+// the health check and the schema when Auth is set.
+func (s *APIServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Auth == nil || r.URL.Path == "/healthz" || r.URL.Path == PolicySchemaPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		caller, err := s.Auth.Authenticate(r.Context(), bearerToken(r.Header.Get("Authorization")))
+		if err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+	})
+}
+
+// writeAuthError answers 401 or 403 for errors wrapping errUnauthenticated
+// or errForbidden and 500 otherwise.
+func writeAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnauthenticated):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dns-mesh-controller"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, errForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// fakeTokens maps the tokens accepted by newFakeAuthenticator to the user
+// info a TokenReview reports for them.
+var fakeTokens = map[string]authenticationv1.UserInfo{
+	"frontend-token": {
+		Username: "system:serviceaccount:default:frontend",
+		Extra: map[string]authenticationv1.ExtraValue{
+			podNameClaim: {"frontend-1"}, podUIDClaim: {"uid-1"},
+		},
+	},
+	"stale-token": {
+		Username: "system:serviceaccount:default:frontend",
+		Extra: map[string]authenticationv1.ExtraValue{
+			podNameClaim: {"frontend-1"}, podUIDClaim: {"uid-0"},
+		},
+	},
+	"unbound-token": {Username: "system:serviceaccount:default:frontend"},
+	expiredToken: {
+		Username: "system:serviceaccount:default:frontend",
+		Extra: map[string]authenticationv1.ExtraValue{
+			podNameClaim: {"frontend-1"}, podUIDClaim: {"uid-1"},
+		},
+	},
+}
+
+// expiredToken is a JWT whose exp claim has passed.
+var expiredToken = fakeJWT(time.Unix(1, 0))
+
+// fakeJWT returns an unsigned JWT that expires at exp.
+func fakeJWT(exp time.Time) string {
+	payload := fmt.Sprintf(`{"exp":%d}`, exp.Unix())
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+// newFakeAuthenticator returns an authenticator that knows the pod
+// default/frontend-1 and reviews fakeTokens, which are issued for
+// DefaultTokenAudience. reviews counts TokenReviews.
+func newFakeAuthenticator(reviews *int) *PodAuthenticator {
+	testScheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+	c := fake.NewClientBuilder().WithScheme(testScheme).WithObjects(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "frontend-1", Namespace: "default", UID: "uid-1",
+			Labels: map[string]string{"app": "frontend", "tier": "web"},
+		},
+		Spec: corev1.PodSpec{ServiceAccountName: "frontend"},
+	}).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			review, ok := obj.(*authenticationv1.TokenReview)
+			if !ok {
+				return c.Create(ctx, obj, opts...)
+			}
+			*reviews++
+			user, ok := fakeTokens[review.Spec.Token]
+			ok = ok && slices.Contains(review.Spec.Audiences, DefaultTokenAudience)
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: ok, User: user}
+			if !ok {
+				review.Status.Error = "invalid bearer token"
+			}
+			return nil
+		},
+	}).Build()
+	return &PodAuthenticator{Client: c, APIReader: c}
+}
+
+var _ = Describe("PodAuthenticator", func() {
+	var (
+		auth    *PodAuthenticator
+		reviews int
+	)
+
+	BeforeEach(func() {
+		reviews = 0
+		auth = newFakeAuthenticator(&reviews)
+	})
+
+	It("should identify the pod a token is bound to", func() {
+		caller, err := auth.Authenticate(context.Background(), "frontend-token")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(caller.Pod).To(Equal("frontend-1"))
+		Expect(caller.Identity).To(Equal(ResolveRequest{
+			Namespace:      "default",
+			ServiceAccount: "frontend",
+			Labels:         map[string]string{"app": "frontend", "tier": "web"},
+		}))
+
+		By("reusing the review and the pod until the review expires")
+		Expect(auth.Client.Delete(context.Background(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "frontend-1", Namespace: "default"},
+		})).To(Succeed())
+		cached, err := auth.Authenticate(context.Background(), "frontend-token")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cached).To(Equal(caller))
+		Expect(reviews).To(Equal(1))
+	})
+
+	It("should not cache a review past the token's expiry", func() {
+		exp, ok := tokenExpiry(fakeJWT(time.Unix(1700000000, 0)))
+		Expect(ok).To(BeTrue())
+		Expect(exp).To(Equal(time.Unix(1700000000, 0)))
+		_, ok = tokenExpiry("frontend-token")
+		Expect(ok).To(BeFalse())
+
+		for range 2 {
+			_, err := auth.Authenticate(context.Background(), expiredToken)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(reviews).To(Equal(2))
+	})
+
+	It("should only accept tokens issued for its audience", func() {
+		auth.Audiences = []string{"https://kubernetes.default.svc"}
+		_, err := auth.Authenticate(context.Background(), "frontend-token")
+		Expect(err).To(MatchError(errUnauthenticated))
+	})
+
+	It("should reject tokens that do not identify a running pod", func() {
+		for token, expected := range map[string]error{
+			"":              errUnauthenticated,
+			"forged-token":  errUnauthenticated,
+			"unbound-token": errUnauthenticated,
+			"stale-token":   errForbidden,
+		} {
+			_, err := auth.Authenticate(context.Background(), token)
+			Expect(err).To(MatchError(expected), token)
+		}
+	})
+
+	It("should only let a caller ask for its own identity", func() {
+		caller, err := auth.Authenticate(context.Background(), "frontend-token")
+		Expect(err).NotTo(HaveOccurred())
+
+		identity, err := caller.Authorize(ResolveRequest{Namespace: "default", Labels: map[string]string{"app": "frontend"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(identity).To(Equal(caller.Identity))
+
+		for _, req := range []ResolveRequest{
+			{Namespace: "payments"},
+			{Namespace: "default", ServiceAccount: "payments"},
+			{Namespace: "default", Labels: map[string]string{"app": "api"}},
+			{Namespace: "default", Labels: map[string]string{"env": "prod"}},
+		} {
+			_, err := caller.Authorize(req)
+			Expect(err).To(MatchError(errForbidden), "%+v", req)
+		}
+	})
+
+	Context("on the API server", func() {
+		var server *APIServer
+
+		BeforeEach(func() {
+			server = NewAPIServer(NewPolicyIndex(), ":0")
+			server.Auth = auth
+			for _, policy := range []*dnsv1beta1.DnsPolicy{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+					Spec: dnsv1beta1.DnsPolicySpec{
+						TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+					Spec: dnsv1beta1.DnsPolicySpec{
+						TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+					Spec:       dnsv1beta1.DnsPolicySpec{Subject: &dnsv1beta1.Subject{ServiceAccount: "web"}},
+				},
+			} {
+				hash, err := SelectorHashFor(&policy.Spec)
+				Expect(err).NotTo(HaveOccurred())
+				server.Index.Upsert(policy, hash)
+			}
+		})
+
+		get := func(url, token string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			server.Server.Handler.ServeHTTP(rec, req)
+			return rec
+		}
+		hashOf := func(labels map[string]string) string {
+			hash, err := ComputeSelectorHash(labels)
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			return hash
+		}
+
+		It("should require a token except for the health check and the schema", func() {
+			rec := get("/api/v1/effective?namespace=default", "")
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(rec.Header().Get("WWW-Authenticate")).To(HavePrefix("Bearer"))
+			Expect(get("/api/v1/effective?namespace=default", "forged-token").Code).To(Equal(http.StatusUnauthorized))
+			Expect(get("/healthz", "").Code).To(Equal(http.StatusOK))
+			Expect(get(PolicySchemaPath, "").Code).To(Equal(http.StatusOK))
+		})
+
+		It("should resolve the caller's full identity", func() {
+			rec := get("/api/v1/resolve?namespace=default", "frontend-token")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var resp ResolveResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Policies).To(ConsistOf(HaveField("Source.Name", "frontend")))
+
+			Expect(get("/api/v1/resolve?namespace=default&labels=app=api", "frontend-token").Code).
+				To(Equal(http.StatusForbidden))
+			Expect(get("/api/v1/effective?namespace=payments", "frontend-token").Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("should only serve policies that apply to the caller by hash or name", func() {
+			Expect(get("/api/policies?hash="+hashOf(map[string]string{"app": "frontend"}), "frontend-token").Code).
+				To(Equal(http.StatusOK))
+			Expect(get("/api/policies?hash="+hashOf(map[string]string{"app": "api"}), "frontend-token").Code).
+				To(Equal(http.StatusForbidden))
+			Expect(get("/api/policies?namespace=payments&hash="+hashOf(map[string]string{"app": "frontend"}),
+				"frontend-token").Code).To(Equal(http.StatusForbidden))
+
+			rec := get("/api/policies?labels=app=frontend", "frontend-token")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(get("/api/policies?labels=app=api", "frontend-token").Code).To(Equal(http.StatusForbidden))
+
+			Expect(get("/api/v1/rpz?namespace=default&name=frontend", "frontend-token").Code).To(Equal(http.StatusOK))
+			Expect(get("/api/v1/rpz?namespace=default&name=web", "frontend-token").Code).To(Equal(http.StatusForbidden))
+
+			rec = get("/api/v1/watch?hash="+hashOf(map[string]string{"app": "api"}), "frontend-token")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).NotTo(ContainSubstring(`"name":"api"`))
+		})
+
+		It("should not list subscribers to pods", func() {
+			server.Stream = NewPolicyStreamServer(server.Index, "")
+			Expect(get("/api/v1/subscribers", "frontend-token").Code).To(Equal(http.StatusForbidden))
+		})
+	})
+})
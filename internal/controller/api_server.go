@@ -17,38 +17,105 @@ limitations under the License.
 package controller
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	ctrl "sigs.k8s.io/controller-runtime"
+
+	dnspolicyv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
 )
 
+// maxResolveBodyBytes bounds the body of a POST /api/v1/resolve request.
+const maxResolveBodyBytes = 64 << 10
+
+const (
+	// DefaultWatchTimeout is how long /api/v1/watch waits for a change.
+	DefaultWatchTimeout = 30 * time.Second
+	// MaxWatchTimeout bounds the timeoutSeconds parameter of /api/v1/watch.
+	MaxWatchTimeout = 5 * time.Minute
+)
+
+// ResolveRequest identifies a pod for /api/v1/resolve.
+type ResolveRequest struct {
+	// Namespace of the pod. Only policies in this namespace apply.
+	Namespace string `json:"namespace"`
+	// ServiceAccount of the pod, matched against policy subjects.
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	// Labels is the pod's full label set.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ResolveResponse lists the policies that apply to a pod.
+type ResolveResponse struct {
+	Policies []*PolicyDocument `json:"policies"`
+	// ClusterPolicies are the cluster-wide baseline policies selecting the pod.
+	ClusterPolicies []*PolicyDocument `json:"clusterPolicies"`
+}
+
+// WatchResponse is the effective policy returned by /api/v1/watch.
+type WatchResponse struct {
+	// SchemaVersion is PolicyDocumentSchemaVersion.
+	SchemaVersion string `json:"schemaVersion"`
+	// Version identifies the content of Policy. Clients pass it back to
+	// wait for the next change.
+	Version string `json:"version"`
+	// Policy is the merged policy of the watched pod or selector hash.
+	Policy *EffectivePolicy `json:"policy"`
+}
+
 // APIServer serves DNS policies to clients via HTTP.
 type APIServer struct {
 	Index  *PolicyIndex
 	Server *http.Server
+	// Stream is the gRPC policy stream whose subscribers are listed at
+	// /api/v1/subscribers. It is nil when the stream is disabled.
+	Stream *PolicyStreamServer
+	// Auth, when set, requires every request to carry the ServiceAccount
+	// token of a pod and only serves that pod the policies applying to it.
+	Auth *PodAuthenticator
+
+	// shutdown is closed when the server shuts down to end pending watches.
+	shutdown chan struct{}
 }
 
 // NewAPIServer creates a new API server instance.
 func NewAPIServer(index *PolicyIndex, addr string) *APIServer {
 	apiServer := &APIServer{
-		Index: index,
+		Index:    index,
+		shutdown: make(chan struct{}),
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/policies", apiServer.handleGetPolicy)
+	mux.HandleFunc("/api/v1/resolve", apiServer.handleResolve)
+	mux.HandleFunc("/api/v1/effective", apiServer.handleEffective)
+	mux.HandleFunc("/api/v1/rpz", apiServer.handleRPZ)
+	mux.HandleFunc("/api/v1/watch", apiServer.handleWatch)
+	mux.HandleFunc("/api/v1/subscribers", apiServer.handleSubscribers)
+	mux.HandleFunc(PolicySchemaPath, apiServer.handleSchema)
 	mux.HandleFunc("/healthz", apiServer.handleHealthz)
 
 	apiServer.Server = &http.Server{
 		Addr:         addr,
-		Handler:      mux,
+		Handler:      apiServer.authenticate(mux),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	apiServer.Server.RegisterOnShutdown(func() { close(apiServer.shutdown) })
 
 	return apiServer
 }
@@ -58,9 +125,13 @@ func (s *APIServer) Start(ctx context.Context) error {
 	log := ctrl.LoggerFrom(ctx).WithName("api-server")
 	log.Info("Starting API server", "addr", s.Server.Addr)
 
-	// Start server in goroutine
+	// Start server in goroutine, serving TLS when a certificate is configured
 	go func() {
-		if err := s.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		serve := s.Server.ListenAndServe
+		if s.Server.TLSConfig != nil {
+			serve = func() error { return s.Server.ListenAndServeTLS("", "") }
+		}
+		if err := serve(); err != nil && err != http.ErrServerClosed {
 			log.Error(err, "API server failed")
 		}
 	}()
@@ -81,43 +152,468 @@ func (s *APIServer) Start(ctx context.Context) error {
 	return nil
 }
 
-// handleGetPolicy handles GET /api/policies?hash=<selectorHash>
+// handleGetPolicy handles GET /api/policies?hash=<selectorHash>&namespace=<ns> and
+// GET /api/policies?labels=<key=value,...>. The hash lookup succeeds only if
+// a single policy has that hash; otherwise clients must ask for the merged
+// policy at /api/v1/effective. Policies are written as PolicyDocuments.
+// The hash lookup returns a hash of the document
+// as ETag and answers 304 Not Modified when If-None-Match carries it.
 func (s *APIServer) handleGetPolicy(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	// Resolve the pod's labels against every selector if they were given
+	if r.URL.Query().Has("labels") {
+		s.handleMatchLabels(w, r, r.URL.Query().Get("labels"))
+		return
+	}
+
 	// Get hash from query parameter
 	hash := r.URL.Query().Get("hash")
 	if hash == "" {
-		http.Error(w, "Missing 'hash' query parameter", http.StatusBadRequest)
+		http.Error(w, "Missing 'hash' or 'labels' query parameter", http.StatusBadRequest)
 		return
 	}
 
-	// Lookup policy by hash
-	policy := s.Index.Get(hash)
-	if policy == nil {
+	// Lookup policy by namespace and hash, or infer the namespace
+	var policies []*dnspolicyv1beta1.DnsPolicy
+	namespace := r.URL.Query().Get("namespace")
+	if caller := callerFrom(r.Context()); caller != nil {
+		// Pods only look hashes up in their own namespace
+		if _, err := caller.Authorize(ResolveRequest{Namespace: cmp.Or(namespace, caller.Identity.Namespace)}); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		namespace = caller.Identity.Namespace
+	}
+	if namespace != "" {
+		policies = s.Index.Get(namespace, hash)
+	} else {
+		policies = s.Index.GetByHash(hash)
+	}
+	switch {
+	case len(policies) == 0:
 		http.Error(w, fmt.Sprintf("No policy found for hash: %s", hash), http.StatusNotFound)
 		return
+	case len(policies) > 1 && namespace == "" && policies[0].Namespace != policies[len(policies)-1].Namespace:
+		http.Error(w, fmt.Sprintf("Policies in several namespaces match hash %s; add the 'namespace' query parameter",
+			hash), http.StatusConflict)
+		return
+	case len(policies) > 1:
+		http.Error(w, fmt.Sprintf("%d policies match hash %s; fetch the merged policy from /api/v1/effective",
+			len(policies), hash), http.StatusConflict)
+		return
+	}
+	policy := policies[0]
+	if caller := callerFrom(r.Context()); caller != nil && len(s.applyingTo(caller, policies)) == 0 {
+		writeAuthError(w, fmt.Errorf("%w: policy %s/%s does not apply to pod %s/%s", errForbidden,
+			policy.Namespace, policy.Name, caller.Identity.Namespace, caller.Pod))
+		return
+	}
+
+	// Let clients revalidate by the hash of the document they would get,
+	// which the index computes once per change, so unchanged policies cost
+	// a 304
+	data, etag := s.Index.Document(types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name})
+	if data == nil {
+		http.Error(w, fmt.Sprintf("Failed to encode policy %s/%s", policy.Namespace, policy.Name),
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
 
 	// Return policy as JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(append(data, '\n'))
+}
+
+// etagMatches reports whether an If-None-Match header matches etag. As RFC
+// 9110 requires for If-None-Match, weak validators compare equal to the
+// strong one.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// handleMatchLabels writes every policy whose targetSelector matches the
+// given pod labels, e.g. "app=frontend,tier=web", as a JSON array of
+// PolicyDocuments.
+func (s *APIServer) handleMatchLabels(w http.ResponseWriter, r *http.Request, rawLabels string) {
+	podLabels, err := labels.ConvertSelectorToLabelsMap(rawLabels)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'labels' query parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	policies := s.Index.Match(podLabels)
+	if caller := callerFrom(r.Context()); caller != nil {
+		if _, err := caller.Authorize(ResolveRequest{Namespace: caller.Identity.Namespace, Labels: podLabels}); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		policies = s.applyingTo(caller, policies)
+	}
+	if len(policies) == 0 {
+		http.Error(w, fmt.Sprintf("No policy found for labels: %s", podLabels), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(policyDocuments(policies)); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleResolve handles GET /api/v1/resolve?namespace=<ns>&serviceAccount=<sa>&labels=<key=value,...>
+// and POST /api/v1/resolve with a ResolveRequest body. It returns every policy
+// that applies to the pod, so sidecars do not need to know selector hashes.
+func (s *APIServer) handleResolve(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeResolveRequest(w, r)
+	if !ok {
+		return
+	}
+
+	podLabels := labels.Set(req.Labels)
+	resp := ResolveResponse{
+		Policies:        policyDocuments(s.Index.Resolve(req.Namespace, req.ServiceAccount, podLabels)),
+		ClusterPolicies: clusterPolicyDocuments(s.Index.ResolveCluster(req.Namespace, req.ServiceAccount, podLabels)),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleEffective handles /api/v1/effective with the same parameters as
+// /api/v1/resolve. It merges every policy that applies to the pod into one
+// EffectivePolicy that records which policy contributed each rule.
+func (s *APIServer) handleEffective(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeResolveRequest(w, r)
+	if !ok {
+		return
+	}
+
+	effective := MergePolicies(
+		s.Index.Resolve(req.Namespace, req.ServiceAccount, labels.Set(req.Labels)),
+		s.Index.ResolveCluster(req.Namespace, req.ServiceAccount, labels.Set(req.Labels)),
+	)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(w).Encode(policy); err != nil {
+	if err := json.NewEncoder(w).Encode(effective); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
 		return
 	}
 }
 
+// handleWatch handles /api/v1/watch, a long poll for changes to an
+// effective policy. The policy is selected either by
+// hash=<selectorHash>[&namespace=<ns>], merging the policies that share
+// the hash, or by the parameters of /api/v1/resolve. Without a version
+// parameter, or when the version differs from the current one, the policy
+// is returned at once. Otherwise the request waits until the policy
+// changes or timeoutSeconds (30 by default, at most 300) pass, and then
+// answers 304 Not Modified.
+func (s *APIServer) handleWatch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	timeout := DefaultWatchTimeout
+	if raw := query.Get("timeoutSeconds"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 0 {
+			http.Error(w, fmt.Sprintf("Invalid 'timeoutSeconds' query parameter: %q", raw), http.StatusBadRequest)
+			return
+		}
+		timeout = min(time.Duration(seconds)*time.Second, MaxWatchTimeout)
+	}
+
+	var effective func() *EffectivePolicy
+	if hash := query.Get("hash"); hash != "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		namespace := query.Get("namespace")
+		caller := callerFrom(r.Context())
+		if caller != nil {
+			if _, err := caller.Authorize(ResolveRequest{Namespace: cmp.Or(namespace, caller.Identity.Namespace)}); err != nil {
+				writeAuthError(w, err)
+				return
+			}
+		}
+		effective = func() *EffectivePolicy {
+			switch {
+			case caller != nil:
+				return MergePolicies(s.applyingTo(caller, s.Index.Get(caller.Identity.Namespace, hash)), nil)
+			case namespace != "":
+				return MergePolicies(s.Index.Get(namespace, hash), nil)
+			}
+			return MergePolicies(s.Index.GetByHash(hash), nil)
+		}
+	} else {
+		req, ok := s.decodeResolveRequest(w, r)
+		if !ok {
+			return
+		}
+		podLabels := labels.Set(req.Labels)
+		effective = func() *EffectivePolicy {
+			return MergePolicies(
+				s.Index.Resolve(req.Namespace, req.ServiceAccount, podLabels),
+				s.Index.ResolveCluster(req.Namespace, req.ServiceAccount, podLabels),
+			)
+		}
+	}
+
+	// The server's write timeout is shorter than a watch
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 10*time.Second)); err != nil &&
+		!errors.Is(err, http.ErrNotSupported) {
+		http.Error(w, fmt.Sprintf("Failed to extend the write deadline: %v", err), http.StatusInternalServerError)
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	since := query.Get("version")
+	for {
+		// Take the change channel first so no change is missed while merging
+		_, changed := s.Index.Changes()
+		resp, err := newWatchResponse(effective())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if resp.Version != since {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-s.shutdown:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// newWatchResponse versions an effective policy by the hash of its JSON form.
+func newWatchResponse(effective *EffectivePolicy) (*WatchResponse, error) {
+	version, err := policyVersion(effective)
+	if err != nil {
+		return nil, err
+	}
+	return &WatchResponse{SchemaVersion: PolicyDocumentSchemaVersion, Version: version, Policy: effective}, nil
+}
+
+// policyVersion identifies an effective policy by the hash of its JSON form.
+func policyVersion(effective *EffectivePolicy) (string, error) {
+	data, err := json.Marshal(effective)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// handleRPZ handles GET /api/v1/rpz and writes an RPZ zone file for CoreDNS
+// or BIND resolvers. With namespace=<ns>&name=<name> it renders one
+// DnsPolicy, with kind=ClusterDnsPolicy&name=<name> one ClusterDnsPolicy,
+// and otherwise the effective policy of a pod given by the parameters of
+// /api/v1/resolve. The optional zone parameter sets the zone origin. The
+// serial is the current Unix time.
+func (s *APIServer) handleRPZ(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	zone := query.Get("zone")
+	if zone == "" {
+		zone = DefaultRPZZone
+	}
+	if errs := validation.IsDNS1123Subdomain(strings.TrimSuffix(zone, ".")); len(errs) > 0 {
+		http.Error(w, fmt.Sprintf("Invalid 'zone' query parameter: %s", strings.Join(errs, "; ")), http.StatusBadRequest)
+		return
+	}
+
+	var effective *EffectivePolicy
+	switch name := query.Get("name"); {
+	case name != "" && query.Get("kind") == KindClusterDnsPolicy:
+		policy := s.Index.GetCluster(name)
+		if policy == nil {
+			http.Error(w, fmt.Sprintf("No ClusterDnsPolicy found: %s", name), http.StatusNotFound)
+			return
+		}
+		if caller := callerFrom(r.Context()); caller != nil && !slices.ContainsFunc(
+			s.Index.ResolveCluster(caller.Identity.Namespace, caller.Identity.ServiceAccount,
+				labels.Set(caller.Identity.Labels)),
+			func(applying *dnspolicyv1beta1.ClusterDnsPolicy) bool { return applying.Name == name }) {
+			writeAuthError(w, fmt.Errorf("%w: ClusterDnsPolicy %s does not apply to pod %s/%s", errForbidden,
+				name, caller.Identity.Namespace, caller.Pod))
+			return
+		}
+		effective = MergePolicies(nil, []*dnspolicyv1beta1.ClusterDnsPolicy{policy})
+	case name != "":
+		namespacedName := types.NamespacedName{Namespace: query.Get("namespace"), Name: name}
+		policy := s.Index.GetByName(namespacedName)
+		if policy == nil {
+			http.Error(w, fmt.Sprintf("No DnsPolicy found: %s", namespacedName), http.StatusNotFound)
+			return
+		}
+		if caller := callerFrom(r.Context()); caller != nil &&
+			len(s.applyingTo(caller, []*dnspolicyv1beta1.DnsPolicy{policy})) == 0 {
+			writeAuthError(w, fmt.Errorf("%w: DnsPolicy %s does not apply to pod %s/%s", errForbidden,
+				namespacedName, caller.Identity.Namespace, caller.Pod))
+			return
+		}
+		effective = MergePolicies([]*dnspolicyv1beta1.DnsPolicy{policy}, nil)
+	default:
+		req, ok := s.decodeResolveRequest(w, r)
+		if !ok {
+			return
+		}
+		effective = MergePolicies(
+			s.Index.Resolve(req.Namespace, req.ServiceAccount, labels.Set(req.Labels)),
+			s.Index.ResolveCluster(req.Namespace, req.ServiceAccount, labels.Set(req.Labels)),
+		)
+	}
+
+	now := time.Now()
+	w.Header().Set("Content-Type", "text/dns")
+	w.WriteHeader(http.StatusOK)
+	w.Write(RenderRPZ(effective, zone, uint32(now.Unix()), now)) //nolint:errcheck
+}
+
+// decodeResolveRequest reads the pod identity from the query of a GET or the
+// body of a POST request. For an authenticated caller the identity must
+// describe the caller and is replaced by its full identity. It writes an
+// error response and returns false if the request is invalid.
+func (s *APIServer) decodeResolveRequest(w http.ResponseWriter, r *http.Request) (ResolveRequest, bool) {
+	var req ResolveRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		podLabels, err := labels.ConvertSelectorToLabelsMap(query.Get("labels"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid 'labels' query parameter: %v", err), http.StatusBadRequest)
+			return req, false
+		}
+		req = ResolveRequest{
+			Namespace:      query.Get("namespace"),
+			ServiceAccount: query.Get("serviceAccount"),
+			Labels:         podLabels,
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxResolveBodyBytes)).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return req, false
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+
+	if req.Namespace == "" {
+		http.Error(w, "Missing 'namespace'", http.StatusBadRequest)
+		return req, false
+	}
+	if caller := callerFrom(r.Context()); caller != nil {
+		identity, err := caller.Authorize(req)
+		if err != nil {
+			writeAuthError(w, err)
+			return req, false
+		}
+		return identity, true
+	}
+	return req, true
+}
+
+// applyingTo returns the policies that apply to caller.
+func (s *APIServer) applyingTo(caller *Caller, policies []*dnspolicyv1beta1.DnsPolicy) []*dnspolicyv1beta1.DnsPolicy {
+	identity := caller.Identity
+	applying := s.Index.Resolve(identity.Namespace, identity.ServiceAccount, labels.Set(identity.Labels))
+	return slices.DeleteFunc(slices.Clone(policies), func(policy *dnspolicyv1beta1.DnsPolicy) bool {
+		return !slices.ContainsFunc(applying, func(a *dnspolicyv1beta1.DnsPolicy) bool {
+			return a.Namespace == policy.Namespace && a.Name == policy.Name
+		})
+	})
+}
+
+// handleSubscribers handles GET /api/v1/subscribers and lists the sidecars
+// connected to the policy stream with the version each has applied.
+func (s *APIServer) handleSubscribers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Stream == nil {
+		http.Error(w, "The policy stream is disabled", http.StatusNotFound)
+		return
+	}
+	if caller := callerFrom(r.Context()); caller != nil {
+		// Subscribers reveal the identities of other pods
+		writeAuthError(w, fmt.Errorf("%w: pods may not list subscribers", errForbidden))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(s.Stream.Subscribers()); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleSchema handles GET /api/v1/schema and returns the JSON Schema of
+// the policy documents served to sidecars.
+func (s *APIServer) handleSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(policySchema)
+}
+
 // handleHealthz handles GET /healthz
 func (s *APIServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":           "ok",
-		"indexed_policies": s.Index.Size(),
+		"status":                   "ok",
+		"indexed_policies":         s.Index.Size(),
+		"indexed_cluster_policies": s.Index.ClusterSize(),
 	})
 }
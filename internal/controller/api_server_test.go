@@ -0,0 +1,372 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("APIServer", func() {
+	var server *APIServer
+
+	BeforeEach(func() {
+		index := NewPolicyIndex()
+		for _, policy := range []*dnsv1beta1.DnsPolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+				Spec: dnsv1beta1.DnsPolicySpec{
+					TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "payments-identity", Namespace: "default"},
+				Spec:       dnsv1beta1.DnsPolicySpec{Subject: &dnsv1beta1.Subject{ServiceAccount: "payments"}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "payments-api", Namespace: "default"},
+				Spec: dnsv1beta1.DnsPolicySpec{
+					TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+					Subject:        &dnsv1beta1.Subject{ServiceAccount: "payments"},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-tier", Namespace: "default"},
+				Spec: dnsv1beta1.DnsPolicySpec{
+					TargetSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "edge"}},
+					}},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "staging"},
+				Spec: dnsv1beta1.DnsPolicySpec{
+					TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend", "env": "staging"}},
+				},
+			},
+		} {
+			hash, err := SelectorHashFor(&policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			index.Upsert(policy, hash)
+		}
+		server = NewAPIServer(index, ":0")
+	})
+
+	resolve := func(req *http.Request) (int, []string) {
+		rec := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+
+		var resp ResolveResponse
+		Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+		names := []string{}
+		for _, policy := range resp.Policies {
+			names = append(names, policy.Source.Namespace+"/"+policy.Source.Name)
+		}
+		return rec.Code, names
+	}
+
+	Context("/api/policies", func() {
+		get := func(url string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+			return rec
+		}
+
+		It("should look a hash up in the given namespace", func() {
+			hash, err := ComputeSelectorHash(map[string]string{"app": "frontend"})
+			Expect(err).NotTo(HaveOccurred())
+
+			rec := get("/api/policies?namespace=default&hash=" + hash)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var policy PolicyDocument
+			Expect(json.Unmarshal(rec.Body.Bytes(), &policy)).To(Succeed())
+			Expect(policy.SchemaVersion).To(Equal(PolicyDocumentSchemaVersion))
+			Expect(policy.Source).To(Equal(PolicySource{Kind: KindDnsPolicy, Namespace: "default", Name: "frontend"}))
+
+			Expect(get("/api/policies?namespace=payments&hash=" + hash).Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should answer 304 when If-None-Match carries the document hash", func() {
+			policy := server.Index.GetByName(types.NamespacedName{Namespace: "default", Name: "frontend"})
+			hash, err := SelectorHashFor(&policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			url := "/api/policies?namespace=default&hash=" + hash
+
+			rec := get(url)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			sum := sha256.Sum256(bytes.TrimSuffix(rec.Body.Bytes(), []byte("\n")))
+			etag := strconv.Quote(hex.EncodeToString(sum[:16]))
+			Expect(rec.Header().Get("ETag")).To(Equal(etag))
+			Expect(rec.Header().Get("Cache-Control")).To(Equal("no-cache"))
+
+			conditional := func(ifNoneMatch string) *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, url, nil)
+				req.Header.Set("If-None-Match", ifNoneMatch)
+				server.Server.Handler.ServeHTTP(rec, req)
+				return rec
+			}
+			for _, ifNoneMatch := range []string{etag, `"old", W/` + etag, "*"} {
+				rec = conditional(ifNoneMatch)
+				Expect(rec.Code).To(Equal(http.StatusNotModified), ifNoneMatch)
+				Expect(rec.Body.Len()).To(BeZero())
+				Expect(rec.Header().Get("ETag")).To(Equal(etag))
+			}
+			Expect(conditional(`"old"`).Code).To(Equal(http.StatusOK))
+
+			// Fields outside the spec hash change the document and its ETag
+			policy = policy.DeepCopy()
+			policy.Generation++
+			server.Index.Upsert(policy, hash)
+			Expect(conditional(etag).Code).To(Equal(http.StatusOK))
+		})
+
+		It("should leave combined policies out of label-only lookups", func() {
+			Expect(get("/api/policies?labels=app=api").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should infer the namespace only when the hash is unambiguous", func() {
+			unique, err := ComputeSelectorHash(map[string]string{"app": "frontend", "env": "staging"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(get("/api/policies?hash=" + unique).Code).To(Equal(http.StatusOK))
+
+			index := server.Index
+			shared := &dnsv1beta1.DnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "payments"},
+				Spec:       dnsv1beta1.DnsPolicySpec{TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}},
+			}
+			hash, err := SelectorHashFor(&shared.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			index.Upsert(shared, hash)
+			Expect(index.Get("default", hash)).NotTo(BeNil())
+			Expect(get("/api/policies?hash=" + hash).Code).To(Equal(http.StatusConflict))
+		})
+
+		It("should refer to the effective policy when several policies share a hash", func() {
+			security := &dnsv1beta1.DnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "security", Namespace: "default"},
+				Spec:       dnsv1beta1.DnsPolicySpec{TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}},
+			}
+			hash, err := SelectorHashFor(&security.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			server.Index.Upsert(security, hash)
+
+			rec := get("/api/policies?namespace=default&hash=" + hash)
+			Expect(rec.Code).To(Equal(http.StatusConflict))
+			Expect(rec.Body.String()).To(ContainSubstring("/api/v1/effective"))
+		})
+	})
+
+	Context("/api/v1/resolve", func() {
+		It("should return every policy in the pod's namespace that applies to it", func() {
+			code, names := resolve(httptest.NewRequest(http.MethodGet,
+				"/api/v1/resolve?namespace=default&serviceAccount=payments&labels=app=frontend,tier=edge,pod-template-hash=abc", nil))
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(names).To(Equal([]string{"default/frontend", "default/payments-identity", "default/web-tier"}))
+		})
+
+		It("should require both the labels and the identity of a combined policy", func() {
+			code, names := resolve(httptest.NewRequest(http.MethodGet,
+				"/api/v1/resolve?namespace=default&serviceAccount=payments&labels=app=api", nil))
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(names).To(Equal([]string{"default/payments-api", "default/payments-identity"}))
+
+			_, names = resolve(httptest.NewRequest(http.MethodGet, "/api/v1/resolve?namespace=default&serviceAccount=billing&labels=app=api", nil))
+			Expect(names).To(BeEmpty())
+			_, names = resolve(httptest.NewRequest(http.MethodGet, "/api/v1/resolve?namespace=default&serviceAccount=payments", nil))
+			Expect(names).To(Equal([]string{"default/payments-identity"}))
+		})
+
+		It("should accept the pod identity as a JSON body", func() {
+			body := `{"namespace":"staging","serviceAccount":"payments","labels":{"app":"frontend","env":"staging"}}`
+			code, names := resolve(httptest.NewRequest(http.MethodPost, "/api/v1/resolve", strings.NewReader(body)))
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(names).To(Equal([]string{"staging/frontend"}))
+		})
+
+		It("should return an empty list when nothing applies", func() {
+			code, names := resolve(httptest.NewRequest(http.MethodGet, "/api/v1/resolve?namespace=default&labels=app=worker", nil))
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(names).To(BeEmpty())
+		})
+
+		It("should return the cluster policies selecting the pod", func() {
+			Expect(server.Index.UpsertCluster(&dnsv1beta1.ClusterDnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "baseline"},
+			}, []string{"default"})).To(Succeed())
+
+			rec := httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/resolve?namespace=default&labels=app=worker", nil))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var resp ResolveResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Policies).To(BeEmpty())
+			Expect(resp.ClusterPolicies).To(HaveLen(1))
+			Expect(resp.ClusterPolicies[0].Source).To(Equal(PolicySource{Kind: KindClusterDnsPolicy, Name: "baseline"}))
+
+			rec = httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/resolve?namespace=staging", nil))
+			Expect(rec.Body.String()).To(ContainSubstring(`"clusterPolicies":[]`))
+		})
+
+		It("should require a namespace", func() {
+			code, _ := resolve(httptest.NewRequest(http.MethodGet, "/api/v1/resolve?labels=app=frontend", nil))
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("/api/v1/effective", func() {
+		It("should merge every policy that applies to the pod", func() {
+			rec := httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+				"/api/v1/effective?namespace=default&serviceAccount=payments&labels=app=frontend", nil))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			var effective EffectivePolicy
+			Expect(json.Unmarshal(rec.Body.Bytes(), &effective)).To(Succeed())
+			Expect(effective.Sources).To(Equal([]PolicySource{
+				{Kind: KindDnsPolicy, Namespace: "default", Name: "frontend"},
+				{Kind: KindDnsPolicy, Namespace: "default", Name: "payments-identity"},
+			}))
+		})
+	})
+
+	Context("/api/v1/watch", func() {
+		watch := func(url string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+			return rec
+		}
+		decode := func(rec *httptest.ResponseRecorder) *WatchResponse {
+			ExpectWithOffset(1, rec.Code).To(Equal(http.StatusOK))
+			var resp WatchResponse
+			ExpectWithOffset(1, json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+			return &resp
+		}
+
+		It("should return the current policy without a version", func() {
+			resp := decode(watch("/api/v1/watch?namespace=default&labels=app=frontend"))
+			Expect(resp.Version).NotTo(BeEmpty())
+			Expect(resp.Policy.Sources).To(Equal([]PolicySource{{Kind: KindDnsPolicy, Namespace: "default", Name: "frontend"}}))
+		})
+
+		It("should answer 304 when nothing changed before the timeout", func() {
+			resp := decode(watch("/api/v1/watch?namespace=default&labels=app=frontend"))
+			rec := watch("/api/v1/watch?namespace=default&labels=app=frontend&timeoutSeconds=0&version=" + resp.Version)
+			Expect(rec.Code).To(Equal(http.StatusNotModified))
+		})
+
+		It("should wait until the policy of a selector hash changes", func() {
+			policy := &dnsv1beta1.DnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+				Spec: dnsv1beta1.DnsPolicySpec{
+					TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				},
+			}
+			hash, err := SelectorHashFor(&policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			url := "/api/v1/watch?namespace=default&hash=" + hash
+			resp := decode(watch(url))
+
+			done := make(chan *httptest.ResponseRecorder)
+			go func() {
+				defer GinkgoRecover()
+				done <- watch(url + "&version=" + resp.Version)
+			}()
+			Consistently(done, "100ms").ShouldNot(Receive())
+
+			// Re-indexing an unchanged policy does not wake the watch up
+			server.Index.Upsert(policy, hash)
+			Consistently(done, "100ms").ShouldNot(Receive())
+
+			policy.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}}
+			server.Index.Upsert(policy, hash)
+			var rec *httptest.ResponseRecorder
+			Eventually(done).Should(Receive(&rec))
+			changed := decode(rec)
+			Expect(changed.Version).NotTo(Equal(resp.Version))
+			Expect(changed.Policy.BlockList).To(HaveLen(1))
+		})
+
+		It("should reject an invalid timeout", func() {
+			Expect(watch("/api/v1/watch?namespace=default&timeoutSeconds=soon").Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("/api/v1/rpz", func() {
+		BeforeEach(func() {
+			policy := &dnsv1beta1.DnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "ads", Namespace: "default"},
+				Spec: dnsv1beta1.DnsPolicySpec{
+					TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+					BlockList:      []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}},
+				},
+			}
+			hash, err := SelectorHashFor(&policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			server.Index.Upsert(policy, hash)
+		})
+
+		It("should render a single policy", func() {
+			rec := httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+				"/api/v1/rpz?namespace=default&name=ads&zone=rpz.example.com", nil))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("text/dns"))
+			Expect(rec.Body.String()).To(HavePrefix("$ORIGIN rpz.example.com.\n"))
+			Expect(rec.Body.String()).To(ContainSubstring("\nads.example.com IN CNAME . ; DnsPolicy/default/ads\n"))
+		})
+
+		It("should render the effective policy of a pod", func() {
+			rec := httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+				"/api/v1/rpz?namespace=default&labels=app=frontend", nil))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring("; generated from DnsPolicy/default/ads, DnsPolicy/default/frontend\n"))
+			Expect(rec.Body.String()).To(ContainSubstring("\nads.example.com IN CNAME . ; DnsPolicy/default/ads\n"))
+		})
+
+		It("should report unknown policies and zones", func() {
+			rec := httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+				"/api/v1/rpz?kind=ClusterDnsPolicy&name=baseline", nil))
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+
+			rec = httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+				"/api/v1/rpz?namespace=default&name=ads&zone=not_a_zone", nil))
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,349 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// ClusterDnsPolicyReconciler reconciles a ClusterDnsPolicy object
+type ClusterDnsPolicyReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Index    *PolicyIndex
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=clusterdnspolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=clusterdnspolicies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=clusterdnspolicies/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile reconciles a ClusterDnsPolicy object by:
+// 1. Resolving the namespaces selected by its namespaceSelector
+// 2. Updating the status with the spec hash and matched namespace count
+// 3. Indexing the policy so the API server can merge it into sidecar answers
+// 4. Handling deletions by removing from index
+func (r *ClusterDnsPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	// Fetch the ClusterDnsPolicy instance
+	var policy dnsv1beta1.ClusterDnsPolicy
+	if err := r.Get(ctx, req.NamespacedName, &policy); err != nil {
+		if apierrors.IsNotFound(err) {
+			// Policy was deleted - remove from index
+			log.Info("ClusterDnsPolicy deleted, removing from index", "name", req.Name)
+			r.Index.DeleteCluster(req.Name)
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get ClusterDnsPolicy")
+		return ctrl.Result{}, err
+	}
+
+	// Handle deletion with finalizer
+	if !policy.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(&policy, dnsPolicyFinalizer) {
+			// Remove from index before removing finalizer
+			log.Info("ClusterDnsPolicy being deleted, removing from index", "name", req.Name)
+			r.Index.DeleteCluster(req.Name)
+			r.Recorder.Event(&policy, corev1.EventTypeNormal, "Deleted", "ClusterDnsPolicy removed from index")
+
+			// Remove finalizer
+			controllerutil.RemoveFinalizer(&policy, dnsPolicyFinalizer)
+			if err := r.Update(ctx, &policy); err != nil {
+				log.Error(err, "Failed to remove finalizer")
+				r.Recorder.Event(&policy, corev1.EventTypeWarning, "FinalizerRemovalFailed", fmt.Sprintf("Failed to remove finalizer: %v", err))
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Add finalizer if not present
+	if !controllerutil.ContainsFinalizer(&policy, dnsPolicyFinalizer) {
+		controllerutil.AddFinalizer(&policy, dnsPolicyFinalizer)
+		if err := r.Update(ctx, &policy); err != nil {
+			log.Error(err, "Failed to add finalizer")
+			r.Recorder.Event(&policy, corev1.EventTypeWarning, "FinalizerAddFailed", fmt.Sprintf("Failed to add finalizer: %v", err))
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(&policy, corev1.EventTypeNormal, "FinalizerAdded", "Finalizer added to ClusterDnsPolicy")
+		return ctrl.Result{}, nil
+	}
+
+	// Validate the spec; the admission webhook rejects the same errors up front
+	if errs := ValidateClusterSpec(&policy.Spec); len(errs) > 0 {
+		err := errs.ToAggregate()
+		log.Error(err, "Invalid ClusterDnsPolicy spec")
+		r.Recorder.Event(&policy, corev1.EventTypeWarning, "InvalidSpec", err.Error())
+		r.updateCondition(&policy, "Ready", metav1.ConditionFalse, "InvalidSpec", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	// Resolve the selected namespaces
+	namespaces, err := r.matchingNamespaces(ctx, &policy.Spec)
+	if err != nil {
+		log.Error(err, "Failed to list namespaces")
+		return ctrl.Result{}, err
+	}
+
+	// Compute spec hash
+	specHash, err := ComputeClusterSpecHash(&policy.Spec)
+	if err != nil {
+		log.Error(err, "Failed to compute spec hash")
+		r.Recorder.Event(&policy, corev1.EventTypeWarning, "HashComputationFailed", fmt.Sprintf("Failed to compute spec hash: %v", err))
+		r.updateCondition(&policy, "Ready", metav1.ConditionFalse, "HashComputationFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	// Look for a higher-priority Override cluster policy that replaces this one
+	shadowedBy, err := r.shadowingPolicy(ctx, &policy, namespaces)
+	if err != nil {
+		log.Error(err, "Failed to look up shadowing policies")
+		return ctrl.Result{}, err
+	}
+	shadowStatus, shadowReason, shadowMessage := metav1.ConditionFalse, "NotShadowed",
+		"no higher-priority policy overrides this policy"
+	if shadowedBy != nil {
+		shadowStatus, shadowReason = metav1.ConditionTrue, "Overridden"
+		shadowMessage = fmt.Sprintf("overridden by %s with priority %d", shadowedBy, shadowedBy.Priority)
+	}
+
+	// Update status if anything changed
+	needsStatusUpdate := false
+	if policy.Status.SpecHash != specHash {
+		log.Info("Spec hash changed", "old", policy.Status.SpecHash, "new", specHash)
+		policy.Status.SpecHash = specHash
+		needsStatusUpdate = true
+		r.Recorder.Eventf(&policy, corev1.EventTypeNormal, "SpecHashUpdated", "Spec hash updated to %s", specHash)
+	}
+	if matched := int32(len(namespaces)); policy.Status.MatchedNamespaces != matched {
+		policy.Status.MatchedNamespaces = matched
+		needsStatusUpdate = true
+	}
+	overlaps := overlappingRules(clusterPolicySpec(&policy.Spec))
+	if !slices.Equal(policy.Status.OverlappingRules, overlaps) {
+		policy.Status.OverlappingRules = overlaps
+		needsStatusUpdate = true
+	}
+	resolvedAction := ResolveAction(clusterPolicySpec(&policy.Spec))
+	if !equality.Semantic.DeepEqual(policy.Status.ResolvedAction, resolvedAction) {
+		policy.Status.ResolvedAction = resolvedAction
+		needsStatusUpdate = true
+	}
+	if policy.Status.ObservedGeneration != policy.Generation {
+		policy.Status.ObservedGeneration = policy.Generation
+		needsStatusUpdate = true
+	}
+	if current := meta.FindStatusCondition(policy.Status.Conditions, conditionShadowed); current == nil ||
+		current.Status != shadowStatus || current.Message != shadowMessage {
+		needsStatusUpdate = true
+		if shadowedBy != nil {
+			r.Recorder.Event(&policy, corev1.EventTypeWarning, "Shadowed", shadowMessage)
+		}
+	}
+
+	// Update index with the policy
+	if err := r.Index.UpsertCluster(&policy, namespaces); err != nil {
+		log.Error(err, "Failed to index ClusterDnsPolicy")
+		return ctrl.Result{}, err
+	}
+	log.Info("ClusterDnsPolicy indexed", "name", req.Name, "namespaces", len(namespaces), "specHash", specHash)
+
+	// Update status if needed
+	if needsStatusUpdate {
+		r.updateCondition(&policy, "Ready", metav1.ConditionTrue, "Reconciled", "ClusterDnsPolicy successfully reconciled")
+		if len(overlaps) > 0 {
+			r.updateCondition(&policy, conditionRulesOverlap, metav1.ConditionTrue, "BlockListPrecedence",
+				fmt.Sprintf("blockList takes precedence over allowList for: %s", strings.Join(overlaps, ", ")))
+		} else {
+			r.updateCondition(&policy, conditionRulesOverlap, metav1.ConditionFalse, "NoOverlap",
+				"allowList and blockList do not overlap")
+		}
+		r.updateCondition(&policy, conditionShadowed, shadowStatus, shadowReason, shadowMessage)
+		if err := r.Status().Update(ctx, &policy); err != nil {
+			log.Error(err, "Failed to update status")
+			r.Recorder.Event(&policy, corev1.EventTypeWarning, "StatusUpdateFailed", fmt.Sprintf("Failed to update status: %v", err))
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(&policy, corev1.EventTypeNormal, "Reconciled", "ClusterDnsPolicy successfully reconciled")
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// shadowingPolicy returns the ClusterDnsPolicy with MergeMode Override that
+// replaces policy for all of its pods, or nil. Only cluster policies that
+// select every namespace policy selects, and every pod in them that policy
+// selects, are considered; DnsPolicies never shadow cluster policies.
+func (r *ClusterDnsPolicyReconciler) shadowingPolicy(ctx context.Context, policy *dnsv1beta1.ClusterDnsPolicy,
+	namespaces []string) (*PolicySource, error) {
+	labelHash, err := ComputeLabelSelectorHash(policy.Spec.TargetSelector)
+	if err != nil {
+		return nil, err
+	}
+	var peers dnsv1beta1.ClusterDnsPolicyList
+	if err := r.List(ctx, &peers); err != nil {
+		return nil, err
+	}
+
+	var candidates []mergeInput
+	for i := range peers.Items {
+		peer := &peers.Items[i]
+		if peer.Name == policy.Name || !peer.DeletionTimestamp.IsZero() ||
+			peer.Spec.MergeMode != dnsv1beta1.MergeModeOverride {
+			continue
+		}
+		if names := peer.Spec.Subject.ServiceAccountNames(); len(names) > 0 {
+			own := policy.Spec.Subject.ServiceAccountNames()
+			if len(own) == 0 || slices.ContainsFunc(own, func(name string) bool { return !slices.Contains(names, name) }) {
+				continue
+			}
+		}
+		if canonicalizeSelector(peer.Spec.TargetSelector) != nil {
+			if hash, err := ComputeLabelSelectorHash(peer.Spec.TargetSelector); err != nil || hash != labelHash {
+				continue
+			}
+		}
+		peerNamespaces, err := r.matchingNamespaces(ctx, &peer.Spec)
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(namespaces, func(namespace string) bool { return !slices.Contains(peerNamespaces, namespace) }) {
+			continue
+		}
+		candidates = append(candidates, clusterPolicyInput(peer))
+	}
+
+	return shadowingSource(clusterPolicyInput(policy), candidates), nil
+}
+
+// matchingNamespaces returns the names of the namespaces selected by the
+// namespaceSelector of a policy and the namespace fields of its subject.
+// Unset selectors select every namespace.
+func (r *ClusterDnsPolicyReconciler) matchingNamespaces(ctx context.Context, spec *dnsv1beta1.ClusterDnsPolicySpec) ([]string, error) {
+	selector := labels.Everything()
+	if spec.NamespaceSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(spec.NamespaceSelector); err != nil {
+			return nil, err
+		}
+	}
+
+	var namespaceList corev1.NamespaceList
+	if err := r.List(ctx, &namespaceList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	namespaces := make([]string, 0, len(namespaceList.Items))
+	for _, namespace := range namespaceList.Items {
+		if subjectSelectsNamespace(spec.Subject, &namespace) {
+			namespaces = append(namespaces, namespace.Name)
+		}
+	}
+	return namespaces, nil
+}
+
+// subjectSelectsNamespace reports whether the namespace and namespaceSelector
+// of an optional subject select a namespace.
+func subjectSelectsNamespace(subject *dnsv1beta1.Subject, namespace *corev1.Namespace) bool {
+	if subject == nil {
+		return true
+	}
+	if subject.Namespace != "" && subject.Namespace != namespace.Name {
+		return false
+	}
+	return selectorMatches(subject.NamespaceSelector, namespace.Labels)
+}
+
+// updateCondition updates a condition in the policy status
+func (r *ClusterDnsPolicyReconciler) updateCondition(policy *dnsv1beta1.ClusterDnsPolicy,
+	conditionType string, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&policy.Status.Conditions, policy.Generation, conditionType, status, reason, message)
+}
+
+// clusterPolicySpec returns the DnsPolicySpec equivalent of a cluster policy
+// so that the DnsPolicy validation, hashing and status helpers apply to it.
+func clusterPolicySpec(spec *dnsv1beta1.ClusterDnsPolicySpec) *dnsv1beta1.DnsPolicySpec {
+	return &dnsv1beta1.DnsPolicySpec{
+		TargetSelector: spec.TargetSelector,
+		Subject:        spec.Subject,
+		BlockList:      spec.BlockList,
+		AllowList:      spec.AllowList,
+		DefaultAction:  spec.DefaultAction,
+		Action:         spec.Action,
+		DryRun:         spec.DryRun,
+		Priority:       spec.Priority,
+		MergeMode:      spec.MergeMode,
+	}
+}
+
+// clusterPoliciesForNamespace requeues every ClusterDnsPolicy when a namespace
+// is created, relabeled or deleted, since any of them may now match it.
+func (r *ClusterDnsPolicyReconciler) clusterPoliciesForNamespace(ctx context.Context, _ client.Object) []reconcile.Request {
+	var policies dnsv1beta1.ClusterDnsPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list ClusterDnsPolicies")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(policies.Items))
+	for _, policy := range policies.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: policy.Name}})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterDnsPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Initialize the event recorder
+	r.Recorder = mgr.GetEventRecorderFor("clusterdnspolicy-controller")
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dnsv1beta1.ClusterDnsPolicy{}).
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.clusterPoliciesForNamespace),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		// An Override policy may shadow any other cluster policy; status
+		// updates cannot change that
+		Watches(&dnsv1beta1.ClusterDnsPolicy{}, handler.EnqueueRequestsFromMapFunc(r.clusterPoliciesForNamespace),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Named("clusterdnspolicy").
+		Complete(r)
+}
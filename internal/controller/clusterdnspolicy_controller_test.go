@@ -0,0 +1,77 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("ClusterDnsPolicy Controller", func() {
+	It("should mark a cluster policy overridden by a higher-priority cluster policy as shadowed", func() {
+		ctx := context.Background()
+		testScheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+		Expect(dnsv1beta1.AddToScheme(testScheme)).To(Succeed())
+		policy := func(name string, priority int32, mode dnsv1beta1.MergeMode) *dnsv1beta1.ClusterDnsPolicy {
+			return &dnsv1beta1.ClusterDnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Finalizers: []string{dnsPolicyFinalizer}},
+				Spec: dnsv1beta1.ClusterDnsPolicySpec{
+					Priority:  priority,
+					MergeMode: mode,
+					BlockList: []dnsv1beta1.DomainRule{{Pattern: "tracking.ads.net"}},
+				},
+			}
+		}
+		c := fake.NewClientBuilder().WithScheme(testScheme).WithObjects(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			policy("baseline", 0, dnsv1beta1.MergeModeAppend),
+			policy("incident-lockdown", 1000, dnsv1beta1.MergeModeOverride),
+		).WithStatusSubresource(&dnsv1beta1.ClusterDnsPolicy{}).Build()
+		r := &ClusterDnsPolicyReconciler{Client: c, Scheme: testScheme, Index: NewPolicyIndex(), Recorder: record.NewFakeRecorder(100)}
+
+		shadowed := func(name string) *metav1.Condition {
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+			Expect(err).NotTo(HaveOccurred())
+			var current dnsv1beta1.ClusterDnsPolicy
+			Expect(c.Get(ctx, types.NamespacedName{Name: name}, &current)).To(Succeed())
+			return meta.FindStatusCondition(current.Status.Conditions, conditionShadowed)
+		}
+
+		condition := shadowed("baseline")
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Message).To(Equal("overridden by ClusterDnsPolicy/incident-lockdown with priority 1000"))
+
+		condition = shadowed("incident-lockdown")
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+	})
+})
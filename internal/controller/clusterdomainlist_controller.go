@@ -0,0 +1,128 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// ClusterDomainListReconciler reconciles a ClusterDomainList object
+type ClusterDomainListReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Store    *DomainListStore
+	Feeds    *FeedFetcher
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=clusterdomainlists,verbs=get;list;watch
+// +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=clusterdomainlists/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile loads the sources of a ClusterDomainList into the Store, like
+// DomainListReconciler.
+func (r *ClusterDomainListReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	var list dnsv1beta1.ClusterDomainList
+	if err := r.Get(ctx, req.NamespacedName, &list); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Store.Delete(dnsv1beta1.DomainListKindCluster, "", req.Name)
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get ClusterDomainList")
+		return ctrl.Result{}, err
+	}
+
+	var result ctrl.Result
+	status := list.Status.DeepCopy()
+	if errs := ValidateClusterDomainListSpec(&list.Spec); len(errs) > 0 {
+		err := errs.ToAggregate()
+		log.Error(err, "Invalid ClusterDomainList spec")
+		r.Recorder.Event(&list, corev1.EventTypeWarning, "InvalidSpec", err.Error())
+		r.Store.Delete(dnsv1beta1.DomainListKindCluster, "", list.Name)
+		setCondition(&status.Conditions, list.Generation, "Ready", metav1.ConditionFalse, "InvalidSpec", err.Error())
+	} else {
+		rules, nextSync, err := loadSources(ctx, r, r.Feeds, "", list.Spec.Sources, status)
+		if err != nil {
+			log.Error(err, "Failed to load ClusterDomainList sources")
+			return ctrl.Result{}, err
+		}
+		result.RequeueAfter = nextSync
+		r.Store.Set(dnsv1beta1.DomainListKindCluster, "", list.Name, rules)
+		reportSourceStatus(r.Recorder, &list, list.Generation, status)
+	}
+	status.ObservedGeneration = list.Generation
+
+	if equality.Semantic.DeepEqual(&list.Status, status) {
+		return result, nil
+	}
+	list.Status = *status
+	if err := r.Status().Update(ctx, &list); err != nil {
+		log.Error(err, "Failed to update ClusterDomainList status")
+		return ctrl.Result{}, err
+	}
+	log.Info("ClusterDomainList loaded", "name", req.Name, "rules", status.RuleCount, "rulesHash", status.RulesHash)
+	return result, nil
+}
+
+// listsReadingSource requeues the ClusterDomainLists that read a changed
+// ConfigMap or Secret.
+func (r *ClusterDomainListReconciler) listsReadingSource(ctx context.Context, obj client.Object) []reconcile.Request {
+	var lists dnsv1beta1.ClusterDomainListList
+	if err := r.List(ctx, &lists); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list ClusterDomainLists")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range lists.Items {
+		if readsSource(lists.Items[i].Spec.Sources, obj, "") {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&lists.Items[i])})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterDomainListReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.Recorder = mgr.GetEventRecorderFor("clusterdomainlist-controller")
+	if r.Feeds == nil {
+		r.Feeds = NewFeedFetcher(NewFeedClient(DefaultFeedTimeout))
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dnsv1beta1.ClusterDomainList{}).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.listsReadingSource)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.listsReadingSource)).
+		Named("clusterdomainlist").
+		Complete(r)
+}
@@ -0,0 +1,335 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+const (
+	// DefaultCoreDNSClusterDomain is the cluster domain served by the
+	// kubernetes plugin of generated server blocks.
+	DefaultCoreDNSClusterDomain = "cluster.local"
+	// DefaultCoreDNSUpstream is where generated server blocks forward
+	// queries that no rule answers.
+	DefaultCoreDNSUpstream = "/etc/resolv.conf"
+
+	// coreDNSTTL is the TTL of sinkhole answers.
+	coreDNSTTL = 300
+)
+
+// CoreDNSOptions are the plugins every generated server block ends with.
+type CoreDNSOptions struct {
+	// ClusterDomain is served by the kubernetes plugin.
+	ClusterDomain string
+	// Upstream is the forward plugin's destination.
+	Upstream string
+}
+
+// CoreDNSView is a group of clients that share an effective policy.
+type CoreDNSView struct {
+	// Name is the name of the view, unique in the Corefile.
+	Name string
+	// ClientIPs are the source addresses the view applies to.
+	ClientIPs []string
+	// Effective is the policy enforced for the clients.
+	Effective *EffectivePolicy
+}
+
+// CoreDNSRendering is a view rendered as CoreDNS server blocks.
+type CoreDNSRendering struct {
+	// Text holds the server blocks.
+	Text string
+	// Skipped counts the rules that CoreDNS cannot express.
+	Skipped int
+}
+
+// RenderCoreDNSView writes the server blocks enforcing a view's effective
+// policy for its clients. Each block selects the clients with the view
+// plugin and answers blocked names with template plugins:
+//
+//   - NXDOMAIN, REFUSED and NODATA become the rcode of the template, and
+//     Sinkhole answers A and AAAA queries with the sinkhole addresses;
+//   - every match type and qtypes are supported; Regex rules are matched
+//     against the query name with its trailing dot;
+//   - DefaultAction Deny answers every other name in the "." block, and
+//     each allowed name gets a block for its zone that CoreDNS prefers over
+//     "." and that still applies the block rules, since blocking wins.
+//
+// Dry-run rules are left out, as are rules expired at now. Allow rules
+// other than Exact, Suffix and leading-label Wildcards are skipped.
+func RenderCoreDNSView(view *CoreDNSView, opts CoreDNSOptions, now time.Time) CoreDNSRendering {
+	effective := view.Effective
+	w := &coreDNSWriter{}
+	if effective.DryRun {
+		return CoreDNSRendering{}
+	}
+
+	var blockTemplates []coreDNSTemplates
+	for i := range effective.BlockList {
+		rule := &effective.BlockList[i]
+		if rule.DryRun || (rule.ExpiresAt != nil && !now.Before(rule.ExpiresAt.Time)) {
+			continue
+		}
+		zone, regex, ok := coreDNSMatch(&rule.DomainRule)
+		if !ok {
+			w.skipped++
+			continue
+		}
+		var b strings.Builder
+		writeTemplates(&b, zone, regex, rule.QTypes, rule.Action, sourcesNote(rule.Sources))
+		blockTemplates = append(blockTemplates, coreDNSTemplates{zone: zone, text: b.String()})
+	}
+
+	deny := effective.DefaultAction == dnsv1beta1.DefaultActionDeny
+	var zones []*allowedZone
+	if deny {
+		zones = w.allowedZones(effective.AllowList, now)
+	}
+
+	fmt.Fprintf(&w.b, "# %s: %s\n", view.Name, sourcesNote(effective.Sources))
+	for _, zone := range append([]*allowedZone{{name: "."}}, zones...) {
+		w.server(zone.name, view, opts, func(b *strings.Builder) {
+			// Only the templates of zones overlapping the server block can match
+			for _, templates := range blockTemplates {
+				if zonesOverlap(zone.name, templates.zone) {
+					b.WriteString(templates.text)
+				}
+			}
+			if !deny {
+				return
+			}
+			quoted := regexName(zone.name)
+			switch {
+			case zone.name == ".":
+				writeTemplates(b, ".", "", nil, effective.Action, "defaultAction Deny")
+			case !zone.apex:
+				writeTemplates(b, zone.name, "^"+quoted+"$", nil, effective.Action, "defaultAction Deny")
+			case !zone.subdomains:
+				writeTemplates(b, zone.name, "^.+[.]"+quoted+"$", nil, effective.Action, "defaultAction Deny")
+			}
+		})
+	}
+	return CoreDNSRendering{Text: w.b.String(), Skipped: w.skipped}
+}
+
+// coreDNSTemplates are the templates of a block rule and their zone.
+type coreDNSTemplates struct {
+	zone string
+	text string
+}
+
+// coreDNSWriter collects the server blocks of a view.
+type coreDNSWriter struct {
+	b       strings.Builder
+	skipped int
+}
+
+// server writes a server block for zone that applies to the clients of view.
+func (w *coreDNSWriter) server(zone string, view *CoreDNSView, opts CoreDNSOptions, templates func(*strings.Builder)) {
+	fmt.Fprintf(&w.b, "%s:53 {\n", zone)
+	fmt.Fprintf(&w.b, "    view %s {\n", view.Name)
+	quoted := make([]string, 0, len(view.ClientIPs))
+	for _, ip := range view.ClientIPs {
+		quoted = append(quoted, "'"+ip+"'")
+	}
+	fmt.Fprintf(&w.b, "        expr client_ip() in [%s]\n", strings.Join(quoted, ", "))
+	w.b.WriteString("    }\n")
+	w.b.WriteString("    errors\n")
+	templates(&w.b)
+	switch {
+	case zone == ".":
+		fmt.Fprintf(&w.b, "    kubernetes %s in-addr.arpa ip6.arpa {\n", opts.ClusterDomain)
+		w.b.WriteString("        pods insecure\n")
+		w.b.WriteString("        fallthrough in-addr.arpa ip6.arpa\n")
+		w.b.WriteString("    }\n")
+	case zonesOverlap(zone, opts.ClusterDomain):
+		// Allowed cluster names are still answered by the kubernetes plugin
+		fmt.Fprintf(&w.b, "    kubernetes %s {\n", opts.ClusterDomain)
+		w.b.WriteString("        pods insecure\n")
+		w.b.WriteString("    }\n")
+	}
+	fmt.Fprintf(&w.b, "    forward . %s\n", opts.Upstream)
+	w.b.WriteString("    cache 30\n")
+	w.b.WriteString("}\n")
+}
+
+// allowedZone is a zone with allowed names under a DefaultAction Deny.
+type allowedZone struct {
+	name       string
+	apex       bool
+	subdomains bool
+}
+
+// allowedZones groups the allow rules by zone, dropping zones whose names
+// are all allowed by a parent zone.
+func (w *coreDNSWriter) allowedZones(rules []EffectiveRule, now time.Time) []*allowedZone {
+	byName := map[string]*allowedZone{}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.DryRun || (rule.ExpiresAt != nil && !now.Before(rule.ExpiresAt.Time)) {
+			continue
+		}
+		if len(rule.QTypes) > 0 {
+			w.skipped++
+			continue
+		}
+		pattern := CanonicalDomain(rule.Pattern)
+		name, apex, subdomains := pattern, true, false
+		switch rule.EffectiveMatchType() {
+		case dnsv1beta1.MatchTypeExact:
+		case dnsv1beta1.MatchTypeSuffix:
+			subdomains = true
+		case dnsv1beta1.MatchTypeWildcard:
+			var ok bool
+			if name, ok = strings.CutPrefix(pattern, "*."); !ok || strings.Contains(name, "*") {
+				w.skipped++
+				continue
+			}
+			apex, subdomains = false, true
+		default:
+			w.skipped++
+			continue
+		}
+		zone := byName[name]
+		if zone == nil {
+			zone = &allowedZone{name: name}
+			byName[name] = zone
+		}
+		zone.apex = zone.apex || apex
+		zone.subdomains = zone.subdomains || subdomains
+	}
+
+	var zones []*allowedZone
+	for name, zone := range byName {
+		covered := false
+		for parent := name; !covered; {
+			var ok bool
+			if _, parent, ok = strings.Cut(parent, "."); !ok {
+				break
+			}
+			covered = byName[parent] != nil && byName[parent].subdomains
+		}
+		if !covered {
+			zones = append(zones, zone)
+		}
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].name < zones[j].name })
+	return zones
+}
+
+// coreDNSMatch returns the zone and query name expression of a template
+// matching a block rule, or false if the rule cannot be expressed.
+func coreDNSMatch(rule *dnsv1beta1.DomainRule) (string, string, bool) {
+	pattern := CanonicalDomain(rule.Pattern)
+	switch rule.EffectiveMatchType() {
+	case dnsv1beta1.MatchTypeExact:
+		return pattern, "^" + regexName(pattern) + "$", true
+	case dnsv1beta1.MatchTypeSuffix:
+		return pattern, "^(.+[.])?" + regexName(pattern) + "$", true
+	case dnsv1beta1.MatchTypeWildcard:
+		labels := strings.Split(pattern, ".")
+		// The zone is the part of the pattern after its last wildcard
+		zone := strings.TrimPrefix(pattern[strings.LastIndex(pattern, "*")+1:], ".")
+		if zone == "" {
+			zone = "."
+		}
+		for i, label := range labels {
+			if label == "*" {
+				labels[i] = ".+"
+			}
+		}
+		return zone, "^" + strings.Join(labels, "[.]") + "[.]$", true
+	case dnsv1beta1.MatchTypeRegex:
+		// The Corefile quotes the expression, so it may not contain quotes
+		if strings.ContainsAny(rule.Pattern, "\"\n") {
+			return "", "", false
+		}
+		regex := rule.Pattern
+		if strings.HasSuffix(regex, "$") && !strings.HasSuffix(regex, `\$`) {
+			regex = strings.TrimSuffix(regex, "$") + `[.]$`
+		}
+		return ".", regex, true
+	}
+	return "", "", false
+}
+
+// zonesOverlap reports whether one zone contains the other.
+func zonesOverlap(a, b string) bool {
+	return a == "." || b == "." || a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
+
+// regexName returns a domain as an expression matching it with the
+// trailing dot of query names. Dots are written as [.] since the Corefile
+// parser treats backslashes specially.
+func regexName(name string) string {
+	return strings.ReplaceAll(name, ".", "[.]") + "[.]"
+}
+
+// writeTemplates writes the template plugins answering the query types of
+// a rule with action. An empty regex matches every name in zone.
+func writeTemplates(b *strings.Builder, zone, regex string, qtypes []string, action *dnsv1beta1.BlockAction, note string) {
+	if len(qtypes) == 0 {
+		qtypes = []string{"ANY"}
+	}
+	actionType := dnsv1beta1.BlockActionNXDomain
+	if action != nil && action.Type != "" {
+		actionType = action.Type
+	}
+	for _, qtype := range qtypes {
+		qtype = strings.ToUpper(qtype)
+		switch actionType {
+		case dnsv1beta1.BlockActionRefused:
+			writeTemplate(b, qtype, zone, regex, "rcode REFUSED", note)
+		case dnsv1beta1.BlockActionNoData:
+			writeTemplate(b, qtype, zone, regex, "rcode NOERROR", note)
+		case dnsv1beta1.BlockActionSinkhole:
+			// Sinkhole A and AAAA queries; other types get an empty answer
+			answered := false
+			for _, answer := range []struct{ qtype, address string }{
+				{"A", action.SinkholeIPv4}, {"AAAA", action.SinkholeIPv6},
+			} {
+				if answer.address != "" && (qtype == "ANY" || qtype == answer.qtype) {
+					writeTemplate(b, answer.qtype, zone, regex, fmt.Sprintf(
+						`answer "{{ .Name }} %d IN %s %s"`, coreDNSTTL, answer.qtype, answer.address), note)
+					answered = qtype == answer.qtype
+				}
+			}
+			if !answered {
+				writeTemplate(b, qtype, zone, regex, "rcode NOERROR", note)
+			}
+		default:
+			writeTemplate(b, qtype, zone, regex, "rcode NXDOMAIN", note)
+		}
+	}
+}
+
+func writeTemplate(b *strings.Builder, qtype, zone, regex, answer, note string) {
+	fmt.Fprintf(b, "    # %s\n", note)
+	fmt.Fprintf(b, "    template IN %s %s {\n", qtype, zone)
+	if regex != "" {
+		fmt.Fprintf(b, "        match \"%s\"\n", regex)
+	}
+	fmt.Fprintf(b, "        %s\n", answer)
+	b.WriteString("        fallthrough\n")
+	b.WriteString("    }\n")
+}
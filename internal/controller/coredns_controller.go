@@ -0,0 +1,304 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+const (
+	// CoreDNSNamespaceLabel opts the pods of a namespace into enforcement
+	// by CoreDNS instead of the sidecar, with the value "enabled".
+	CoreDNSNamespaceLabel = "dns.dnspolicies.io/coredns"
+
+	// DefaultCoreDNSKey is the ConfigMap key holding the server blocks.
+	DefaultCoreDNSKey = "dns-mesh.server"
+
+	// conditionRenderedToCoreDNS reports whether a DnsPolicy is enforced by CoreDNS.
+	conditionRenderedToCoreDNS = "RenderedToCoreDNS"
+)
+
+// CoreDNSReconciler renders the effective policies of the pods in
+// namespaces labeled with CoreDNSNamespaceLabel into a ConfigMap key that
+// CoreDNS imports, for workloads that cannot run the sidecar. Every pod
+// IP is matched by the view plugin of the server blocks enforcing its
+// effective policy, so pods sharing a policy share a view.
+type CoreDNSReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Index    *PolicyIndex
+	Recorder record.EventRecorder
+	// ConfigMap is the ConfigMap the server blocks are written to. Other
+	// keys of the ConfigMap are left alone.
+	ConfigMap types.NamespacedName
+	// Key is the ConfigMap key holding the server blocks.
+	Key string
+	// Options sets the plugins that forward unblocked queries.
+	Options CoreDNSOptions
+	// now returns the current time; tests replace it.
+	now func() time.Time
+}
+
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=dnspolicies/status,verbs=get;update;patch
+
+// Reconcile renders every CoreDNS namespace into the ConfigMap and reports
+// on each DnsPolicy whether it was rendered. All events map to a single
+// request for the ConfigMap.
+func (r *CoreDNSReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+	now := time.Now()
+	if r.now != nil {
+		now = r.now()
+	}
+
+	var namespaces corev1.NamespaceList
+	if err := r.List(ctx, &namespaces, client.MatchingLabels{CoreDNSNamespaceLabel: "enabled"}); err != nil {
+		log.Error(err, "Failed to list CoreDNS namespaces")
+		return ctrl.Result{}, err
+	}
+
+	// Group the pods of the CoreDNS namespaces by effective policy
+	views := map[string]*CoreDNSView{}
+	podsBySource := map[PolicySource]int{}
+	shadowedBySource := map[PolicySource]int{}
+	enabled := map[string]bool{}
+	for _, ns := range namespaces.Items {
+		enabled[ns.Name] = true
+		var pods corev1.PodList
+		if err := r.List(ctx, &pods, client.InNamespace(ns.Name)); err != nil {
+			log.Error(err, "Failed to list pods", "namespace", ns.Name)
+			return ctrl.Result{}, err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.Spec.HostNetwork || len(pod.Status.PodIPs) == 0 ||
+				pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			podLabels := labels.Set(pod.Labels)
+			policies := r.Index.Resolve(pod.Namespace, pod.Spec.ServiceAccountName, podLabels)
+			clusterPolicies := r.Index.ResolveCluster(pod.Namespace, pod.Spec.ServiceAccountName, podLabels)
+			if len(policies) == 0 && len(clusterPolicies) == 0 {
+				continue
+			}
+			effective := MergePolicies(policies, clusterPolicies)
+			key := sourcesNote(effective.Sources)
+			view := views[key]
+			if view == nil {
+				sum := sha256.Sum256([]byte(key))
+				view = &CoreDNSView{Name: "dns-mesh-" + hex.EncodeToString(sum[:])[:10], Effective: effective}
+				views[key] = view
+			}
+			for _, ip := range pod.Status.PodIPs {
+				view.ClientIPs = append(view.ClientIPs, ip.IP)
+			}
+			for _, source := range effective.Sources {
+				podsBySource[source]++
+			}
+			for _, source := range effective.Shadowed {
+				shadowedBySource[source]++
+			}
+		}
+	}
+
+	// Render the views in a stable order
+	ordered := make([]*CoreDNSView, 0, len(views))
+	for _, view := range views {
+		sort.Strings(view.ClientIPs)
+		ordered = append(ordered, view)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Name < ordered[j].Name })
+	var text strings.Builder
+	text.WriteString("# Generated by dns-mesh-controller from the DnsPolicies of namespaces\n")
+	fmt.Fprintf(&text, "# labeled %s=enabled. Do not edit.\n", CoreDNSNamespaceLabel)
+	skippedBySource := map[PolicySource]int{}
+	var requeueAfter time.Duration
+	for _, view := range ordered {
+		rendering := RenderCoreDNSView(view, r.Options, now)
+		text.WriteString(rendering.Text)
+		for _, rules := range [][]EffectiveRule{view.Effective.BlockList, view.Effective.AllowList} {
+			for _, rule := range rules {
+				if rule.ExpiresAt != nil && rule.ExpiresAt.After(now) {
+					requeueAfter = minPositive(requeueAfter, rule.ExpiresAt.Sub(now))
+				}
+			}
+		}
+		for _, source := range view.Effective.Sources {
+			skippedBySource[source] = max(skippedBySource[source], rendering.Skipped)
+		}
+	}
+
+	if err := r.writeConfigMap(ctx, text.String()); err != nil {
+		log.Error(err, "Failed to write CoreDNS ConfigMap", "configMap", r.ConfigMap)
+		return ctrl.Result{}, err
+	}
+
+	// Report the outcome on every DnsPolicy of a CoreDNS namespace
+	var policies dnsv1beta1.DnsPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		log.Error(err, "Failed to list DnsPolicies")
+		return ctrl.Result{}, err
+	}
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		source := PolicySource{Kind: KindDnsPolicy, Namespace: policy.Namespace, Name: policy.Name, Priority: policy.Spec.Priority}
+		var status metav1.ConditionStatus
+		var reason, message string
+		switch pods := podsBySource[source]; {
+		case !enabled[policy.Namespace]:
+		case policy.Spec.DryRun:
+			status, reason = metav1.ConditionFalse, "DryRun"
+			message = "dry-run policies are only logged by the sidecar"
+		case pods == 0 && shadowedBySource[source] > 0:
+			status, reason = metav1.ConditionFalse, "Shadowed"
+			message = "a higher-priority Override policy is rendered for the selected pods"
+		case pods == 0:
+			status, reason = metav1.ConditionFalse, "NoPods"
+			message = "no running pod in the namespace is selected by the policy"
+		default:
+			status, reason = metav1.ConditionTrue, "Rendered"
+			message = fmt.Sprintf("rendered into %s/%s key %s for %d pods", r.ConfigMap.Namespace, r.ConfigMap.Name, r.Key, pods)
+			if skipped := skippedBySource[source]; skipped > 0 {
+				message += fmt.Sprintf("; %d rules cannot be expressed in CoreDNS and were skipped", skipped)
+			}
+		}
+		if err := r.updatePolicyCondition(ctx, policy, status, reason, message); err != nil {
+			log.Error(err, "Failed to update DnsPolicy status", "name", client.ObjectKeyFromObject(policy))
+			return ctrl.Result{}, err
+		}
+	}
+
+	log.Info("Rendered CoreDNS server blocks", "views", len(ordered), "configMap", r.ConfigMap)
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// writeConfigMap stores the server blocks under the key, creating the
+// ConfigMap when it does not exist yet.
+func (r *CoreDNSReconciler) writeConfigMap(ctx context.Context, text string) error {
+	var configMap corev1.ConfigMap
+	err := r.Get(ctx, r.ConfigMap, &configMap)
+	if apierrors.IsNotFound(err) {
+		configMap = corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: r.ConfigMap.Name, Namespace: r.ConfigMap.Namespace},
+			Data:       map[string]string{r.Key: text},
+		}
+		return r.Create(ctx, &configMap)
+	}
+	if err != nil {
+		return err
+	}
+	if current, ok := configMap.Data[r.Key]; ok && current == text {
+		return nil
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[r.Key] = text
+	return r.Update(ctx, &configMap)
+}
+
+// updatePolicyCondition sets the RenderedToCoreDNS condition of a policy,
+// or removes it when status is empty, retrying on conflicts with the
+// DnsPolicy reconciler.
+func (r *CoreDNSReconciler) updatePolicyCondition(ctx context.Context, policy *dnsv1beta1.DnsPolicy,
+	status metav1.ConditionStatus, reason, message string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current := meta.FindStatusCondition(policy.Status.Conditions, conditionRenderedToCoreDNS)
+		switch {
+		case status == "" && current == nil:
+			return nil
+		case status == "":
+			meta.RemoveStatusCondition(&policy.Status.Conditions, conditionRenderedToCoreDNS)
+		case current != nil && current.Status == status && current.Reason == reason &&
+			current.Message == message && current.ObservedGeneration == policy.Generation:
+			return nil
+		default:
+			setCondition(&policy.Status.Conditions, policy.Generation, conditionRenderedToCoreDNS, status, reason, message)
+			if current == nil || current.Status != status {
+				eventType := corev1.EventTypeNormal
+				if status == metav1.ConditionFalse {
+					eventType = corev1.EventTypeWarning
+				}
+				r.Recorder.Event(policy, eventType, "CoreDNS"+reason, message)
+			}
+		}
+		err := r.Status().Update(ctx, policy)
+		if apierrors.IsConflict(err) {
+			if getErr := r.Get(ctx, client.ObjectKeyFromObject(policy), policy); getErr != nil {
+				return getErr
+			}
+		}
+		return err
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CoreDNSReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.Recorder = mgr.GetEventRecorderFor("coredns-controller")
+	if r.Key == "" {
+		r.Key = DefaultCoreDNSKey
+	}
+
+	render := handler.EnqueueRequestsFromMapFunc(r.renderRequest)
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("coredns").
+		Watches(&corev1.Pod{}, render).
+		Watches(&corev1.Namespace{}, render).
+		Watches(&dnsv1beta1.DnsPolicy{}, render).
+		Watches(&dnsv1beta1.ClusterDnsPolicy{}, render).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.configMapRequest)).
+		Complete(r)
+}
+
+// renderRequest maps every event to the single request rendering the ConfigMap.
+func (r *CoreDNSReconciler) renderRequest(_ context.Context, _ client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: r.ConfigMap}}
+}
+
+// configMapRequest re-renders the ConfigMap when it is edited or deleted.
+func (r *CoreDNSReconciler) configMapRequest(ctx context.Context, obj client.Object) []reconcile.Request {
+	if client.ObjectKeyFromObject(obj) != r.ConfigMap {
+		return nil
+	}
+	return r.renderRequest(ctx, obj)
+}
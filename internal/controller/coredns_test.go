@@ -0,0 +1,262 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("CoreDNS rendering", func() {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	options := CoreDNSOptions{ClusterDomain: DefaultCoreDNSClusterDomain, Upstream: DefaultCoreDNSUpstream}
+
+	render := func(spec dnsv1beta1.DnsPolicySpec) CoreDNSRendering {
+		policy := &dnsv1beta1.DnsPolicy{ObjectMeta: metav1.ObjectMeta{Name: "ads", Namespace: "default"}, Spec: spec}
+		return RenderCoreDNSView(&CoreDNSView{
+			Name:      "dns-mesh-test",
+			ClientIPs: []string{"10.0.0.5", "fd00::5"},
+			Effective: MergePolicies([]*dnsv1beta1.DnsPolicy{policy}, nil),
+		}, options, now)
+	}
+
+	It("should answer blocked names with templates in a view of the clients", func() {
+		rendering := render(dnsv1beta1.DnsPolicySpec{
+			BlockList: []dnsv1beta1.DomainRule{{Pattern: "Ads.Example.com."}},
+		})
+		Expect(rendering.Skipped).To(BeZero())
+		Expect(rendering.Text).To(Equal(`# dns-mesh-test: DnsPolicy/default/ads
+.:53 {
+    view dns-mesh-test {
+        expr client_ip() in ['10.0.0.5', 'fd00::5']
+    }
+    errors
+    # DnsPolicy/default/ads
+    template IN ANY ads.example.com {
+        match "^ads[.]example[.]com[.]$"
+        rcode NXDOMAIN
+        fallthrough
+    }
+    kubernetes cluster.local in-addr.arpa ip6.arpa {
+        pods insecure
+        fallthrough in-addr.arpa ip6.arpa
+    }
+    forward . /etc/resolv.conf
+    cache 30
+}
+`))
+	})
+
+	It("should translate match types, query types and actions", func() {
+		expired := metav1.NewTime(now.Add(-time.Hour))
+		rendering := render(dnsv1beta1.DnsPolicySpec{
+			BlockList: []dnsv1beta1.DomainRule{
+				{Pattern: "tracker.example.net", MatchType: dnsv1beta1.MatchTypeSuffix,
+					Action: &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionNoData}},
+				{Pattern: "api.*.example.org", MatchType: dnsv1beta1.MatchTypeWildcard,
+					Action: &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionRefused}},
+				{Pattern: "sinkhole.example.org", QTypes: []string{"a", "TXT"}, Action: &dnsv1beta1.BlockAction{
+					Type: dnsv1beta1.BlockActionSinkhole, SinkholeIPv4: "10.0.0.1",
+				}},
+				{Pattern: `^ads[0-9]+\.example\.com$`, MatchType: dnsv1beta1.MatchTypeRegex},
+				{Pattern: "old.example.com", ExpiresAt: &expired},
+			},
+		})
+		Expect(rendering.Text).To(ContainSubstring(`    template IN ANY tracker.example.net {
+        match "^(.+[.])?tracker[.]example[.]net[.]$"
+        rcode NOERROR
+`))
+		Expect(rendering.Text).To(ContainSubstring(`    template IN ANY example.org {
+        match "^api[.].+[.]example[.]org[.]$"
+        rcode REFUSED
+`))
+		Expect(rendering.Text).To(ContainSubstring(`    template IN A sinkhole.example.org {
+        match "^sinkhole[.]example[.]org[.]$"
+        answer "{{ .Name }} 300 IN A 10.0.0.1"
+`))
+		Expect(rendering.Text).To(ContainSubstring(`    template IN TXT sinkhole.example.org {
+        match "^sinkhole[.]example[.]org[.]$"
+        rcode NOERROR
+`))
+		Expect(rendering.Text).To(ContainSubstring(`    template IN ANY . {
+        match "^ads[0-9]+\.example\.com[.]$"
+`))
+		Expect(rendering.Text).NotTo(ContainSubstring("old.example.com"))
+	})
+
+	It("should add a server block per allowed zone under a default deny", func() {
+		rendering := render(dnsv1beta1.DnsPolicySpec{
+			DefaultAction: dnsv1beta1.DefaultActionDeny,
+			BlockList:     []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}},
+			AllowList: []dnsv1beta1.DomainRule{
+				{Pattern: "example.com", MatchType: dnsv1beta1.MatchTypeSuffix},
+				{Pattern: "www.example.com"},
+				{Pattern: "*.svc.cluster.local"},
+				{Pattern: "txt.example.net", QTypes: []string{"TXT"}},
+			},
+		})
+		Expect(rendering.Skipped).To(Equal(1))
+		blocks := strings.Split(rendering.Text, "\n}\n")
+		Expect(blocks).To(HaveLen(4))
+		Expect(blocks[0]).To(ContainSubstring(`    # defaultAction Deny
+    template IN ANY . {
+        rcode NXDOMAIN
+`))
+		Expect(blocks[1]).To(HavePrefix("example.com:53 {"))
+		Expect(blocks[1]).To(ContainSubstring("template IN ANY ads.example.com {"))
+		Expect(blocks[1]).NotTo(ContainSubstring("defaultAction Deny"))
+		Expect(blocks[2]).To(HavePrefix("svc.cluster.local:53 {"))
+		Expect(blocks[2]).NotTo(ContainSubstring("ads.example.com"))
+		Expect(blocks[2]).To(ContainSubstring(`    template IN ANY svc.cluster.local {
+        match "^svc[.]cluster[.]local[.]$"
+`))
+		Expect(blocks[2]).To(ContainSubstring("    kubernetes cluster.local {"))
+		Expect(blocks[3]).To(BeEmpty())
+	})
+
+	It("should leave dry-run policies out", func() {
+		rendering := render(dnsv1beta1.DnsPolicySpec{
+			DryRun:    true,
+			BlockList: []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}},
+		})
+		Expect(rendering.Text).To(BeEmpty())
+	})
+
+	Context("reconciled into a ConfigMap", func() {
+		var (
+			c      client.Client
+			r      *CoreDNSReconciler
+			target = types.NamespacedName{Namespace: "kube-system", Name: "coredns-custom"}
+		)
+
+		pod := func(name, ip string, podLabels map[string]string) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "legacy", Labels: podLabels},
+				Status: corev1.PodStatus{
+					Phase:  corev1.PodRunning,
+					PodIPs: []corev1.PodIP{{IP: ip}},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			testScheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+			Expect(dnsv1beta1.AddToScheme(testScheme)).To(Succeed())
+			policies := []*dnsv1beta1.DnsPolicy{{
+				ObjectMeta: metav1.ObjectMeta{Name: "ads", Namespace: "legacy"},
+				Spec: dnsv1beta1.DnsPolicySpec{
+					TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+					BlockList:      []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}},
+				},
+			}, {
+				ObjectMeta: metav1.ObjectMeta{Name: "unused", Namespace: "legacy"},
+				Spec: dnsv1beta1.DnsPolicySpec{
+					TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				},
+			}}
+			index := NewPolicyIndex()
+			objects := []client.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name: "legacy", Labels: map[string]string{CoreDNSNamespaceLabel: "enabled"},
+				}},
+				pod("web-1", "10.0.0.7", map[string]string{"app": "web"}),
+				pod("web-0", "10.0.0.6", map[string]string{"app": "web"}),
+				pod("other", "10.0.0.8", nil),
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: target.Name, Namespace: target.Namespace},
+					Data:       map[string]string{"other.server": "example.org:53 {}\n"},
+				},
+			}
+			for _, policy := range policies {
+				hash, err := SelectorHashFor(&policy.Spec)
+				Expect(err).NotTo(HaveOccurred())
+				index.Upsert(policy, hash)
+				objects = append(objects, policy)
+			}
+			c = fake.NewClientBuilder().WithScheme(testScheme).WithObjects(objects...).
+				WithStatusSubresource(&dnsv1beta1.DnsPolicy{}).Build()
+			r = &CoreDNSReconciler{
+				Client:    c,
+				Scheme:    testScheme,
+				Index:     index,
+				Recorder:  record.NewFakeRecorder(100),
+				ConfigMap: target,
+				Key:       DefaultCoreDNSKey,
+				Options:   options,
+			}
+		})
+
+		condition := func(name string) *metav1.Condition {
+			var policy dnsv1beta1.DnsPolicy
+			ExpectWithOffset(1, c.Get(ctx, types.NamespacedName{Namespace: "legacy", Name: name}, &policy)).To(Succeed())
+			return meta.FindStatusCondition(policy.Status.Conditions, conditionRenderedToCoreDNS)
+		}
+
+		It("should render the selected pods and report it on each policy", func() {
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: target})
+			Expect(err).NotTo(HaveOccurred())
+
+			var configMap corev1.ConfigMap
+			Expect(c.Get(ctx, target, &configMap)).To(Succeed())
+			Expect(configMap.Data).To(HaveKeyWithValue("other.server", "example.org:53 {}\n"))
+			Expect(configMap.Data[DefaultCoreDNSKey]).To(ContainSubstring("expr client_ip() in ['10.0.0.6', '10.0.0.7']"))
+			Expect(configMap.Data[DefaultCoreDNSKey]).To(ContainSubstring(`match "^ads[.]example[.]com[.]$"`))
+
+			rendered := condition("ads")
+			Expect(rendered).NotTo(BeNil())
+			Expect(rendered.Status).To(Equal(metav1.ConditionTrue))
+			Expect(rendered.Message).To(ContainSubstring("for 2 pods"))
+			unused := condition("unused")
+			Expect(unused).NotTo(BeNil())
+			Expect(unused.Reason).To(Equal("NoPods"))
+		})
+
+		It("should remove the condition when the namespace leaves CoreDNS", func() {
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: target})
+			Expect(err).NotTo(HaveOccurred())
+
+			var ns corev1.Namespace
+			Expect(c.Get(ctx, types.NamespacedName{Name: "legacy"}, &ns)).To(Succeed())
+			ns.Labels = nil
+			Expect(c.Update(ctx, &ns)).To(Succeed())
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: target})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(condition("ads")).To(BeNil())
+			var configMap corev1.ConfigMap
+			Expect(c.Get(ctx, target, &configMap)).To(Succeed())
+			Expect(configMap.Data[DefaultCoreDNSKey]).NotTo(ContainSubstring("view"))
+		})
+	})
+})
@@ -18,24 +18,63 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
-	dnsv1alpha1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1alpha1"
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
 )
 
 const (
 	dnsPolicyFinalizer = "dns.dnspolicies.io/finalizer"
+
+	// conditionRulesOverlap reports patterns present in both AllowList and BlockList.
+	conditionRulesOverlap = "RulesOverlap"
+
+	// conditionShadowed reports a higher-priority Override policy that replaces this one.
+	conditionShadowed = "Shadowed"
+
+	// domainListRetryInterval is how long to wait for the sources of a referenced list to load.
+	domainListRetryInterval = 5 * time.Second
+
+	// conditionRPZExported reports whether spec.export.rpz was written to its ConfigMap.
+	conditionRPZExported = "RPZExported"
+
+	// conditionSubjectKeysIgnored reports v1alpha1 subject keys that never match a pod.
+	conditionSubjectKeysIgnored = "SubjectKeysIgnored"
+
+	// rpzExportLabel marks the ConfigMaps written for spec.export.rpz with the policy name.
+	rpzExportLabel = "dns.dnspolicies.io/rpz-export"
+	// rpzSerialAnnotation and rpzRecordsAnnotation record the SOA serial of an
+	// exported zone and the hash of its records, so the serial only changes
+	// with the records.
+	rpzSerialAnnotation  = "dns.dnspolicies.io/rpz-serial"
+	rpzRecordsAnnotation = "dns.dnspolicies.io/rpz-records-hash"
 )
 
 // DnsPolicyReconciler reconciles a DnsPolicy object
@@ -44,23 +83,30 @@ type DnsPolicyReconciler struct {
 	Scheme   *runtime.Scheme
 	Index    *PolicyIndex
 	Recorder record.EventRecorder
+	// DomainLists holds the rules loaded from the sources of referenced lists.
+	DomainLists *DomainListStore
 }
 
 // +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=dnspolicies,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=dnspolicies/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=dnspolicies/finalizers,verbs=update
+// +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=domainlists;clusterdomainlists,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile reconciles a DnsPolicy object by:
-// 1. Computing hashes of the targetSelector and full spec
-// 2. Updating the status with computed hashes
-// 3. Indexing the policy for efficient client lookups by hash
-// 4. Handling deletions by removing from index
+// 1. Adding the rules of referenced DomainLists to the spec
+// 2. Computing hashes of the targetSelector and full spec
+// 3. Updating the status with computed hashes and the Shadowed condition
+// 4. Indexing the policy with its expanded spec for efficient client lookups by hash
+// 5. Writing the RPZ export of the policy into its ConfigMap
+// 6. Handling deletions by removing from index
 func (r *DnsPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := logf.FromContext(ctx)
 
 	// Fetch the DnsPolicy instance
-	var policy dnsv1alpha1.DnsPolicy
+	var policy dnsv1beta1.DnsPolicy
 	if err := r.Get(ctx, req.NamespacedName, &policy); err != nil {
 		if apierrors.IsNotFound(err) {
 			// Policy was deleted - remove from index
@@ -103,32 +149,35 @@ func (r *DnsPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, nil
 	}
 
-	// Validate targetSelector is not empty
-	if len(policy.Spec.TargetSelector) == 0 && len(policy.Spec.Subject) == 0 {
-		err := fmt.Errorf("TargetSelector or Subject cannot be empty")
+	// Validate the spec; the admission webhook rejects the same errors up front
+	if errs := ValidateSpec(&policy.Spec); len(errs) > 0 {
+		err := errs.ToAggregate()
 		log.Error(err, "Invalid DnsPolicy spec")
-		r.Recorder.Event(&policy, corev1.EventTypeWarning, "InvalidSpec", "TargetSelector or Subject cannot be empty")
+		r.Recorder.Event(&policy, corev1.EventTypeWarning, "InvalidSpec", err.Error())
 		r.updateCondition(ctx, &policy, "Ready", metav1.ConditionFalse, "InvalidSpec", err.Error())
 		return ctrl.Result{}, err
 	}
 
-	var hashObject map[string]string
-	// Validate targetSelector is not empty
-	if len(policy.Spec.TargetSelector) == 0 {
-		if len(policy.Spec.Subject) == 0 {
-			err := fmt.Errorf("targetSelector or Subject cannot be empty")
-			log.Error(err, "Invalid DnsPolicy spec")
-			r.Recorder.Event(&policy, corev1.EventTypeWarning, "InvalidSpec", "TargetSelector or Subject cannot be empty")
-			r.updateCondition(ctx, &policy, "Ready", metav1.ConditionFalse, "InvalidSpec", err.Error())
-			return ctrl.Result{}, err
-		} else {
-			hashObject = policy.Spec.Subject
-		}
-	} else {
-		hashObject = policy.Spec.TargetSelector
+	// Add the rules of referenced DomainLists; missing lists are reported, not fatal
+	expanded, unresolved, err := ExpandDomainLists(ctx, r, r.DomainLists, policy.Namespace, &policy.Spec)
+	if errors.Is(err, ErrDomainListNotLoaded) {
+		// The list reconciler stores the rules and updates the list status shortly
+		log.Info("Waiting for domain list sources", "reason", err.Error())
+		return ctrl.Result{RequeueAfter: domainListRetryInterval}, nil
 	}
+	if err != nil {
+		log.Error(err, "Failed to load referenced domain lists")
+		return ctrl.Result{}, err
+	}
+	listsStatus, listsReason, listsMessage := metav1.ConditionTrue, "Resolved",
+		"all referenced domain lists were loaded"
+	if len(unresolved) > 0 {
+		listsStatus, listsReason = metav1.ConditionFalse, "UnresolvedDomainLists"
+		listsMessage = strings.Join(unresolved, "; ")
+	}
+
 	// Compute selector hash
-	selectorHash, err := ComputeSelectorHash(hashObject)
+	selectorHash, err := SelectorHashFor(&policy.Spec)
 	if err != nil {
 		log.Error(err, "Failed to compute selector hash")
 		r.Recorder.Event(&policy, corev1.EventTypeWarning, "HashComputationFailed", fmt.Sprintf("Failed to compute selector hash: %v", err))
@@ -136,19 +185,8 @@ func (r *DnsPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
-	existing_index := r.Index.Get(selectorHash)
-
-	if existing_index != nil {
-		if len(existing_index.Name) != 0 && existing_index.Name != policy.Name {
-			err := errors.New("DUPLICATE HASH ERROR")
-			log.Error(err, fmt.Sprintf("The policy contains same hash policy with the name %s", existing_index.Name))
-			r.Recorder.Event(&policy, corev1.EventTypeWarning, "DuplicatHash", fmt.Sprintf("Failed to compute spec hash: %v", err))
-			r.updateCondition(ctx, &policy, "Ready", metav1.ConditionFalse, "HashComputationFailed", err.Error())
-			return ctrl.Result{}, err
-		}
-	}
-	// Compute spec hash
-	specHash, err := ComputeSpecHash(&policy.Spec)
+	// Compute spec hash, covering the content of referenced lists
+	specHash, err := ComputeSpecHash(expanded)
 	if err != nil {
 		log.Error(err, "Failed to compute spec hash")
 		r.Recorder.Event(&policy, corev1.EventTypeWarning, "HashComputationFailed", fmt.Sprintf("Failed to compute spec hash: %v", err))
@@ -156,6 +194,19 @@ func (r *DnsPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
+	// Look for a higher-priority Override policy that replaces this one
+	shadowedBy, err := r.shadowingPolicy(ctx, &policy, selectorHash)
+	if err != nil {
+		log.Error(err, "Failed to look up shadowing policies")
+		return ctrl.Result{}, err
+	}
+	shadowStatus, shadowReason, shadowMessage := metav1.ConditionFalse, "NotShadowed",
+		"no higher-priority policy overrides this policy"
+	if shadowedBy != nil {
+		shadowStatus, shadowReason = metav1.ConditionTrue, "Overridden"
+		shadowMessage = fmt.Sprintf("overridden by %s with priority %d", shadowedBy, shadowedBy.Priority)
+	}
+
 	// Update status if hashes have changed
 	needsStatusUpdate := false
 	if policy.Status.SelectorHash != selectorHash {
@@ -170,19 +221,96 @@ func (r *DnsPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		needsStatusUpdate = true
 		r.Recorder.Eventf(&policy, corev1.EventTypeNormal, "SpecHashUpdated", "Spec hash updated to %s", specHash)
 	}
+	overlaps := overlappingRules(expanded)
+	if !slices.Equal(policy.Status.OverlappingRules, overlaps) {
+		policy.Status.OverlappingRules = overlaps
+		needsStatusUpdate = true
+	}
+	resolvedAction := ResolveAction(&policy.Spec)
+	if !equality.Semantic.DeepEqual(policy.Status.ResolvedAction, resolvedAction) {
+		policy.Status.ResolvedAction = resolvedAction
+		needsStatusUpdate = true
+	}
 	if policy.Status.ObservedGeneration != policy.Generation {
 		policy.Status.ObservedGeneration = policy.Generation
 		needsStatusUpdate = true
 	}
+	if current := meta.FindStatusCondition(policy.Status.Conditions, conditionShadowed); current == nil ||
+		current.Status != shadowStatus || current.Message != shadowMessage {
+		needsStatusUpdate = true
+		if shadowedBy != nil {
+			r.Recorder.Event(&policy, corev1.EventTypeWarning, "Shadowed", shadowMessage)
+		}
+	}
+	ignoredKeys := ignoredSubjectKeys(&policy)
+	ignoredMessage := fmt.Sprintf("v1alpha1 subject keys without a v1beta1 field never match a pod: %s",
+		strings.Join(ignoredKeys, ", "))
+	if current := meta.FindStatusCondition(policy.Status.Conditions, conditionSubjectKeysIgnored); len(ignoredKeys) == 0 {
+		needsStatusUpdate = needsStatusUpdate || current != nil
+	} else if current == nil || current.Message != ignoredMessage {
+		needsStatusUpdate = true
+		r.Recorder.Event(&policy, corev1.EventTypeWarning, "SubjectKeysIgnored", ignoredMessage)
+	}
+	if current := meta.FindStatusCondition(policy.Status.Conditions, conditionDomainListsResolved); current == nil ||
+		current.Status != listsStatus || current.Message != listsMessage {
+		needsStatusUpdate = true
+		if len(unresolved) > 0 {
+			r.Recorder.Event(&policy, corev1.EventTypeWarning, "UnresolvedDomainLists", listsMessage)
+		}
+	}
 
-	// Update index with the policy
-	r.Index.Upsert(&policy, selectorHash)
+	// Update index with the policy; clients receive the rules of referenced lists inline
+	indexed := policy.DeepCopy()
+	indexed.Spec = *expanded
+	r.Index.Upsert(indexed, selectorHash)
 	log.Info("DnsPolicy indexed", "name", req.NamespacedName, "selectorHash", selectorHash, "specHash", specHash)
 	r.Recorder.Event(&policy, corev1.EventTypeNormal, "PolicyIndexed", "DnsPolicy successfully indexed and ready")
 
+	// Write the RPZ export; RPZ has no expiry, so requeue when a rule expires
+	var result ctrl.Result
+	rpzStatus, rpzReason, rpzMessage, err := r.exportRPZ(ctx, &policy, expanded)
+	if err != nil {
+		log.Error(err, "Failed to export RPZ zone")
+		r.Recorder.Event(&policy, corev1.EventTypeWarning, "RPZExportFailed", fmt.Sprintf("Failed to export RPZ zone: %v", err))
+		return ctrl.Result{}, err
+	}
+	current := meta.FindStatusCondition(policy.Status.Conditions, conditionRPZExported)
+	switch {
+	case rpzStatus == "":
+		needsStatusUpdate = needsStatusUpdate || current != nil
+	case current == nil || current.Status != rpzStatus || current.Message != rpzMessage:
+		needsStatusUpdate = true
+		if rpzStatus == metav1.ConditionFalse {
+			r.Recorder.Event(&policy, corev1.EventTypeWarning, rpzReason, rpzMessage)
+		}
+	}
+	if rpzStatus != "" {
+		result.RequeueAfter = nextRuleExpiry(expanded, time.Now())
+	}
+
 	// Update status if needed
 	if needsStatusUpdate {
 		r.updateCondition(ctx, &policy, "Ready", metav1.ConditionTrue, "Reconciled", "DnsPolicy successfully reconciled")
+		if len(overlaps) > 0 {
+			r.updateCondition(ctx, &policy, conditionRulesOverlap, metav1.ConditionTrue, "BlockListPrecedence",
+				fmt.Sprintf("blockList takes precedence over allowList for: %s", strings.Join(overlaps, ", ")))
+		} else {
+			r.updateCondition(ctx, &policy, conditionRulesOverlap, metav1.ConditionFalse, "NoOverlap",
+				"allowList and blockList do not overlap")
+		}
+		r.updateCondition(ctx, &policy, conditionShadowed, shadowStatus, shadowReason, shadowMessage)
+		r.updateCondition(ctx, &policy, conditionDomainListsResolved, listsStatus, listsReason, listsMessage)
+		if len(ignoredKeys) == 0 {
+			meta.RemoveStatusCondition(&policy.Status.Conditions, conditionSubjectKeysIgnored)
+		} else {
+			r.updateCondition(ctx, &policy, conditionSubjectKeysIgnored, metav1.ConditionTrue, "UnknownSubjectKeys",
+				ignoredMessage)
+		}
+		if rpzStatus == "" {
+			meta.RemoveStatusCondition(&policy.Status.Conditions, conditionRPZExported)
+		} else {
+			r.updateCondition(ctx, &policy, conditionRPZExported, rpzStatus, rpzReason, rpzMessage)
+		}
 		if err := r.Status().Update(ctx, &policy); err != nil {
 			log.Error(err, "Failed to update status")
 			r.Recorder.Event(&policy, corev1.EventTypeWarning, "StatusUpdateFailed", fmt.Sprintf("Failed to update status: %v", err))
@@ -191,36 +319,343 @@ func (r *DnsPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		r.Recorder.Event(&policy, corev1.EventTypeNormal, "Reconciled", "DnsPolicy successfully reconciled")
 	}
 
-	return ctrl.Result{}, nil
+	return result, nil
 }
 
 // updateCondition updates a condition in the policy status
-func (r *DnsPolicyReconciler) updateCondition(ctx context.Context, policy *dnsv1alpha1.DnsPolicy,
+func (r *DnsPolicyReconciler) updateCondition(ctx context.Context, policy *dnsv1beta1.DnsPolicy,
+	conditionType string, status metav1.ConditionStatus, reason, message string) {
+	setCondition(&policy.Status.Conditions, policy.Generation, conditionType, status, reason, message)
+}
+
+// setCondition updates or appends a condition, keeping its transition time
+// unless the status changed.
+func setCondition(conditions *[]metav1.Condition, generation int64,
 	conditionType string, status metav1.ConditionStatus, reason, message string) {
 	condition := metav1.Condition{
 		Type:               conditionType,
 		Status:             status,
-		ObservedGeneration: policy.Generation,
+		ObservedGeneration: generation,
 		LastTransitionTime: metav1.Now(),
 		Reason:             reason,
 		Message:            message,
 	}
 
 	// Find and update existing condition, or append new one
-	found := false
-	for i, existing := range policy.Status.Conditions {
+	for i, existing := range *conditions {
 		if existing.Type == conditionType {
-			// Only update if status changed
-			if existing.Status != status {
-				policy.Status.Conditions[i] = condition
+			// Keep the transition time unless the status changed
+			if existing.Status == status {
+				condition.LastTransitionTime = existing.LastTransitionTime
+			}
+			(*conditions)[i] = condition
+			return
+		}
+	}
+	*conditions = append(*conditions, condition)
+}
+
+// overlappingRules returns the sorted patterns that appear in both the
+// AllowList and the BlockList, compared in their canonical form.
+func overlappingRules(spec *dnsv1beta1.DnsPolicySpec) []string {
+	if len(spec.AllowList) == 0 || len(spec.BlockList) == 0 {
+		return nil
+	}
+
+	allowed := make(map[string]struct{}, len(spec.AllowList))
+	for _, rule := range spec.AllowList {
+		allowed[CanonicalDomain(rule.Pattern)] = struct{}{}
+	}
+
+	var overlaps []string
+	for _, rule := range spec.BlockList {
+		normalized := CanonicalDomain(rule.Pattern)
+		if _, ok := allowed[normalized]; ok && !slices.Contains(overlaps, normalized) {
+			overlaps = append(overlaps, normalized)
+		}
+	}
+	sort.Strings(overlaps)
+	return overlaps
+}
+
+// shadowingPolicy returns the policy with MergeMode Override that replaces
+// this policy for every pod it selects, or nil. The candidates are the other
+// DnsPolicies of the namespace with the same selector hash and the
+// ClusterDnsPolicies covering the namespace whose TargetSelector is unset or
+// equal to this policy's and whose service accounts, if any, include all of
+// this policy's.
+func (r *DnsPolicyReconciler) shadowingPolicy(ctx context.Context, policy *dnsv1beta1.DnsPolicy, selectorHash string) (*PolicySource, error) {
+	var candidates []mergeInput
+
+	var peers dnsv1beta1.DnsPolicyList
+	if err := r.List(ctx, &peers, client.InNamespace(policy.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range peers.Items {
+		peer := &peers.Items[i]
+		if peer.Name == policy.Name || !peer.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if hash, err := SelectorHashFor(&peer.Spec); err != nil || hash != selectorHash {
+			continue
+		}
+		candidates = append(candidates, dnsPolicyInput(peer))
+	}
+
+	labelHash, err := ComputeLabelSelectorHash(policy.Spec.TargetSelector)
+	if err != nil {
+		return nil, err
+	}
+	var namespace corev1.Namespace
+	if err := r.Get(ctx, client.ObjectKey{Name: policy.Namespace}, &namespace); err != nil {
+		return nil, err
+	}
+	var clusterPolicies dnsv1beta1.ClusterDnsPolicyList
+	if err := r.List(ctx, &clusterPolicies); err != nil {
+		return nil, err
+	}
+	for i := range clusterPolicies.Items {
+		clusterPolicy := &clusterPolicies.Items[i]
+		if !clusterPolicy.DeletionTimestamp.IsZero() || !selectorMatches(clusterPolicy.Spec.NamespaceSelector, namespace.Labels) ||
+			!subjectSelectsNamespace(clusterPolicy.Spec.Subject, &namespace) {
+			continue
+		}
+		if names := clusterPolicy.Spec.Subject.ServiceAccountNames(); len(names) > 0 {
+			own := policy.Spec.Subject.ServiceAccountNames()
+			if len(own) == 0 || slices.ContainsFunc(own, func(name string) bool { return !slices.Contains(names, name) }) {
+				continue
+			}
+		}
+		if canonicalizeSelector(clusterPolicy.Spec.TargetSelector) != nil {
+			if hash, err := ComputeLabelSelectorHash(clusterPolicy.Spec.TargetSelector); err != nil || hash != labelHash {
+				continue
+			}
+		}
+		candidates = append(candidates, clusterPolicyInput(clusterPolicy))
+	}
+
+	return shadowingSource(dnsPolicyInput(policy), candidates), nil
+}
+
+// exportRPZ writes the policy's rules as an RPZ zone into the ConfigMap of
+// spec.export.rpz and deletes the ConfigMaps of earlier exports. It returns
+// the RPZExported condition, or an empty status if the policy exports no
+// zone. A ConfigMap of the same name that the policy does not own is left
+// alone and reported.
+func (r *DnsPolicyReconciler) exportRPZ(ctx context.Context, policy *dnsv1beta1.DnsPolicy,
+	expanded *dnsv1beta1.DnsPolicySpec) (metav1.ConditionStatus, string, string, error) {
+	var export *dnsv1beta1.RPZExport
+	if policy.Spec.Export != nil {
+		export = policy.Spec.Export.RPZ
+	}
+
+	var previous corev1.ConfigMapList
+	if err := r.List(ctx, &previous, client.InNamespace(policy.Namespace),
+		client.MatchingLabels{rpzExportLabel: policy.Name}); err != nil {
+		return "", "", "", err
+	}
+	for i := range previous.Items {
+		configMap := &previous.Items[i]
+		if (export == nil || configMap.Name != export.ConfigMapName) && metav1.IsControlledBy(configMap, policy) {
+			if err := r.Delete(ctx, configMap); client.IgnoreNotFound(err) != nil {
+				return "", "", "", err
+			}
+		}
+	}
+	if export == nil {
+		return "", "", "", nil
+	}
+
+	zone, key := export.Zone, export.Key
+	if zone == "" {
+		zone = DefaultRPZZone
+	}
+	if key == "" {
+		key = DefaultRPZKey
+	}
+	indexed := policy.DeepCopy()
+	indexed.Spec = *expanded
+	records := BuildRPZRecords(MergePolicies([]*dnsv1beta1.DnsPolicy{indexed}, nil), time.Now())
+	recordsHash := sha256.Sum256([]byte(records.Text))
+
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: export.ConfigMapName, Namespace: policy.Namespace}}
+	err := r.Get(ctx, client.ObjectKeyFromObject(configMap), configMap)
+	if client.IgnoreNotFound(err) != nil {
+		return "", "", "", err
+	}
+	if err == nil && !metav1.IsControlledBy(configMap, policy) {
+		return metav1.ConditionFalse, "ConfigMapConflict",
+			fmt.Sprintf("ConfigMap %s exists and is not managed by this policy", export.ConfigMapName), nil
+	}
+
+	// Keep the serial while the records are unchanged so resolvers do not reload
+	serial, _ := strconv.ParseUint(configMap.Annotations[rpzSerialAnnotation], 10, 32)
+	if configMap.Annotations[rpzRecordsAnnotation] != hex.EncodeToString(recordsHash[:]) {
+		serial = max(serial+1, uint64(time.Now().Unix()))
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		configMap.Labels = map[string]string{rpzExportLabel: policy.Name}
+		configMap.Annotations = map[string]string{
+			rpzSerialAnnotation:  strconv.FormatUint(serial, 10),
+			rpzRecordsAnnotation: hex.EncodeToString(recordsHash[:]),
+		}
+		configMap.Data = map[string]string{key: string(RPZZoneFile(zone, uint32(serial), records))}
+		configMap.BinaryData = nil
+		return controllerutil.SetControllerReference(policy, configMap, r.Scheme)
+	}); err != nil {
+		return "", "", "", err
+	}
+
+	return metav1.ConditionTrue, "Exported", fmt.Sprintf("zone %s written to key %s of ConfigMap %s: %d records, %d rules skipped",
+		zone, key, export.ConfigMapName, records.Records, records.Skipped), nil
+}
+
+// nextRuleExpiry returns how long until the next rule of a spec expires, or
+// zero if none expires after now.
+func nextRuleExpiry(spec *dnsv1beta1.DnsPolicySpec, now time.Time) time.Duration {
+	var next time.Duration
+	for _, rules := range [][]dnsv1beta1.DomainRule{spec.BlockList, spec.AllowList} {
+		for _, rule := range rules {
+			if rule.ExpiresAt == nil || !rule.ExpiresAt.After(now) {
+				continue
+			}
+			if until := rule.ExpiresAt.Sub(now); next == 0 || until < next {
+				next = until
 			}
-			found = true
-			break
 		}
 	}
-	if !found {
-		policy.Status.Conditions = append(policy.Status.Conditions, condition)
+	return next
+}
+
+// selectorMatches reports whether an optional label selector matches a set
+// of labels. An unset selector matches everything and an invalid one nothing.
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) bool {
+	if selector == nil {
+		return true
+	}
+	parsed, err := metav1.LabelSelectorAsSelector(selector)
+	return err == nil && parsed.Matches(labels.Set(set))
+}
+
+// ignoredSubjectKeys returns the v1alpha1 subject keys that the conversion
+// kept in IgnoredSubjectKeysAnnotation, sorted.
+func ignoredSubjectKeys(policy *dnsv1beta1.DnsPolicy) []string {
+	data, ok := policy.Annotations[dnsv1beta1.IgnoredSubjectKeysAnnotation]
+	if !ok {
+		return nil
+	}
+	var ignored map[string]string
+	if err := json.Unmarshal([]byte(data), &ignored); err != nil {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(ignored))
+}
+
+// policiesSharingSelector requeues the other DnsPolicies of a namespace that
+// had or have the same selector hash as a changed policy, so their Shadowed
+// condition follows priority and mode changes.
+func (r *DnsPolicyReconciler) policiesSharingSelector(ctx context.Context, obj client.Object) []reconcile.Request {
+	changed, ok := obj.(*dnsv1beta1.DnsPolicy)
+	if !ok {
+		return nil
+	}
+	hashes := []string{changed.Status.SelectorHash}
+	if hash, err := SelectorHashFor(&changed.Spec); err == nil {
+		hashes = append(hashes, hash)
+	}
+
+	var policies dnsv1beta1.DnsPolicyList
+	if err := r.List(ctx, &policies, client.InNamespace(changed.Namespace)); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list DnsPolicies")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		if policy.Name != changed.Name && slices.Contains(hashes, policy.Status.SelectorHash) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(policy)})
+		}
+	}
+	return requests
+}
+
+// policiesInNamespace requeues the DnsPolicies of a namespace after its
+// labels changed, since they decide which cluster policies shadow them.
+func (r *DnsPolicyReconciler) policiesInNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	var policies dnsv1beta1.DnsPolicyList
+	if err := r.List(ctx, &policies, client.InNamespace(obj.GetName())); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list DnsPolicies")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(policies.Items))
+	for i := range policies.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&policies.Items[i])})
+	}
+	return requests
+}
+
+// policiesCoveredByClusterPolicy requeues the DnsPolicies of the namespaces
+// a changed ClusterDnsPolicy covers. Updates are mapped for both the old and
+// the new object, so namespaces it stopped covering are requeued as well.
+func (r *DnsPolicyReconciler) policiesCoveredByClusterPolicy(ctx context.Context, obj client.Object) []reconcile.Request {
+	clusterPolicy, ok := obj.(*dnsv1beta1.ClusterDnsPolicy)
+	if !ok {
+		return nil
+	}
+	log := logf.FromContext(ctx)
+	selector := labels.Everything()
+	if clusterPolicy.Spec.NamespaceSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(clusterPolicy.Spec.NamespaceSelector); err != nil {
+			return nil
+		}
+	}
+
+	var namespaces corev1.NamespaceList
+	if err := r.List(ctx, &namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		log.Error(err, "Failed to list namespaces")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range namespaces.Items {
+		namespace := &namespaces.Items[i]
+		if !subjectSelectsNamespace(clusterPolicy.Spec.Subject, namespace) {
+			continue
+		}
+		var policies dnsv1beta1.DnsPolicyList
+		if err := r.List(ctx, &policies, client.InNamespace(namespace.Name)); err != nil {
+			log.Error(err, "Failed to list DnsPolicies")
+			return nil
+		}
+		for j := range policies.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&policies.Items[j])})
+		}
+	}
+	return requests
+}
+
+// policiesReferencingList requeues the DnsPolicies that reference a changed
+// DomainList in its namespace, or a changed ClusterDomainList anywhere.
+func (r *DnsPolicyReconciler) policiesReferencingList(ctx context.Context, obj client.Object) []reconcile.Request {
+	kind := dnsv1beta1.DomainListKindCluster
+	var opts []client.ListOption
+	if _, ok := obj.(*dnsv1beta1.DomainList); ok {
+		kind = dnsv1beta1.DomainListKindNamespaced
+		opts = append(opts, client.InNamespace(obj.GetNamespace()))
+	}
+
+	var policies dnsv1beta1.DnsPolicyList
+	if err := r.List(ctx, &policies, opts...); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list DnsPolicies")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range policies.Items {
+		if referencesList(&policies.Items[i].Spec, kind, obj.GetName()) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&policies.Items[i])})
+		}
 	}
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -229,7 +664,17 @@ func (r *DnsPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("dnspolicy-controller")
 
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&dnsv1alpha1.DnsPolicy{}).
+		For(&dnsv1beta1.DnsPolicy{}).
+		Watches(&dnsv1beta1.DnsPolicy{}, handler.EnqueueRequestsFromMapFunc(r.policiesSharingSelector)).
+		// Only spec changes of cluster policies and label changes of
+		// namespaces can change which policy shadows a DnsPolicy
+		Watches(&dnsv1beta1.ClusterDnsPolicy{}, handler.EnqueueRequestsFromMapFunc(r.policiesCoveredByClusterPolicy),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.policiesInNamespace),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Watches(&dnsv1beta1.DomainList{}, handler.EnqueueRequestsFromMapFunc(r.policiesReferencingList)).
+		Watches(&dnsv1beta1.ClusterDomainList{}, handler.EnqueueRequestsFromMapFunc(r.policiesReferencingList)).
+		Owns(&corev1.ConfigMap{}).
 		Named("dnspolicy").
 		Complete(r)
 }
@@ -21,32 +21,37 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
-	dnsv1alpha1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1alpha1"
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
 )
 
 var _ = Describe("DnsPolicy Controller", func() {
+	ctx := context.Background()
+
 	Context("When reconciling a resource", func() {
 		const resourceName = "test-resource"
 
-		ctx := context.Background()
-
 		typeNamespacedName := types.NamespacedName{
 			Name:      resourceName,
 			Namespace: "default", // TODO(user):Modify as needed
 		}
-		dnspolicy := &dnsv1alpha1.DnsPolicy{}
+		dnspolicy := &dnsv1beta1.DnsPolicy{}
 
 		BeforeEach(func() {
 			By("creating the custom resource for the Kind DnsPolicy")
 			err := k8sClient.Get(ctx, typeNamespacedName, dnspolicy)
 			if err != nil && errors.IsNotFound(err) {
-				resource := &dnsv1alpha1.DnsPolicy{
+				resource := &dnsv1beta1.DnsPolicy{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      resourceName,
 						Namespace: "default",
@@ -59,7 +64,7 @@ var _ = Describe("DnsPolicy Controller", func() {
 
 		AfterEach(func() {
 			// TODO(user): Cleanup logic after each test, like removing the resource instance.
-			resource := &dnsv1alpha1.DnsPolicy{}
+			resource := &dnsv1beta1.DnsPolicy{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -69,8 +74,10 @@ var _ = Describe("DnsPolicy Controller", func() {
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &DnsPolicyReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Index:    NewPolicyIndex(),
+				Recorder: record.NewFakeRecorder(100),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -81,4 +88,37 @@ var _ = Describe("DnsPolicy Controller", func() {
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
 	})
+
+	It("should requeue only the DnsPolicies of the namespaces a cluster policy covers", func() {
+		testScheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+		Expect(dnsv1beta1.AddToScheme(testScheme)).To(Succeed())
+		namespace := func(name, env string) *corev1.Namespace {
+			return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"env": env}}}
+		}
+		policy := func(namespace string) *dnsv1beta1.DnsPolicy {
+			return &dnsv1beta1.DnsPolicy{ObjectMeta: metav1.ObjectMeta{Name: "baseline", Namespace: namespace}}
+		}
+		c := fake.NewClientBuilder().WithScheme(testScheme).WithObjects(
+			namespace("shop", "prod"), namespace("billing", "prod"), namespace("sandbox", "dev"),
+			policy("shop"), policy("billing"), policy("sandbox"),
+		).Build()
+		r := &DnsPolicyReconciler{Client: c, Scheme: testScheme, Index: NewPolicyIndex()}
+
+		clusterPolicy := &dnsv1beta1.ClusterDnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "prod-baseline"},
+			Spec: dnsv1beta1.ClusterDnsPolicySpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			},
+		}
+		Expect(r.policiesCoveredByClusterPolicy(ctx, clusterPolicy)).To(ConsistOf(
+			reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "shop", Name: "baseline"}},
+			reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "billing", Name: "baseline"}},
+		))
+
+		clusterPolicy.Spec.Subject = &dnsv1beta1.Subject{Namespace: "billing"}
+		Expect(r.policiesCoveredByClusterPolicy(ctx, clusterPolicy)).To(ConsistOf(
+			reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "billing", Name: "baseline"}},
+		))
+	})
 })
@@ -0,0 +1,186 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// conditionDomainListsResolved reports whether every DomainList and
+// ClusterDomainList referenced by a policy could be loaded.
+const conditionDomainListsResolved = "DomainListsResolved"
+
+// ErrDomainListNotLoaded is returned by ExpandDomainLists for a list whose
+// sources have not been loaded into the DomainListStore yet.
+var ErrDomainListNotLoaded = errors.New("domain list sources not loaded yet")
+
+// domainListKey identifies a DomainList or ClusterDomainList in a DomainListStore.
+type domainListKey struct {
+	Kind      dnsv1beta1.DomainListKind
+	Namespace string
+	Name      string
+}
+
+// DomainListStore holds the rules that the DomainList and ClusterDomainList
+// reconcilers loaded from the sources of each list. Source rules can be far
+// larger than an object, so they are kept in memory rather than in status.
+type DomainListStore struct {
+	mu    sync.RWMutex
+	rules map[domainListKey][]dnsv1beta1.DomainRule
+}
+
+// NewDomainListStore creates an empty DomainListStore.
+func NewDomainListStore() *DomainListStore {
+	return &DomainListStore{rules: make(map[domainListKey][]dnsv1beta1.DomainRule)}
+}
+
+// Set stores the source rules of a list. The namespace of a
+// ClusterDomainList is empty.
+func (s *DomainListStore) Set(kind dnsv1beta1.DomainListKind, namespace, name string, rules []dnsv1beta1.DomainRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules[domainListKey{Kind: kind, Namespace: namespace, Name: name}] = slices.Clone(rules)
+}
+
+// Get returns the source rules of a list and whether they were loaded.
+// The returned slice must not be modified.
+func (s *DomainListStore) Get(kind dnsv1beta1.DomainListKind, namespace, name string) ([]dnsv1beta1.DomainRule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rules, ok := s.rules[domainListKey{Kind: kind, Namespace: namespace, Name: name}]
+	return rules, ok
+}
+
+// Delete forgets the source rules of a deleted list.
+func (s *DomainListStore) Delete(kind dnsv1beta1.DomainListKind, namespace, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rules, domainListKey{Kind: kind, Namespace: namespace, Name: name})
+}
+
+// ExpandDomainLists returns a copy of a policy spec with the rules of the
+// lists named in BlockListRefs and AllowListRefs added to BlockList and
+// AllowList, normalized like NormalizeSpec. Rule actions only apply to
+// blocked names and are dropped from rules added to AllowList.
+// References to lists that are missing or invalid are skipped and each is
+// described in the returned messages. The rules loaded from list sources
+// are taken from store; with a nil store only the inline rules are added.
+// It returns ErrDomainListNotLoaded when the sources of a list are pending.
+func ExpandDomainLists(ctx context.Context, c client.Reader, store *DomainListStore, namespace string,
+	spec *dnsv1beta1.DnsPolicySpec) (*dnsv1beta1.DnsPolicySpec, []string, error) {
+	expanded := spec.DeepCopy()
+	var unresolved []string
+
+	for i := range spec.BlockListRefs {
+		rules, problem, err := domainListRules(ctx, c, store, namespace, &spec.BlockListRefs[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if problem != "" {
+			unresolved = append(unresolved, problem)
+			continue
+		}
+		expanded.BlockList = append(expanded.BlockList, rules...)
+	}
+	for i := range spec.AllowListRefs {
+		rules, problem, err := domainListRules(ctx, c, store, namespace, &spec.AllowListRefs[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if problem != "" {
+			unresolved = append(unresolved, problem)
+			continue
+		}
+		for _, rule := range rules {
+			rule.Action = nil
+			expanded.AllowList = append(expanded.AllowList, rule)
+		}
+	}
+
+	NormalizeSpec(expanded)
+	return expanded, unresolved, nil
+}
+
+// domainListRules loads the inline and source rules of a referenced list.
+// A missing or invalid list is reported as a problem message rather than
+// an error so that the policy can still be indexed with its other rules.
+func domainListRules(ctx context.Context, c client.Reader, store *DomainListStore, namespace string,
+	ref *dnsv1beta1.DomainListReference) ([]dnsv1beta1.DomainRule, string, error) {
+	kind := ref.EffectiveKind()
+	var spec *dnsv1beta1.DomainListSpec
+	var description string
+	switch kind {
+	case dnsv1beta1.DomainListKindCluster:
+		description = fmt.Sprintf("ClusterDomainList %s", ref.Name)
+		namespace = ""
+		var list dnsv1beta1.ClusterDomainList
+		if err := c.Get(ctx, client.ObjectKey{Name: ref.Name}, &list); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, description + " not found", nil
+			}
+			return nil, "", err
+		}
+		spec = &list.Spec
+		if errs := ValidateClusterDomainListSpec(spec); len(errs) > 0 {
+			return nil, fmt.Sprintf("%s is invalid: %v", description, errs.ToAggregate()), nil
+		}
+	default:
+		description = fmt.Sprintf("DomainList %s/%s", namespace, ref.Name)
+		var list dnsv1beta1.DomainList
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, &list); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, description + " not found", nil
+			}
+			return nil, "", err
+		}
+		spec = &list.Spec
+		if errs := ValidateDomainListSpec(spec); len(errs) > 0 {
+			return nil, fmt.Sprintf("%s is invalid: %v", description, errs.ToAggregate()), nil
+		}
+	}
+
+	if store == nil || len(spec.Sources) == 0 {
+		return spec.Rules, "", nil
+	}
+	loaded, ok := store.Get(kind, namespace, ref.Name)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrDomainListNotLoaded, description)
+	}
+	return append(slices.Clone(spec.Rules), loaded...), "", nil
+}
+
+// referencesList reports whether a policy spec names a list in BlockListRefs
+// or AllowListRefs.
+func referencesList(spec *dnsv1beta1.DnsPolicySpec, kind dnsv1beta1.DomainListKind, name string) bool {
+	for _, refs := range [][]dnsv1beta1.DomainListReference{spec.BlockListRefs, spec.AllowListRefs} {
+		for i := range refs {
+			if refs[i].EffectiveKind() == kind && refs[i].Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,315 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+const (
+	// MaxReportedParseErrors is how many parse errors of a source are listed
+	// in status. ParseErrorCount still counts all of them.
+	MaxReportedParseErrors = 20
+
+	// DomainListSecretLabel opts a Secret into being read as a list source,
+	// with the value "enabled". The controller only caches and watches
+	// Secrets with this label.
+	DomainListSecretLabel = "dns.dnspolicies.io/domain-list"
+)
+
+// DomainListReconciler reconciles a DomainList object
+type DomainListReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Store    *DomainListStore
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=domainlists,verbs=get;list;watch
+// +kubebuilder:rbac:groups=dns.dnspolicies.io,resources=domainlists/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile loads the sources of a DomainList into the Store and reports
+// the result in its status. The DnsPolicy reconciler watches the status, so
+// a change of status.rulesHash re-indexes the policies referencing the list.
+func (r *DomainListReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	var list dnsv1beta1.DomainList
+	if err := r.Get(ctx, req.NamespacedName, &list); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Store.Delete(dnsv1beta1.DomainListKindNamespaced, req.Namespace, req.Name)
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get DomainList")
+		return ctrl.Result{}, err
+	}
+
+	var result ctrl.Result
+	status := list.Status.DeepCopy()
+	if errs := ValidateDomainListSpec(&list.Spec); len(errs) > 0 {
+		err := errs.ToAggregate()
+		log.Error(err, "Invalid DomainList spec")
+		r.Recorder.Event(&list, corev1.EventTypeWarning, "InvalidSpec", err.Error())
+		r.Store.Delete(dnsv1beta1.DomainListKindNamespaced, list.Namespace, list.Name)
+		setCondition(&status.Conditions, list.Generation, "Ready", metav1.ConditionFalse, "InvalidSpec", err.Error())
+	} else {
+		rules, nextSync, err := loadSources(ctx, r, nil, list.Namespace, list.Spec.Sources, status)
+		if err != nil {
+			log.Error(err, "Failed to load DomainList sources")
+			return ctrl.Result{}, err
+		}
+		result.RequeueAfter = nextSync
+		r.Store.Set(dnsv1beta1.DomainListKindNamespaced, list.Namespace, list.Name, rules)
+		reportSourceStatus(r.Recorder, &list, list.Generation, status)
+	}
+	status.ObservedGeneration = list.Generation
+
+	if equality.Semantic.DeepEqual(&list.Status, status) {
+		return result, nil
+	}
+	list.Status = *status
+	if err := r.Status().Update(ctx, &list); err != nil {
+		log.Error(err, "Failed to update DomainList status")
+		return ctrl.Result{}, err
+	}
+	log.Info("DomainList loaded", "name", req.NamespacedName, "rules", status.RuleCount, "rulesHash", status.RulesHash)
+	return result, nil
+}
+
+// loadSources reads and parses the sources of a list and fills in the
+// source fields of its status. Sources that cannot be read are reported in
+// status and skipped; only API errors are returned. Sources without a
+// namespace are read from defaultNamespace. HTTP sources are fetched with
+// feeds, and refused without; an HTTP source whose download failed keeps
+// its last good content.
+// The returned duration is how long until the next HTTP download is due,
+// or zero without HTTP sources.
+func loadSources(ctx context.Context, c client.Reader, feeds *FeedFetcher, defaultNamespace string,
+	sources []dnsv1beta1.DomainListSource, status *dnsv1beta1.DomainListStatus) ([]dnsv1beta1.DomainRule, time.Duration, error) {
+	var rules []dnsv1beta1.DomainRule
+	var nextSync time.Duration
+	status.Sources = make([]dnsv1beta1.SourceStatus, len(sources))
+	for i := range sources {
+		sourceStatus := &status.Sources[i]
+		var data []byte
+		if source := sources[i].HTTP; source != nil && feeds == nil {
+			sourceStatus.Error = "HTTP sources are only allowed in a ClusterDomainList"
+			continue
+		} else if source != nil {
+			feed := feeds.Fetch(ctx, source)
+			nextSync = minPositive(nextSync, time.Until(feed.NextSync))
+			sourceStatus.SHA256 = feed.SHA256
+			if !feed.SyncedAt.IsZero() {
+				// Status is stored with second precision; truncating keeps an
+				// unchanged sync from looking like a status change.
+				syncedAt := metav1.NewTime(feed.SyncedAt.Truncate(time.Second))
+				sourceStatus.LastSyncTime = &syncedAt
+			}
+			switch {
+			case feed.Err != nil && feed.Data == nil:
+				sourceStatus.Error = feed.Err.Error()
+				continue
+			case feed.Err != nil:
+				sourceStatus.Error = fmt.Sprintf("%v; keeping the content synced at %s",
+					feed.Err, sourceStatus.LastSyncTime.UTC().Format(time.RFC3339))
+			}
+			data = feed.Data
+		} else {
+			var problem string
+			var err error
+			data, problem, err = sourceData(ctx, c, defaultNamespace, &sources[i])
+			if err != nil {
+				return nil, 0, err
+			}
+			if problem != "" {
+				sourceStatus.Error = problem
+				continue
+			}
+		}
+
+		parsed, parseErrors, err := ParseDomainList(bytes.NewReader(data), sources[i].EffectiveFormat())
+		if err != nil {
+			sourceStatus.Error = err.Error()
+			continue
+		}
+		sourceStatus.RuleCount = int32(len(parsed))
+		sourceStatus.ParseErrorCount = int32(len(parseErrors))
+		if len(parseErrors) > MaxReportedParseErrors {
+			parseErrors = parseErrors[:MaxReportedParseErrors]
+		}
+		sourceStatus.ParseErrors = parseErrors
+		rules = append(rules, parsed...)
+	}
+	if len(sources) == 0 {
+		status.Sources = nil
+	}
+
+	rules = normalizeRuleList(rules)
+	hash, err := ComputeRulesHash(rules)
+	if err != nil {
+		return nil, 0, err
+	}
+	status.RuleCount = int32(len(rules))
+	status.RulesHash = hash
+	return rules, nextSync, nil
+}
+
+// minPositive returns the smaller of two durations, treating zero as unset.
+// A due or overdue d is returned as a second so it still requeues.
+func minPositive(current, d time.Duration) time.Duration {
+	d = max(d, time.Second)
+	if current == 0 {
+		return d
+	}
+	return min(current, d)
+}
+
+// sourceData returns the content of the ConfigMap or Secret key named by a
+// source. A missing object or key is returned as a problem message.
+func sourceData(ctx context.Context, c client.Reader, defaultNamespace string,
+	source *dnsv1beta1.DomainListSource) ([]byte, string, error) {
+	if ref := source.SecretKeyRef; ref != nil {
+		key := client.ObjectKey{Namespace: sourceNamespace(ref, defaultNamespace), Name: ref.Name}
+		var secret corev1.Secret
+		err := c.Get(ctx, key, &secret)
+		if apierrors.IsNotFound(err) || (err == nil && secret.Labels[DomainListSecretLabel] != "enabled") {
+			return nil, fmt.Sprintf("Secret %s not found or not labeled %s=enabled", key, DomainListSecretLabel), nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		data, ok := secret.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Sprintf("key %q not found in Secret %s", ref.Key, key), nil
+		}
+		return data, "", nil
+	}
+
+	ref := source.ConfigMapKeyRef
+	key := client.ObjectKey{Namespace: sourceNamespace(ref, defaultNamespace), Name: ref.Name}
+	var configMap corev1.ConfigMap
+	if err := c.Get(ctx, key, &configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Sprintf("ConfigMap %s not found", key), nil
+		}
+		return nil, "", err
+	}
+	if data, ok := configMap.Data[ref.Key]; ok {
+		return []byte(data), "", nil
+	}
+	if data, ok := configMap.BinaryData[ref.Key]; ok {
+		return data, "", nil
+	}
+	return nil, fmt.Sprintf("key %q not found in ConfigMap %s", ref.Key, key), nil
+}
+
+func sourceNamespace(ref *dnsv1beta1.SourceKeyReference, defaultNamespace string) string {
+	if ref.Namespace != "" {
+		return ref.Namespace
+	}
+	return defaultNamespace
+}
+
+// reportSourceStatus sets the Ready condition of a list from the source
+// fields of its status. Parse errors do not make a list unready: the lines
+// that parsed are still used.
+func reportSourceStatus(recorder record.EventRecorder, list client.Object, generation int64, status *dnsv1beta1.DomainListStatus) {
+	var problems []string
+	var parseErrors int32
+	for i, source := range status.Sources {
+		if source.Error != "" {
+			problems = append(problems, fmt.Sprintf("sources[%d]: %s", i, source.Error))
+		}
+		parseErrors += source.ParseErrorCount
+	}
+
+	switch {
+	case len(problems) > 0:
+		message := strings.Join(problems, "; ")
+		recorder.Event(list, corev1.EventTypeWarning, "SourceUnavailable", message)
+		setCondition(&status.Conditions, generation, "Ready", metav1.ConditionFalse, "SourceUnavailable", message)
+	case parseErrors > 0:
+		setCondition(&status.Conditions, generation, "Ready", metav1.ConditionTrue, "ParseErrors",
+			fmt.Sprintf("%d lines could not be parsed and were skipped; see status.sources", parseErrors))
+	default:
+		setCondition(&status.Conditions, generation, "Ready", metav1.ConditionTrue, "Loaded",
+			fmt.Sprintf("%d rules loaded from %d sources", status.RuleCount, len(status.Sources)))
+	}
+}
+
+// readsSource reports whether a list source reads the given ConfigMap or
+// Secret. Sources without a namespace read from defaultNamespace.
+func readsSource(sources []dnsv1beta1.DomainListSource, obj client.Object, defaultNamespace string) bool {
+	_, isSecret := obj.(*corev1.Secret)
+	return slices.ContainsFunc(sources, func(source dnsv1beta1.DomainListSource) bool {
+		ref := source.ConfigMapKeyRef
+		if isSecret {
+			ref = source.SecretKeyRef
+		}
+		return ref != nil && ref.Name == obj.GetName() && sourceNamespace(ref, defaultNamespace) == obj.GetNamespace()
+	})
+}
+
+// listsReadingSource requeues the DomainLists of a namespace that read a
+// changed ConfigMap or Secret.
+func (r *DomainListReconciler) listsReadingSource(ctx context.Context, obj client.Object) []reconcile.Request {
+	var lists dnsv1beta1.DomainListList
+	if err := r.List(ctx, &lists, client.InNamespace(obj.GetNamespace())); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list DomainLists")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range lists.Items {
+		if readsSource(lists.Items[i].Spec.Sources, obj, lists.Items[i].Namespace) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&lists.Items[i])})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DomainListReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.Recorder = mgr.GetEventRecorderFor("domainlist-controller")
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dnsv1beta1.DomainList{}).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.listsReadingSource)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.listsReadingSource)).
+		Named("domainlist").
+		Complete(r)
+}
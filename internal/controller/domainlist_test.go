@@ -0,0 +1,194 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("Domain lists", func() {
+	Context("ExpandDomainLists", func() {
+		It("should add the rules of referenced lists and report missing ones", func() {
+			testScheme := runtime.NewScheme()
+			Expect(dnsv1beta1.AddToScheme(testScheme)).To(Succeed())
+			c := fake.NewClientBuilder().WithScheme(testScheme).WithObjects(
+				&dnsv1beta1.DomainList{
+					ObjectMeta: metav1.ObjectMeta{Name: "ads", Namespace: "default"},
+					Spec: dnsv1beta1.DomainListSpec{Rules: []dnsv1beta1.DomainRule{
+						{Pattern: "tracking.ads.net"}, {Pattern: "*.doubleclick.net"},
+					}},
+				},
+				&dnsv1beta1.DomainList{
+					ObjectMeta: metav1.ObjectMeta{Name: "ads", Namespace: "payments"},
+					Spec:       dnsv1beta1.DomainListSpec{Rules: []dnsv1beta1.DomainRule{{Pattern: "other.example"}}},
+				},
+				&dnsv1beta1.ClusterDomainList{
+					ObjectMeta: metav1.ObjectMeta{Name: "partners"},
+					Spec: dnsv1beta1.DomainListSpec{Rules: []dnsv1beta1.DomainRule{{
+						Pattern: "api.partner.example",
+						Action:  &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionRefused},
+					}}},
+				},
+			).Build()
+
+			spec := &dnsv1beta1.DnsPolicySpec{
+				BlockList:     []dnsv1beta1.DomainRule{{Pattern: "tracking.ads.net"}},
+				BlockListRefs: []dnsv1beta1.DomainListReference{{Name: "ads"}, {Name: "malware"}},
+				AllowListRefs: []dnsv1beta1.DomainListReference{{Kind: dnsv1beta1.DomainListKindCluster, Name: "partners"}},
+			}
+			expanded, unresolved, err := ExpandDomainLists(ctx, c, nil, "default", spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unresolved).To(Equal([]string{"DomainList default/malware not found"}))
+			Expect(expanded.BlockList).To(Equal([]dnsv1beta1.DomainRule{
+				{Pattern: "*.doubleclick.net"}, {Pattern: "tracking.ads.net"},
+			}))
+			Expect(expanded.AllowList).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "api.partner.example"}}))
+			Expect(spec.BlockList).To(HaveLen(1))
+
+			withoutLists, err := ComputeSpecHash(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ComputeSpecHash(expanded)).NotTo(Equal(withoutLists))
+		})
+	})
+
+	Context("with sources", func() {
+		var (
+			testScheme *runtime.Scheme
+			list       *dnsv1beta1.DomainList
+		)
+
+		BeforeEach(func() {
+			testScheme = runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+			Expect(dnsv1beta1.AddToScheme(testScheme)).To(Succeed())
+			list = &dnsv1beta1.DomainList{
+				ObjectMeta: metav1.ObjectMeta{Name: "threats", Namespace: "default"},
+				Spec: dnsv1beta1.DomainListSpec{
+					Rules: []dnsv1beta1.DomainRule{{Pattern: "manual.example.com"}},
+					Sources: []dnsv1beta1.DomainListSource{
+						{
+							Format:          dnsv1beta1.DomainListFormatHosts,
+							ConfigMapKeyRef: &dnsv1beta1.SourceKeyReference{Name: "feeds", Key: "hosts"},
+						},
+						{SecretKeyRef: &dnsv1beta1.SourceKeyReference{Name: "private-feed", Key: "domains"}},
+					},
+				},
+			}
+		})
+
+		It("should load every readable source and report the others", func() {
+			c := fake.NewClientBuilder().WithScheme(testScheme).WithObjects(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "feeds", Namespace: "default"},
+				Data:       map[string]string{"hosts": "0.0.0.0 ads.example.com\nnot-an-address ads.example.org\n"},
+			}).Build()
+
+			var status dnsv1beta1.DomainListStatus
+			rules, nextSync, err := loadSources(ctx, c, nil, "default", list.Spec.Sources, &status)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nextSync).To(BeZero())
+			Expect(rules).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}}))
+			Expect(status.RuleCount).To(Equal(int32(1)))
+			Expect(status.RulesHash).To(Equal(mustHash(ComputeRulesHash(rules))))
+			Expect(status.Sources).To(HaveLen(2))
+			Expect(status.Sources[0].ParseErrorCount).To(Equal(int32(1)))
+			Expect(status.Sources[0].ParseErrors[0].Line).To(Equal(int32(2)))
+			Expect(status.Sources[1].Error).To(Equal(
+				"Secret default/private-feed not found or not labeled dns.dnspolicies.io/domain-list=enabled"))
+		})
+
+		It("should only read Secrets labeled as list sources", func() {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "private-feed", Namespace: "default"},
+				Data:       map[string][]byte{"domains": []byte("private.example.com\n")},
+			}
+			sources := list.Spec.Sources[1:]
+
+			var status dnsv1beta1.DomainListStatus
+			c := fake.NewClientBuilder().WithScheme(testScheme).WithObjects(secret).Build()
+			rules, _, err := loadSources(ctx, c, nil, "default", sources, &status)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(BeEmpty())
+			Expect(status.Sources[0].Error).To(ContainSubstring("not labeled"))
+
+			secret.Labels = map[string]string{DomainListSecretLabel: "enabled"}
+			c = fake.NewClientBuilder().WithScheme(testScheme).WithObjects(secret).Build()
+			rules, _, err = loadSources(ctx, c, nil, "default", sources, &status)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "private.example.com"}}))
+		})
+
+		It("should load HTTP sources and requeue for the next download", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "||ads.example.com^\n") //nolint:errcheck
+			}))
+			DeferCleanup(server.Close)
+			sources := []dnsv1beta1.DomainListSource{{
+				Format: dnsv1beta1.DomainListFormatAdBlock,
+				HTTP:   &dnsv1beta1.HTTPSource{URL: server.URL, Interval: &metav1.Duration{Duration: 10 * time.Minute}},
+			}}
+
+			var status dnsv1beta1.DomainListStatus
+			c := fake.NewClientBuilder().WithScheme(testScheme).Build()
+			rules, nextSync, err := loadSources(ctx, c, NewFeedFetcher(server.Client()), "default", sources, &status)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "ads.example.com", MatchType: dnsv1beta1.MatchTypeSuffix}}))
+			Expect(nextSync).To(BeNumerically("~", 10*time.Minute, time.Minute))
+			Expect(status.Sources[0].Error).To(BeEmpty())
+			Expect(status.Sources[0].SHA256).NotTo(BeEmpty())
+			Expect(status.Sources[0].LastSyncTime).NotTo(BeNil())
+		})
+
+		It("should add stored source rules and wait for lists that are not loaded", func() {
+			c := fake.NewClientBuilder().WithScheme(testScheme).WithObjects(list).Build()
+			store := NewDomainListStore()
+			spec := &dnsv1beta1.DnsPolicySpec{BlockListRefs: []dnsv1beta1.DomainListReference{{Name: "threats"}}}
+
+			_, _, err := ExpandDomainLists(ctx, c, store, "default", spec)
+			Expect(err).To(MatchError(ErrDomainListNotLoaded))
+
+			store.Set(dnsv1beta1.DomainListKindNamespaced, "default", "threats", []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}})
+			expanded, unresolved, err := ExpandDomainLists(ctx, c, store, "default", spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unresolved).To(BeEmpty())
+			Expect(expanded.BlockList).To(Equal([]dnsv1beta1.DomainRule{
+				{Pattern: "ads.example.com"}, {Pattern: "manual.example.com"},
+			}))
+		})
+	})
+
+	Context("referencesList", func() {
+		It("should default the kind of a reference to DomainList", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{AllowListRefs: []dnsv1beta1.DomainListReference{{Name: "partners"}}}
+			Expect(referencesList(spec, dnsv1beta1.DomainListKindNamespaced, "partners")).To(BeTrue())
+			Expect(referencesList(spec, dnsv1beta1.DomainListKindCluster, "partners")).To(BeFalse())
+		})
+	})
+})
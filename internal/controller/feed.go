@@ -0,0 +1,316 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+const (
+	// DefaultFeedInterval is how often an HTTP source without an interval
+	// is downloaded.
+	DefaultFeedInterval = time.Hour
+	// MinFeedInterval is the shortest interval an HTTP source may set.
+	MinFeedInterval = time.Minute
+	// DefaultFeedMaxSize is the largest download accepted from an HTTP
+	// source without a maxSize.
+	DefaultFeedMaxSize int64 = 10 << 20
+	// MaxFeedMaxSize is the largest maxSize an HTTP source may set.
+	MaxFeedMaxSize int64 = 64 << 20
+	// DefaultFeedTimeout is the request timeout for HTTP sources.
+	DefaultFeedTimeout = 30 * time.Second
+
+	// feedRetryInterval bounds the wait before retrying a failed download.
+	feedRetryInterval = time.Minute
+	// maxChecksumFileSize is the largest checksum file read from sha256URL.
+	maxChecksumFileSize = 64 << 10
+	feedUserAgent       = "dns-mesh-controller"
+)
+
+// FeedFetcher downloads HTTP list sources. It keeps the last good content
+// of each source, downloads it again only once its interval has passed and
+// sends If-None-Match and If-Modified-Since so an unchanged list is not
+// transferred again. It is shared by the list reconcilers.
+type FeedFetcher struct {
+	// Client sends the requests. It should set a timeout.
+	Client *http.Client
+
+	now   func() time.Time
+	mu    sync.Mutex
+	feeds map[string]feedState
+}
+
+// feedState is what is remembered about a source between downloads.
+type feedState struct {
+	data         []byte
+	sha256       string
+	etag         string
+	lastModified string
+	syncedAt     time.Time
+	attemptedAt  time.Time
+	interval     time.Duration
+	err          error
+}
+
+// FeedResult is the content of an HTTP source and the outcome of its last
+// download.
+type FeedResult struct {
+	// Data is the last good content. It is nil until a download succeeds.
+	Data []byte
+	// SHA256 is the hex SHA-256 checksum of Data.
+	SHA256 string
+	// SyncedAt is when Data was last downloaded or found unchanged.
+	SyncedAt time.Time
+	// Err is why the last download failed, if it did. Data is then the
+	// content of an earlier download.
+	Err error
+	// NextSync is when the source should be fetched again.
+	NextSync time.Time
+}
+
+// NewFeedClient returns the HTTP client for list sources. It refuses to
+// connect to loopback, link-local and unspecified addresses, which covers
+// names resolving to them and redirects, so that lists cannot be used to
+// reach the controller's own endpoints or cloud metadata services.
+func NewFeedClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isFeedAddress(addrPort.Addr()) {
+				return fmt.Errorf("refusing to download a list from %s", addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// isFeedAddress reports whether lists may be downloaded from addr.
+func isFeedAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !addr.IsLoopback() && !addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast() &&
+		!addr.IsUnspecified()
+}
+
+// NewFeedFetcher returns a FeedFetcher sending requests with client.
+func NewFeedFetcher(client *http.Client) *FeedFetcher {
+	return &FeedFetcher{Client: client, now: time.Now, feeds: map[string]feedState{}}
+}
+
+// Fetch returns the content of an HTTP source, downloading it if its
+// interval has passed since the last attempt. A failed attempt is retried
+// after at most feedRetryInterval.
+func (f *FeedFetcher) Fetch(ctx context.Context, source *dnsv1beta1.HTTPSource) FeedResult {
+	key := feedKey(source)
+	interval := feedInterval(source)
+	now := f.now()
+
+	f.mu.Lock()
+	state := f.feeds[key]
+	f.mu.Unlock()
+
+	if !state.attemptedAt.IsZero() && now.Before(state.nextSync()) {
+		return state.result()
+	}
+
+	state.attemptedAt = now
+	state.interval = interval
+	state.err = f.download(ctx, source, &state)
+	if state.err == nil {
+		state.syncedAt = now
+	}
+
+	f.mu.Lock()
+	f.feeds[key] = state
+	f.prune(now)
+	f.mu.Unlock()
+	return state.result()
+}
+
+// download sends a conditional request for a source and, when the content
+// changed, verifies it and stores it in state. State is left unchanged on
+// error.
+func (f *FeedFetcher) download(ctx context.Context, source *dnsv1beta1.HTTPSource, state *feedState) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", feedUserAgent)
+	if state.data != nil {
+		if state.etag != "" {
+			req.Header.Set("If-None-Match", state.etag)
+		}
+		if state.lastModified != "" {
+			req.Header.Set("If-Modified-Since", state.lastModified)
+		}
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && state.data != nil:
+		return nil
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("GET %s: unexpected status %s", source.URL, resp.Status)
+	}
+
+	maxSize := feedMaxSize(source)
+	if resp.ContentLength > maxSize {
+		return fmt.Errorf("GET %s: content length %d exceeds the maximum size of %d bytes", source.URL, resp.ContentLength, maxSize)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", source.URL, err)
+	}
+	if int64(len(data)) > maxSize {
+		return fmt.Errorf("GET %s: content exceeds the maximum size of %d bytes", source.URL, maxSize)
+	}
+
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	expected := strings.ToLower(source.SHA256)
+	if source.SHA256URL != "" {
+		if expected, err = f.fetchChecksum(ctx, source.SHA256URL); err != nil {
+			return err
+		}
+	}
+	if expected != "" && expected != checksum {
+		return fmt.Errorf("GET %s: checksum %s does not match the expected %s", source.URL, checksum, expected)
+	}
+
+	state.data = data
+	state.sha256 = checksum
+	state.etag = resp.Header.Get("ETag")
+	state.lastModified = resp.Header.Get("Last-Modified")
+	return nil
+}
+
+// fetchChecksum downloads a checksum file in the format written by
+// sha256sum and returns the checksum on its first line.
+func (f *FeedFetcher) fetchChecksum(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", feedUserAgent)
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxChecksumFileSize))
+	if err != nil {
+		return "", fmt.Errorf("GET %s: %w", url, err)
+	}
+	checksum, ok := parseChecksumFile(data)
+	if !ok {
+		return "", fmt.Errorf("GET %s: no SHA-256 checksum found", url)
+	}
+	return checksum, nil
+}
+
+// parseChecksumFile returns the first field of the first non-empty line of
+// a checksum file if it is a hex SHA-256 checksum.
+func parseChecksumFile(data []byte) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		checksum := strings.ToLower(fields[0])
+		return checksum, isSHA256Hex(checksum)
+	}
+	return "", false
+}
+
+// prune forgets sources that have not been fetched for two of their
+// intervals, such as those of deleted lists. f.mu must be held.
+func (f *FeedFetcher) prune(now time.Time) {
+	for key, state := range f.feeds {
+		if now.After(state.attemptedAt.Add(2*state.interval + feedRetryInterval)) {
+			delete(f.feeds, key)
+		}
+	}
+}
+
+func (s *feedState) nextSync() time.Time {
+	if s.err != nil {
+		return s.attemptedAt.Add(min(s.interval, feedRetryInterval))
+	}
+	return s.attemptedAt.Add(s.interval)
+}
+
+func (s *feedState) result() FeedResult {
+	return FeedResult{Data: s.data, SHA256: s.sha256, SyncedAt: s.syncedAt, Err: s.err, NextSync: s.nextSync()}
+}
+
+// feedKey identifies the cached content of a source. Sources that download
+// the same URL with the same checks share it.
+func feedKey(source *dnsv1beta1.HTTPSource) string {
+	return strings.Join([]string{source.URL, strings.ToLower(source.SHA256), source.SHA256URL,
+		fmt.Sprint(feedMaxSize(source))}, "\x00")
+}
+
+func feedInterval(source *dnsv1beta1.HTTPSource) time.Duration {
+	if source.Interval == nil {
+		return DefaultFeedInterval
+	}
+	return source.Interval.Duration
+}
+
+func feedMaxSize(source *dnsv1beta1.HTTPSource) int64 {
+	if source.MaxSize == nil {
+		return DefaultFeedMaxSize
+	}
+	return source.MaxSize.Value()
+}
+
+func isSHA256Hex(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
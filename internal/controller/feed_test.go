@@ -0,0 +1,157 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("FeedFetcher", func() {
+	const content = "ads.example.com\ntracking.example.net\n"
+
+	var (
+		server   *httptest.Server
+		requests []*http.Request
+		body     string
+		checksum string
+		fetcher  *FeedFetcher
+		now      time.Time
+		source   *dnsv1beta1.HTTPSource
+	)
+
+	sha256Hex := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	BeforeEach(func() {
+		requests = nil
+		body = content
+		checksum = sha256Hex(content)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/feed.txt", func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+			etag := `"` + sha256Hex(body) + `"`
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			fmt.Fprint(w, body) //nolint:errcheck
+		})
+		mux.HandleFunc("/feed.txt.sha256", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s  feed.txt\n", checksum) //nolint:errcheck
+		})
+		server = httptest.NewServer(mux)
+		DeferCleanup(server.Close)
+
+		now = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+		fetcher = NewFeedFetcher(server.Client())
+		fetcher.now = func() time.Time { return now }
+		source = &dnsv1beta1.HTTPSource{
+			URL:      server.URL + "/feed.txt",
+			Interval: &metav1.Duration{Duration: 10 * time.Minute},
+		}
+	})
+
+	It("should download again only once the interval has passed", func() {
+		result := fetcher.Fetch(ctx, source)
+		Expect(result.Err).NotTo(HaveOccurred())
+		Expect(string(result.Data)).To(Equal(content))
+		Expect(result.SHA256).To(Equal(sha256Hex(content)))
+		Expect(result.NextSync).To(Equal(now.Add(10 * time.Minute)))
+
+		now = now.Add(5 * time.Minute)
+		Expect(fetcher.Fetch(ctx, source).Data).To(Equal(result.Data))
+		Expect(requests).To(HaveLen(1))
+
+		now = now.Add(5 * time.Minute)
+		result = fetcher.Fetch(ctx, source)
+		Expect(result.Err).NotTo(HaveOccurred())
+		Expect(string(result.Data)).To(Equal(content))
+		Expect(result.SyncedAt).To(Equal(now))
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[1].Header.Get("If-None-Match")).To(Equal(`"` + sha256Hex(content) + `"`))
+	})
+
+	It("should pick up changed content", func() {
+		Expect(fetcher.Fetch(ctx, source).Err).NotTo(HaveOccurred())
+
+		body = "malware.example.org\n"
+		now = now.Add(10 * time.Minute)
+		result := fetcher.Fetch(ctx, source)
+		Expect(result.Err).NotTo(HaveOccurred())
+		Expect(string(result.Data)).To(Equal(body))
+	})
+
+	It("should keep the last good content when a download is too large", func() {
+		source.MaxSize = resource.NewQuantity(int64(len(content)), resource.BinarySI)
+		Expect(fetcher.Fetch(ctx, source).Err).NotTo(HaveOccurred())
+
+		body = content + "malware.example.org\n"
+		now = now.Add(10 * time.Minute)
+		result := fetcher.Fetch(ctx, source)
+		Expect(result.Err).To(MatchError(ContainSubstring("exceeds the maximum size")))
+		Expect(string(result.Data)).To(Equal(content))
+		Expect(result.SyncedAt).To(Equal(now.Add(-10 * time.Minute)))
+		Expect(result.NextSync).To(Equal(now.Add(feedRetryInterval)))
+	})
+
+	It("should verify a pinned checksum", func() {
+		source.SHA256 = sha256Hex("something else")
+		result := fetcher.Fetch(ctx, source)
+		Expect(result.Err).To(MatchError(ContainSubstring("does not match the expected")))
+		Expect(result.Data).To(BeNil())
+	})
+
+	It("should verify the checksum from a checksum file", func() {
+		source.SHA256URL = server.URL + "/feed.txt.sha256"
+		Expect(fetcher.Fetch(ctx, source).Err).NotTo(HaveOccurred())
+
+		body = "tampered.example.com\n"
+		now = now.Add(10 * time.Minute)
+		result := fetcher.Fetch(ctx, source)
+		Expect(result.Err).To(MatchError(ContainSubstring("does not match the expected " + checksum)))
+		Expect(string(result.Data)).To(Equal(content))
+	})
+
+	It("should report unexpected statuses", func() {
+		source.URL = server.URL + "/missing.txt"
+		result := fetcher.Fetch(ctx, source)
+		Expect(result.Err).To(MatchError(ContainSubstring("unexpected status 404 Not Found")))
+		Expect(result.Data).To(BeNil())
+	})
+
+	It("should refuse to download from loopback addresses", func() {
+		result := NewFeedFetcher(NewFeedClient(time.Second)).Fetch(ctx, source)
+		Expect(result.Err).To(MatchError(ContainSubstring("refusing to download a list from 127.0.0.1")))
+		Expect(requests).To(BeEmpty())
+	})
+})
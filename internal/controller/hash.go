@@ -20,9 +20,14 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"slices"
 	"sort"
+	"strings"
+	"time"
 
-	dnspolicyv1alpha1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnspolicyv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
 )
 
 // ComputeSelectorHash computes a deterministic hash of a label selector.
@@ -56,34 +61,234 @@ func ComputeSelectorHash(selector map[string]string) (string, error) {
 	return hex.EncodeToString(hash[:]), nil
 }
 
+// canonicalSelector is the hashed form of a metav1.LabelSelector.
+type canonicalSelector struct {
+	MatchLabels      map[string]string                 `json:"matchLabels,omitempty"`
+	MatchExpressions []metav1.LabelSelectorRequirement `json:"matchExpressions,omitempty"`
+}
+
+// ComputeLabelSelectorHash computes a deterministic hash of a label selector.
+// A selector that only uses matchLabels, or whose expressions all fold into
+// matchLabels, hashes exactly like ComputeSelectorHash of those labels so
+// that clients hashing their pod labels keep finding it.
+func ComputeLabelSelectorHash(selector *metav1.LabelSelector) (string, error) {
+	canonical := canonicalizeSelector(selector)
+	if canonical == nil {
+		return "", nil
+	}
+	if len(canonical.MatchExpressions) == 0 {
+		return ComputeSelectorHash(canonical.MatchLabels)
+	}
+
+	// Marshal to JSON; map keys are written in sorted order
+	data, err := json.Marshal(canonical)
+	if err != nil {
+		return "", err
+	}
+
+	// Compute SHA256 hash
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// canonicalizeSelector returns an equivalent selector in canonical form:
+// an In requirement with a single value becomes a matchLabel, values are
+// sorted and deduplicated, and requirements are sorted and deduplicated.
+// It returns nil for a nil or empty selector.
+func canonicalizeSelector(selector *metav1.LabelSelector) *canonicalSelector {
+	if selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0) {
+		return nil
+	}
+
+	canonical := &canonicalSelector{MatchLabels: make(map[string]string, len(selector.MatchLabels))}
+	for k, v := range selector.MatchLabels {
+		canonical.MatchLabels[k] = v
+	}
+
+	var requirements []metav1.LabelSelectorRequirement
+	for _, req := range selector.MatchExpressions {
+		values := slices.Clone(req.Values)
+		sort.Strings(values)
+		values = slices.Compact(values)
+
+		if req.Operator == metav1.LabelSelectorOpIn && len(values) == 1 {
+			if existing, ok := canonical.MatchLabels[req.Key]; !ok || existing == values[0] {
+				canonical.MatchLabels[req.Key] = values[0]
+				continue
+			}
+		}
+		requirements = append(requirements, metav1.LabelSelectorRequirement{
+			Key:      req.Key,
+			Operator: req.Operator,
+			Values:   values,
+		})
+	}
+
+	sort.Slice(requirements, func(i, j int) bool {
+		return requirementKey(requirements[i]) < requirementKey(requirements[j])
+	})
+	canonical.MatchExpressions = slices.CompactFunc(requirements, func(a, b metav1.LabelSelectorRequirement) bool {
+		return requirementKey(a) == requirementKey(b)
+	})
+	return canonical
+}
+
+func requirementKey(req metav1.LabelSelectorRequirement) string {
+	return req.Key + "\x00" + string(req.Operator) + "\x00" + strings.Join(req.Values, "\x00")
+}
+
+// canonicalSubject is the hashed form of a Subject.
+type canonicalSubject struct {
+	ServiceAccounts   []string           `json:"serviceAccounts,omitempty"`
+	Namespace         string             `json:"namespace,omitempty"`
+	NamespaceSelector *canonicalSelector `json:"namespaceSelector,omitempty"`
+}
+
+// canonicalizeSubject returns a subject in canonical form: serviceAccount
+// and serviceAccounts become one sorted, deduplicated list. It returns nil
+// for a nil or empty subject.
+func canonicalizeSubject(subject *dnspolicyv1beta1.Subject) *canonicalSubject {
+	if subject.IsEmpty() {
+		return nil
+	}
+	serviceAccounts := subject.ServiceAccountNames()
+	sort.Strings(serviceAccounts)
+	return &canonicalSubject{
+		ServiceAccounts:   slices.Compact(serviceAccounts),
+		Namespace:         subject.Namespace,
+		NamespaceSelector: canonicalizeSelector(subject.NamespaceSelector),
+	}
+}
+
+// ComputeSubjectHash computes a deterministic hash of a Subject. A subject
+// with at most one service account and no namespaceSelector hashes like
+// ComputeSelectorHash of its {"serviceAccount": ..., "namespace": ...} map,
+// which is how subjects were written before they were typed.
+func ComputeSubjectHash(subject *dnspolicyv1beta1.Subject) (string, error) {
+	canonical := canonicalizeSubject(subject)
+	if canonical == nil {
+		return "", nil
+	}
+	if len(canonical.ServiceAccounts) <= 1 && canonical.NamespaceSelector == nil {
+		legacy := map[string]string{}
+		if len(canonical.ServiceAccounts) == 1 {
+			legacy["serviceAccount"] = canonical.ServiceAccounts[0]
+		}
+		if canonical.Namespace != "" {
+			legacy["namespace"] = canonical.Namespace
+		}
+		return ComputeSelectorHash(legacy)
+	}
+
+	// Marshal to JSON
+	data, err := json.Marshal(canonical)
+	if err != nil {
+		return "", err
+	}
+
+	// Compute SHA256 hash
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// SelectorHashFor returns the hash clients use to look up a policy: the
+// TargetSelector hash for label-only policies, the Subject hash for
+// identity-only policies, and a hash of both when a policy uses both, since
+// it then selects fewer pods than either alone.
+func SelectorHashFor(spec *dnspolicyv1beta1.DnsPolicySpec) (string, error) {
+	selector := canonicalizeSelector(spec.TargetSelector)
+	switch {
+	case selector == nil:
+		return ComputeSubjectHash(spec.Subject)
+	case spec.Subject.IsEmpty():
+		return ComputeLabelSelectorHash(spec.TargetSelector)
+	}
+
+	// Marshal to JSON
+	data, err := json.Marshal(struct {
+		TargetSelector *canonicalSelector `json:"targetSelector"`
+		Subject        *canonicalSubject  `json:"subject"`
+	}{
+		TargetSelector: selector,
+		Subject:        canonicalizeSubject(spec.Subject),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Compute SHA256 hash
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// normalizedRule is the hashed form of a DomainRule. Description is left out
+// because editing a comment does not change what the sidecar enforces.
+type normalizedRule struct {
+	Pattern   string
+	MatchType dnspolicyv1beta1.MatchType
+	QTypes    []string                      `json:",omitempty"`
+	Action    *dnspolicyv1beta1.BlockAction `json:",omitempty"`
+	ExpiresAt string                        `json:",omitempty"`
+}
+
+// ComputeRulesHash computes a hash of a rule list that does not depend on
+// rule order or descriptions. It returns an empty hash for an empty list.
+func ComputeRulesHash(rules []dnspolicyv1beta1.DomainRule) (string, error) {
+	if len(rules) == 0 {
+		return "", nil
+	}
+
+	// Marshal to JSON
+	data, err := json.Marshal(normalizeRules(rules, true))
+	if err != nil {
+		return "", err
+	}
+
+	// Compute SHA256 hash
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
 // ComputeSpecHash computes a hash of the entire DnsPolicySpec.
 // This is used to detect when the policy configuration has changed.
-func ComputeSpecHash(spec *dnspolicyv1alpha1.DnsPolicySpec) (string, error) {
+func ComputeSpecHash(spec *dnspolicyv1beta1.DnsPolicySpec) (string, error) {
+	return computeSpecHash(nil, spec)
+}
+
+// ComputeClusterSpecHash computes a hash of the entire ClusterDnsPolicySpec.
+func ComputeClusterSpecHash(spec *dnspolicyv1beta1.ClusterDnsPolicySpec) (string, error) {
+	return computeSpecHash(spec.NamespaceSelector, clusterPolicySpec(spec))
+}
+
+func computeSpecHash(namespaceSelector *metav1.LabelSelector, spec *dnspolicyv1beta1.DnsPolicySpec) (string, error) {
 	// Create a normalized representation
 	normalized := struct {
-		TargetSelector map[string]string
-		BlockList      []string
+		NamespaceSelector *canonicalSelector `json:",omitempty"`
+		TargetSelector    *canonicalSelector
+		Subject           *canonicalSubject `json:",omitempty"`
+		BlockList         []normalizedRule
+		AllowList         []normalizedRule               `json:",omitempty"`
+		DefaultAction     dnspolicyv1beta1.DefaultAction `json:",omitempty"`
+		Action            *dnspolicyv1beta1.BlockAction  `json:",omitempty"`
+		DryRun            bool                           `json:",omitempty"`
+		Priority          int32                          `json:",omitempty"`
+		MergeMode         dnspolicyv1beta1.MergeMode     `json:",omitempty"`
 	}{
-		TargetSelector: spec.TargetSelector,
-		BlockList:      spec.BlockList,
+		// encoding/json writes map keys in sorted order
+		NamespaceSelector: canonicalizeSelector(namespaceSelector),
+		TargetSelector:    canonicalizeSelector(spec.TargetSelector),
+		Subject:           canonicalizeSubject(spec.Subject),
+		BlockList:         normalizeRules(spec.BlockList, true),
+		AllowList:         normalizeRules(spec.AllowList, false),
+		Action:            spec.Action,
+		DryRun:            spec.DryRun,
+		Priority:          spec.Priority,
 	}
-	if normalized.BlockList != nil {
-		sort.Strings(normalized.BlockList)
+	if spec.DefaultAction != dnspolicyv1beta1.DefaultActionAllow {
+		normalized.DefaultAction = spec.DefaultAction
 	}
-
-	// Sort target selector keys
-	if normalized.TargetSelector != nil {
-		keys := make([]string, 0, len(normalized.TargetSelector))
-		for k := range normalized.TargetSelector {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-
-		sortedSelector := make(map[string]string, len(normalized.TargetSelector))
-		for _, k := range keys {
-			sortedSelector[k] = normalized.TargetSelector[k]
-		}
-		normalized.TargetSelector = sortedSelector
+	if spec.MergeMode != dnspolicyv1beta1.MergeModeAppend {
+		normalized.MergeMode = spec.MergeMode
 	}
 
 	// Marshal to JSON
@@ -96,3 +301,55 @@ func ComputeSpecHash(spec *dnspolicyv1alpha1.DnsPolicySpec) (string, error) {
 	hash := sha256.Sum256(data)
 	return hex.EncodeToString(hash[:]), nil
 }
+
+// normalizeRules returns rules in a deterministic order with inferred match
+// types filled in and query types upper-cased, sorted and deduplicated.
+// Per-rule actions are only kept when withAction is set (BlockList).
+func normalizeRules(rules []dnspolicyv1beta1.DomainRule, withAction bool) []normalizedRule {
+	if rules == nil {
+		return nil
+	}
+
+	normalized := make([]normalizedRule, 0, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		n := normalizedRule{
+			Pattern:   rule.Pattern,
+			MatchType: rule.EffectiveMatchType(),
+		}
+		for _, qtype := range rule.QTypes {
+			n.QTypes = append(n.QTypes, strings.ToUpper(qtype))
+		}
+		sort.Strings(n.QTypes)
+		n.QTypes = slices.Compact(n.QTypes)
+		if withAction {
+			n.Action = rule.Action
+		}
+		if rule.ExpiresAt != nil {
+			n.ExpiresAt = rule.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		normalized = append(normalized, n)
+	}
+
+	// Order by pattern, breaking ties on the full encoded rule
+	keys := make([]string, len(normalized))
+	for i := range normalized {
+		data, _ := json.Marshal(normalized[i])
+		keys[i] = normalized[i].Pattern + "\x00" + string(data)
+	}
+	sort.Sort(byKey{keys: keys, rules: normalized})
+	return normalized
+}
+
+// byKey sorts rules by a parallel slice of precomputed keys.
+type byKey struct {
+	keys  []string
+	rules []normalizedRule
+}
+
+func (b byKey) Len() int           { return len(b.keys) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.rules[i], b.rules[j] = b.rules[j], b.rules[i]
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("Policy hashing", func() {
+	Context("ComputeSpecHash", func() {
+		It("should not depend on rule order and should not reorder the spec", func() {
+			a := &dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "payments"}},
+				BlockList: []dnsv1beta1.DomainRule{
+					{Pattern: "b.example.com"},
+					{Pattern: "a.example.com", QTypes: []string{"AAAA", "A"}},
+				},
+				AllowList: []dnsv1beta1.DomainRule{{Pattern: "z.example.com"}, {Pattern: "y.example.com"}},
+			}
+			b := &dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "payments"}},
+				BlockList: []dnsv1beta1.DomainRule{
+					{Pattern: "a.example.com", QTypes: []string{"a", "AAAA", "A"}},
+					{Pattern: "b.example.com"},
+				},
+				AllowList: []dnsv1beta1.DomainRule{{Pattern: "y.example.com"}, {Pattern: "z.example.com"}},
+			}
+
+			hashA, err := ComputeSpecHash(a)
+			Expect(err).NotTo(HaveOccurred())
+			hashB, err := ComputeSpecHash(b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hashA).To(Equal(hashB))
+			Expect(a.BlockList[0].Pattern).To(Equal("b.example.com"))
+		})
+
+		It("should treat inferred and explicit match types alike and ignore descriptions", func() {
+			inferred := &dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "payments"}},
+				BlockList:      []dnsv1beta1.DomainRule{{Pattern: "*.ads.net"}, {Pattern: "tracker.io"}},
+			}
+			explicit := &dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "payments"}},
+				BlockList: []dnsv1beta1.DomainRule{
+					{Pattern: "*.ads.net", MatchType: dnsv1beta1.MatchTypeWildcard, Description: "ad network"},
+					{Pattern: "tracker.io", MatchType: dnsv1beta1.MatchTypeExact},
+				},
+			}
+
+			hashA, err := ComputeSpecHash(inferred)
+			Expect(err).NotTo(HaveOccurred())
+			hashB, err := ComputeSpecHash(explicit)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hashA).To(Equal(hashB))
+
+			explicit.BlockList[1].MatchType = dnsv1beta1.MatchTypeSuffix
+			hashC, err := ComputeSpecHash(explicit)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hashC).NotTo(Equal(hashA))
+		})
+
+		It("should treat an unset default action as Allow", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "payments"}},
+				AllowList:      []dnsv1beta1.DomainRule{{Pattern: "api.example.com"}},
+			}
+			unset, err := ComputeSpecHash(spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			spec.DefaultAction = dnsv1beta1.DefaultActionAllow
+			allow, err := ComputeSpecHash(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allow).To(Equal(unset))
+
+			spec.DefaultAction = dnsv1beta1.DefaultActionDeny
+			deny, err := ComputeSpecHash(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deny).NotTo(Equal(unset))
+		})
+	})
+
+	Context("ComputeLabelSelectorHash", func() {
+		It("should hash plain matchLabels like ComputeSelectorHash", func() {
+			expected, err := ComputeSelectorHash(map[string]string{"app": "frontend", "tier": "web"})
+			Expect(err).NotTo(HaveOccurred())
+
+			hash, err := ComputeLabelSelectorHash(&metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "frontend"},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "web"}},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hash).To(Equal(expected))
+		})
+
+		It("should not depend on expression or value order", func() {
+			a := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "edge"}},
+				{Key: "environment", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"dev"}},
+			}}
+			b := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "environment", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"dev"}},
+				{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"edge", "web"}},
+				{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "edge"}},
+			}}
+
+			hashA, err := ComputeLabelSelectorHash(a)
+			Expect(err).NotTo(HaveOccurred())
+			hashB, err := ComputeLabelSelectorHash(b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hashA).To(Equal(hashB))
+
+			b.MatchExpressions[0].Operator = metav1.LabelSelectorOpIn
+			hashC, err := ComputeLabelSelectorHash(b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hashC).NotTo(Equal(hashA))
+		})
+	})
+
+	Context("SelectorHashFor", func() {
+		It("should hash a selector combined with a subject apart from either alone", func() {
+			selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}
+			subject := &dnsv1beta1.Subject{ServiceAccount: "payments"}
+
+			labelsOnly, err := SelectorHashFor(&dnsv1beta1.DnsPolicySpec{TargetSelector: selector})
+			Expect(err).NotTo(HaveOccurred())
+			subjectOnly, err := SelectorHashFor(&dnsv1beta1.DnsPolicySpec{Subject: subject})
+			Expect(err).NotTo(HaveOccurred())
+			combined, err := SelectorHashFor(&dnsv1beta1.DnsPolicySpec{TargetSelector: selector, Subject: subject})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(combined).NotTo(BeElementOf(labelsOnly, subjectOnly))
+			Expect(labelsOnly).To(Equal(mustHash(ComputeSelectorHash(selector.MatchLabels))))
+			Expect(subjectOnly).To(Equal(mustHash(ComputeSelectorHash(map[string]string{"serviceAccount": "payments"}))))
+
+			expressions := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"api"}},
+			}}
+			Expect(SelectorHashFor(&dnsv1beta1.DnsPolicySpec{TargetSelector: expressions, Subject: subject})).To(Equal(combined))
+		})
+	})
+
+	Context("ComputeSubjectHash", func() {
+		It("should keep the hash of single-account subjects and ignore account order", func() {
+			legacy := mustHash(ComputeSelectorHash(map[string]string{"serviceAccount": "payments", "namespace": "shop"}))
+			Expect(ComputeSubjectHash(&dnsv1beta1.Subject{ServiceAccount: "payments", Namespace: "shop"})).To(Equal(legacy))
+			Expect(ComputeSubjectHash(&dnsv1beta1.Subject{ServiceAccounts: []string{"payments"}, Namespace: "shop"})).To(Equal(legacy))
+
+			several := mustHash(ComputeSubjectHash(&dnsv1beta1.Subject{ServiceAccounts: []string{"payments", "billing"}}))
+			Expect(ComputeSubjectHash(&dnsv1beta1.Subject{
+				ServiceAccount: "billing", ServiceAccounts: []string{"payments"},
+			})).To(Equal(several))
+			Expect(several).NotTo(Equal(legacy))
+		})
+	})
+
+	Context("overlappingRules", func() {
+		It("should report names present in both lists", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				AllowList: []dnsv1beta1.DomainRule{{Pattern: "API.example.com."}, {Pattern: "*.example.org"}},
+				BlockList: []dnsv1beta1.DomainRule{
+					{Pattern: "api.example.com"}, {Pattern: "ads.example.net"}, {Pattern: "*.example.org"},
+				},
+			}
+			Expect(overlappingRules(spec)).To(Equal([]string{"*.example.org", "api.example.com"}))
+		})
+	})
+})
+
+// mustHash returns a hash, failing the spec on error.
+func mustHash(hash string, err error) string {
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return hash
+}
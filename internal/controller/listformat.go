@@ -0,0 +1,207 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// maxSourceLineLength is the longest line a domain list source may contain.
+const maxSourceLineLength = 64 * 1024
+
+// hostsLocalNames are the entries of a stock hosts file. They name the local
+// machine, not a blocked domain, and are skipped.
+var hostsLocalNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// ParseDomainList parses a domain list source in the given format into
+// normalized rules. Comments and blank lines are skipped. Lines that cannot
+// be parsed are skipped too and returned as parse errors, which name the
+// line but never quote it, since status is readable by more users than the
+// source; the error result is only set when the source itself cannot be read.
+func ParseDomainList(r io.Reader, format dnsv1beta1.DomainListFormat) ([]dnsv1beta1.DomainRule, []dnsv1beta1.ParseError, error) {
+	var parseLine func(string) ([]string, error)
+	matchType := dnsv1beta1.MatchType("")
+	switch format {
+	case dnsv1beta1.DomainListFormatPlain, "":
+		parseLine = parsePlainLine
+	case dnsv1beta1.DomainListFormatHosts:
+		parseLine = parseHostsLine
+		matchType = dnsv1beta1.MatchTypeExact
+	case dnsv1beta1.DomainListFormatAdBlock:
+		parseLine = parseAdBlockLine
+		matchType = dnsv1beta1.MatchTypeSuffix
+	case dnsv1beta1.DomainListFormatDnsmasq:
+		parseLine = parseDnsmasqLine
+		matchType = dnsv1beta1.MatchTypeSuffix
+	default:
+		return nil, nil, fmt.Errorf("unsupported format %q", format)
+	}
+
+	var rules []dnsv1beta1.DomainRule
+	var parseErrors []dnsv1beta1.ParseError
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxSourceLineLength)
+	for line := int32(1); scanner.Scan(); line++ {
+		domains, err := parseLine(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			parseErrors = append(parseErrors, dnsv1beta1.ParseError{Line: line, Message: err.Error()})
+			continue
+		}
+		for _, domain := range domains {
+			rule := dnsv1beta1.DomainRule{Pattern: CanonicalDomain(domain)}
+			// Exact is inferred for names without '*', so only Suffix is stored
+			if matchType == dnsv1beta1.MatchTypeSuffix {
+				rule.MatchType = matchType
+			}
+			if matchType == dnsv1beta1.MatchTypeExact && strings.Contains(rule.Pattern, "*") {
+				err = fmt.Errorf("wildcards are not allowed in this format")
+			} else if ValidatePattern(rule.Pattern, rule.EffectiveMatchType()) != nil {
+				err = fmt.Errorf("not a valid domain name or wildcard")
+			}
+			if err != nil {
+				parseErrors = append(parseErrors, dnsv1beta1.ParseError{Line: line, Message: err.Error()})
+				continue
+			}
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return normalizeRuleList(rules), parseErrors, nil
+}
+
+// stripComment removes a '#' comment from a line.
+func stripComment(line string) string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+// parsePlainLine parses a line holding a single domain or wildcard pattern.
+func parsePlainLine(line string) ([]string, error) {
+	line = stripComment(line)
+	if line == "" {
+		return nil, nil
+	}
+	if fields := strings.Fields(line); len(fields) > 1 {
+		return nil, fmt.Errorf("expected one domain per line, got %d fields", len(fields))
+	}
+	return []string{line}, nil
+}
+
+// parseHostsLine parses a hosts file line: an address followed by names.
+func parseHostsLine(line string) ([]string, error) {
+	fields := strings.Fields(stripComment(line))
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if _, err := netip.ParseAddr(fields[0]); err != nil {
+		return nil, fmt.Errorf("the first field is not an IP address")
+	}
+	if len(fields) == 1 {
+		return nil, fmt.Errorf("missing host name after the address")
+	}
+	var names []string
+	for _, name := range fields[1:] {
+		if !hostsLocalNames[strings.ToLower(name)] {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// parseAdBlockLine parses an AdBlock network rule of the form ||domain^.
+// Comments start with '!', a single '#' or '['. Exceptions, rule options
+// and cosmetic rules have no DNS equivalent and are reported.
+func parseAdBlockLine(line string) ([]string, error) {
+	switch {
+	case line == "", strings.HasPrefix(line, "!"), strings.HasPrefix(line, "["),
+		strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "##"):
+		return nil, nil
+	case strings.HasPrefix(line, "@@"):
+		return nil, fmt.Errorf("exception rules are not supported")
+	case strings.Contains(line, "##") || strings.Contains(line, "#@#"):
+		return nil, fmt.Errorf("cosmetic rules are not supported")
+	case !strings.HasPrefix(line, "||"):
+		return nil, fmt.Errorf("expected a rule of the form ||domain^")
+	}
+
+	rule := strings.TrimPrefix(line, "||")
+	if i := strings.IndexByte(rule, '$'); i >= 0 {
+		return nil, fmt.Errorf("rule options are not supported")
+	}
+	domain, ok := strings.CutSuffix(strings.TrimSuffix(rule, "|"), "^")
+	if !ok || domain == "" {
+		return nil, fmt.Errorf("expected a rule of the form ||domain^")
+	}
+	return []string{domain}, nil
+}
+
+// parseDnsmasqLine parses an address=, server= or local= line naming one or
+// more domains between slashes, e.g. address=/ads.example.com/0.0.0.0.
+// dnsmasq only allows comments on lines of their own.
+func parseDnsmasqLine(line string) ([]string, error) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	option, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return nil, fmt.Errorf("expected option=value")
+	}
+	switch option = strings.TrimSpace(option); option {
+	case "address", "server", "local":
+	default:
+		return nil, fmt.Errorf("unsupported option, expected address, server or local")
+	}
+
+	value = strings.TrimSpace(value)
+	parts := strings.Split(strings.TrimPrefix(value, "/"), "/")
+	if !strings.HasPrefix(value, "/") || len(parts) < 2 {
+		return nil, fmt.Errorf("expected %s=/domain/...", option)
+	}
+	var domains []string
+	for _, domain := range parts[:len(parts)-1] {
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("expected %s=/domain/...", option)
+	}
+	return domains, nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("Domain list formats", func() {
+	parse := func(format dnsv1beta1.DomainListFormat, content string) ([]dnsv1beta1.DomainRule, []dnsv1beta1.ParseError) {
+		rules, parseErrors, err := ParseDomainList(strings.NewReader(content), format)
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		return rules, parseErrors
+	}
+
+	It("should parse plain lists", func() {
+		rules, parseErrors := parse(dnsv1beta1.DomainListFormatPlain, `# threat feed
+Tracking.Ads.NET.
+*.malicious-site.com  # whole zone
+
+tracking.ads.net
+two names.example
+`)
+		Expect(rules).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "*.malicious-site.com"}, {Pattern: "tracking.ads.net"}}))
+		Expect(parseErrors).To(ConsistOf(dnsv1beta1.ParseError{Line: 6, Message: "expected one domain per line, got 2 fields"}))
+	})
+
+	It("should parse hosts files and skip local names", func() {
+		rules, parseErrors := parse(dnsv1beta1.DomainListFormatHosts, `127.0.0.1 localhost
+::1 ip6-localhost ip6-loopback
+0.0.0.0 0.0.0.0
+0.0.0.0 ads.example.com tracker.example.com # inline comment
+0.0.0.0 *.example.org
+ads.example.net
+`)
+		Expect(rules).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}, {Pattern: "tracker.example.com"}}))
+		Expect(parseErrors).To(HaveLen(2))
+		Expect(parseErrors[0].Line).To(Equal(int32(5)))
+		Expect(parseErrors[1]).To(Equal(dnsv1beta1.ParseError{Line: 6, Message: "the first field is not an IP address"}))
+	})
+
+	It("should parse AdBlock network rules as suffix rules", func() {
+		rules, parseErrors := parse(dnsv1beta1.DomainListFormatAdBlock, `[Adblock Plus 2.0]
+! Title: threat feed
+||ads.example.com^
+||tracker.example.net^|
+@@||cdn.example.com^
+||ads.example.org^$third-party
+example.com##.banner
+/banner/*
+`)
+		Expect(rules).To(Equal([]dnsv1beta1.DomainRule{
+			{Pattern: "ads.example.com", MatchType: dnsv1beta1.MatchTypeSuffix},
+			{Pattern: "tracker.example.net", MatchType: dnsv1beta1.MatchTypeSuffix},
+		}))
+		Expect(parseErrors).To(Equal([]dnsv1beta1.ParseError{
+			{Line: 5, Message: "exception rules are not supported"},
+			{Line: 6, Message: "rule options are not supported"},
+			{Line: 7, Message: "cosmetic rules are not supported"},
+			{Line: 8, Message: "expected a rule of the form ||domain^"},
+		}))
+	})
+
+	It("should parse dnsmasq address, server and local lines as suffix rules", func() {
+		rules, parseErrors := parse(dnsv1beta1.DomainListFormatDnsmasq, `# blocked zones
+address=/ads.example.com/0.0.0.0
+address=/a.example.net/b.example.net/
+server=/tracker.example.org/
+local=/internal.example/
+cache-size=1000
+address=ads.example.com
+`)
+		Expect(rules).To(HaveLen(5))
+		Expect(rules[0]).To(Equal(dnsv1beta1.DomainRule{Pattern: "a.example.net", MatchType: dnsv1beta1.MatchTypeSuffix}))
+		Expect(parseErrors).To(Equal([]dnsv1beta1.ParseError{
+			{Line: 6, Message: "unsupported option, expected address, server or local"},
+			{Line: 7, Message: "expected address=/domain/..."},
+		}))
+	})
+
+	It("should report invalid domains with their line", func() {
+		_, parseErrors := parse(dnsv1beta1.DomainListFormatAdBlock, "||ads..example.com^\n")
+		Expect(parseErrors).To(Equal([]dnsv1beta1.ParseError{{Line: 1, Message: "not a valid domain name or wildcard"}}))
+	})
+})
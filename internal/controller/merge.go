@@ -0,0 +1,395 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// Kinds of the policies that can contribute to an EffectivePolicy.
+const (
+	KindDnsPolicy        = "DnsPolicy"
+	KindClusterDnsPolicy = "ClusterDnsPolicy"
+)
+
+// PolicySource identifies a policy that contributed to an EffectivePolicy.
+type PolicySource struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Priority  int32  `json:"priority,omitempty"`
+}
+
+// String returns the source as kind/name or kind/namespace/name.
+// The priority is left out.
+func (s PolicySource) String() string {
+	if s.Namespace == "" {
+		return s.Kind + "/" + s.Name
+	}
+	return s.Kind + "/" + s.Namespace + "/" + s.Name
+}
+
+// EffectiveRule is a rule of the merged policy together with the policies
+// that contributed it. The rule of the first source wins when several
+// policies list the same pattern.
+type EffectiveRule struct {
+	dnsv1beta1.DomainRule `json:",inline"`
+	// Sources lists every policy containing this rule, in merge order.
+	Sources []PolicySource `json:"sources"`
+	// DryRun is set when the rule only comes from dry-run policies while
+	// other contributing policies are enforced. Sidecars log such queries
+	// instead of blocking them.
+	DryRun bool `json:"dryrun,omitempty"`
+}
+
+// EffectivePolicy is the single policy a sidecar enforces after every
+// DnsPolicy and ClusterDnsPolicy that applies to its pod is merged.
+type EffectivePolicy struct {
+	// SchemaVersion is PolicyDocumentSchemaVersion.
+	SchemaVersion string `json:"schemaVersion"`
+	// Sources lists the merged policies in merge order.
+	Sources []PolicySource `json:"sources"`
+	// Shadowed lists the policies that apply to the pod but were ignored
+	// because a higher-priority policy uses MergeMode Override.
+	Shadowed []PolicySource `json:"shadowed,omitempty"`
+	// BlockList is the union of the BlockLists of all sources. Rules
+	// without an action carry the resolved action of their source.
+	BlockList []EffectiveRule `json:"blockList"`
+	// AllowList is the union of the AllowLists of all sources. Under a
+	// Deny DefaultAction it only keeps the rules that every enforced
+	// Deny-default source allows.
+	AllowList []EffectiveRule `json:"allowList"`
+	// DefaultAction is Deny when any enforced source denies by default.
+	DefaultAction dnsv1beta1.DefaultAction `json:"defaultAction"`
+	// Action answers queries rejected by DefaultAction.
+	Action *dnsv1beta1.BlockAction `json:"action"`
+	// DryRun is set when every source is a dry-run policy.
+	DryRun bool `json:"dryrun"`
+}
+
+// mergeInput is a policy to merge in its DnsPolicySpec form.
+type mergeInput struct {
+	source PolicySource
+	spec   *dnsv1beta1.DnsPolicySpec
+}
+
+// MergePolicies merges the policies that apply to one pod into a single
+// EffectivePolicy. Policies are merged by descending Priority, and the
+// first policy with MergeMode Override shadows every policy after it,
+// except that a DnsPolicy only shadows other DnsPolicies.
+// The remaining policies are merged deny-wins:
+//
+//   - BlockList and AllowList are unions, and a blocked name stays blocked
+//     whichever policy allows it;
+//   - DefaultAction is Deny if any enforced policy denies by default, and
+//     then a name is only allowed if every such policy allows it;
+//   - a policy only enforces if any of the merged policies is not a dry run.
+//
+// Ties, such as the action of a pattern blocked by two policies, go to the
+// policy merged first. Among policies of equal priority that is the most
+// specific one: DnsPolicies before ClusterDnsPolicies, then by namespace
+// and name.
+func MergePolicies(policies []*dnsv1beta1.DnsPolicy, clusterPolicies []*dnsv1beta1.ClusterDnsPolicy) *EffectivePolicy {
+	inputs := make([]mergeInput, 0, len(policies)+len(clusterPolicies))
+	for _, policy := range policies {
+		inputs = append(inputs, dnsPolicyInput(policy))
+	}
+	for _, policy := range clusterPolicies {
+		inputs = append(inputs, clusterPolicyInput(policy))
+	}
+	sort.SliceStable(inputs, func(i, j int) bool {
+		return lessSource(inputs[i].source, inputs[j].source)
+	})
+
+	// Policies merged after an Override policy are shadowed by it, but a
+	// DnsPolicy cannot switch off the cluster-wide baselines
+	var shadowed []PolicySource
+	merged := inputs[:0:0]
+	var overridden, clusterOverridden bool
+	for _, input := range inputs {
+		if clusterOverridden || (overridden && input.source.Kind == KindDnsPolicy) {
+			shadowed = append(shadowed, input.source)
+			continue
+		}
+		merged = append(merged, input)
+		if input.spec.MergeMode == dnsv1beta1.MergeModeOverride {
+			overridden = true
+			clusterOverridden = clusterOverridden || input.source.Kind == KindClusterDnsPolicy
+		}
+	}
+	inputs = merged
+
+	effective := mergeInputs(inputs)
+	effective.Shadowed = shadowed
+	return effective
+}
+
+// dnsPolicyInput returns the merge input of a DnsPolicy.
+func dnsPolicyInput(policy *dnsv1beta1.DnsPolicy) mergeInput {
+	return mergeInput{
+		source: PolicySource{Kind: KindDnsPolicy, Namespace: policy.Namespace, Name: policy.Name, Priority: policy.Spec.Priority},
+		spec:   &policy.Spec,
+	}
+}
+
+// clusterPolicyInput returns the merge input of a ClusterDnsPolicy.
+func clusterPolicyInput(policy *dnsv1beta1.ClusterDnsPolicy) mergeInput {
+	return mergeInput{
+		source: PolicySource{Kind: KindClusterDnsPolicy, Name: policy.Name, Priority: policy.Spec.Priority},
+		spec:   clusterPolicySpec(&policy.Spec),
+	}
+}
+
+// shadowingSource returns the policy that shadows target, or nil. Every
+// candidate must apply to at least the pods target applies to; target is
+// shadowed by the first candidate in merge order that uses MergeMode
+// Override and is merged before it. A DnsPolicy never shadows a
+// ClusterDnsPolicy.
+func shadowingSource(target mergeInput, candidates []mergeInput) *PolicySource {
+	var shadowing *PolicySource
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.spec.MergeMode != dnsv1beta1.MergeModeOverride || !lessSource(candidate.source, target.source) ||
+			(candidate.source.Kind == KindDnsPolicy && target.source.Kind == KindClusterDnsPolicy) {
+			continue
+		}
+		if shadowing == nil || lessSource(candidate.source, *shadowing) {
+			shadowing = &candidate.source
+		}
+	}
+	return shadowing
+}
+
+// lessSource orders policies by descending priority, then DnsPolicies
+// before ClusterDnsPolicies, then by namespace and name.
+func lessSource(a, b PolicySource) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.Kind != b.Kind {
+		return a.Kind == KindDnsPolicy
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+func mergeInputs(inputs []mergeInput) *EffectivePolicy {
+	effective := &EffectivePolicy{
+		SchemaVersion: PolicyDocumentSchemaVersion,
+		Sources:       []PolicySource{},
+		BlockList:     []EffectiveRule{},
+		AllowList:     []EffectiveRule{},
+		DefaultAction: dnsv1beta1.DefaultActionAllow,
+	}
+
+	// Dry-run policies only count as enforced when nothing else is
+	enforced := slices.ContainsFunc(inputs, func(input mergeInput) bool { return !input.spec.DryRun })
+	effective.DryRun = len(inputs) > 0 && !enforced
+
+	blockList := newRuleMerger(true)
+	allowList := newRuleMerger(false)
+	for _, input := range inputs {
+		effective.Sources = append(effective.Sources, input.source)
+		dryRun := enforced && input.spec.DryRun
+		action := ResolveAction(input.spec)
+
+		for i := range input.spec.BlockList {
+			rule := input.spec.BlockList[i].DeepCopy()
+			if rule.Action == nil {
+				rule.Action = action.DeepCopy()
+			}
+			blockList.add(rule, input.source, dryRun)
+		}
+		for i := range input.spec.AllowList {
+			allowList.add(input.spec.AllowList[i].DeepCopy(), input.source, dryRun)
+		}
+
+		if input.spec.DefaultAction == dnsv1beta1.DefaultActionDeny && !dryRun &&
+			effective.DefaultAction != dnsv1beta1.DefaultActionDeny {
+			effective.DefaultAction = dnsv1beta1.DefaultActionDeny
+			effective.Action = action
+		}
+		if effective.Action == nil {
+			effective.Action = action
+		}
+	}
+	if effective.Action == nil {
+		effective.Action = ResolveAction(&dnsv1beta1.DnsPolicySpec{})
+	}
+
+	effective.BlockList = blockList.rules
+	effective.AllowList = allowList.rules
+	if effective.DefaultAction == dnsv1beta1.DefaultActionDeny {
+		effective.AllowList = slices.DeleteFunc(effective.AllowList, func(rule EffectiveRule) bool {
+			return !allowedByDenySources(&rule.DomainRule, inputs, enforced)
+		})
+	}
+	return effective
+}
+
+// allowedByDenySources reports whether every enforced Deny-default input
+// has an AllowList rule covering rule, so allowing it opens no name that
+// one of them denies.
+func allowedByDenySources(rule *dnsv1beta1.DomainRule, inputs []mergeInput, enforced bool) bool {
+	for _, input := range inputs {
+		if input.spec.DefaultAction != dnsv1beta1.DefaultActionDeny || (enforced && input.spec.DryRun) {
+			continue
+		}
+		if !slices.ContainsFunc(input.spec.AllowList, func(allow dnsv1beta1.DomainRule) bool {
+			return ruleCovers(&allow, rule)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleCovers reports whether outer matches every query rule matches, for
+// at least as long. It is conservative: regular expressions only cover
+// themselves.
+func ruleCovers(outer, rule *dnsv1beta1.DomainRule) bool {
+	if outer.ExpiresAt != nil && (rule.ExpiresAt == nil || rule.ExpiresAt.After(outer.ExpiresAt.Time)) {
+		return false
+	}
+	if len(outer.QTypes) > 0 && (len(rule.QTypes) == 0 || slices.ContainsFunc(rule.QTypes, func(qtype string) bool {
+		return !slices.ContainsFunc(outer.QTypes, func(o string) bool { return strings.EqualFold(o, qtype) })
+	})) {
+		return false
+	}
+	if mergeKey(&dnsv1beta1.DomainRule{Pattern: outer.Pattern, MatchType: outer.MatchType}) ==
+		mergeKey(&dnsv1beta1.DomainRule{Pattern: rule.Pattern, MatchType: rule.MatchType}) {
+		return true
+	}
+
+	outerType, ruleType := outer.EffectiveMatchType(), rule.EffectiveMatchType()
+	if outerType == dnsv1beta1.MatchTypeRegex || ruleType == dnsv1beta1.MatchTypeRegex {
+		return false
+	}
+	outerPattern, pattern := CanonicalDomain(outer.Pattern), CanonicalDomain(rule.Pattern)
+	// Every name rule matches ends with zone, and Exact rules match only it
+	zone := pattern
+	if ruleType == dnsv1beta1.MatchTypeWildcard {
+		zone = strings.TrimPrefix(pattern[strings.LastIndex(pattern, "*")+1:], ".")
+		if zone == "" {
+			return false
+		}
+	}
+	// Wildcard names lie strictly below their zone
+	below := ruleType == dnsv1beta1.MatchTypeWildcard
+	switch outerType {
+	case dnsv1beta1.MatchTypeExact:
+		return false
+	case dnsv1beta1.MatchTypeSuffix:
+		return zone == outerPattern || strings.HasSuffix(zone, "."+outerPattern)
+	case dnsv1beta1.MatchTypeWildcard:
+		if ruleType == dnsv1beta1.MatchTypeExact {
+			return wildcardMatches(outerPattern, pattern)
+		}
+		parent, ok := strings.CutPrefix(outerPattern, "*.")
+		if !ok || strings.Contains(parent, "*") {
+			return false
+		}
+		return strings.HasSuffix(zone, "."+parent) || (below && zone == parent)
+	}
+	return false
+}
+
+// wildcardMatches reports whether name matches a Wildcard pattern, where
+// each '*' label stands for one or more labels.
+func wildcardMatches(pattern, name string) bool {
+	labels := strings.Split(pattern, ".")
+	for i, label := range labels {
+		if label == "*" {
+			labels[i] = ".+"
+		} else {
+			labels[i] = regexp.QuoteMeta(label)
+		}
+	}
+	return regexp.MustCompile("^" + strings.Join(labels, `\.`) + "$").MatchString(name)
+}
+
+// ruleMerger collects the union of rule lists, folding rules that match the
+// same names for the same query types into one EffectiveRule.
+type ruleMerger struct {
+	rules []EffectiveRule
+	index map[string]int
+	// block keeps a folded rule until its last copy expires. Otherwise the
+	// rule ends with its first copy, so that allowing never outlasts a source.
+	block bool
+}
+
+func newRuleMerger(block bool) *ruleMerger {
+	return &ruleMerger{rules: []EffectiveRule{}, index: map[string]int{}, block: block}
+}
+
+func (m *ruleMerger) add(rule *dnsv1beta1.DomainRule, source PolicySource, dryRun bool) {
+	key := mergeKey(rule)
+	if i, ok := m.index[key]; ok {
+		existing := &m.rules[i]
+		if !slices.Contains(existing.Sources, source) {
+			existing.Sources = append(existing.Sources, source)
+		}
+		// Deny wins: an enforced copy of the rule enforces it
+		existing.DryRun = existing.DryRun && dryRun
+		if m.block == expiresAfter(rule.ExpiresAt, existing.ExpiresAt) &&
+			!rule.ExpiresAt.Equal(existing.ExpiresAt) {
+			existing.ExpiresAt = rule.ExpiresAt
+		}
+		return
+	}
+	m.index[key] = len(m.rules)
+	m.rules = append(m.rules, EffectiveRule{
+		DomainRule: *rule,
+		Sources:    []PolicySource{source},
+		DryRun:     dryRun,
+	})
+}
+
+// expiresAfter reports whether expiry a is later than b, where nil means
+// never.
+func expiresAfter(a, b *metav1.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	return a.After(b.Time)
+}
+
+// mergeKey identifies the names and query types a rule matches. Rules with
+// the same key are duplicates regardless of their action or description.
+func mergeKey(rule *dnsv1beta1.DomainRule) string {
+	matchType := rule.EffectiveMatchType()
+	pattern := rule.Pattern
+	if matchType != dnsv1beta1.MatchTypeRegex {
+		pattern = CanonicalDomain(pattern)
+	}
+	qtypes := make([]string, 0, len(rule.QTypes))
+	for _, qtype := range rule.QTypes {
+		qtypes = append(qtypes, strings.ToUpper(qtype))
+	}
+	sort.Strings(qtypes)
+	qtypes = slices.Compact(qtypes)
+	return string(matchType) + "\x00" + pattern + "\x00" + strings.Join(qtypes, ",")
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("MergePolicies", func() {
+	var (
+		team     *dnsv1beta1.DnsPolicy
+		security *dnsv1beta1.ClusterDnsPolicy
+	)
+
+	BeforeEach(func() {
+		team = &dnsv1beta1.DnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+			Spec: dnsv1beta1.DnsPolicySpec{
+				AllowList: []dnsv1beta1.DomainRule{{Pattern: "api.example.com"}, {Pattern: "cdn.example.com"}},
+				BlockList: []dnsv1beta1.DomainRule{{Pattern: "tracking.ads.net"}},
+				Action:    &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionRefused},
+			},
+		}
+		security = &dnsv1beta1.ClusterDnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "baseline"},
+			Spec: dnsv1beta1.ClusterDnsPolicySpec{
+				BlockList: []dnsv1beta1.DomainRule{{Pattern: "Tracking.Ads.NET."}, {Pattern: "cdn.example.com"}},
+			},
+		}
+	})
+
+	sources := func(rule EffectiveRule) []string {
+		var result []string
+		for _, source := range rule.Sources {
+			result = append(result, source.String())
+		}
+		return result
+	}
+
+	It("should take the union of the rule lists and record their sources", func() {
+		effective := MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+
+		Expect(effective.Sources).To(Equal([]PolicySource{
+			{Kind: KindDnsPolicy, Namespace: "default", Name: "frontend"},
+			{Kind: KindClusterDnsPolicy, Name: "baseline"},
+		}))
+		Expect(effective.AllowList).To(HaveLen(2))
+		Expect(effective.BlockList).To(HaveLen(2))
+
+		Expect(effective.BlockList[0].Pattern).To(Equal("tracking.ads.net"))
+		Expect(sources(effective.BlockList[0])).To(Equal([]string{"DnsPolicy/default/frontend", "ClusterDnsPolicy/baseline"}))
+		Expect(effective.BlockList[1].Pattern).To(Equal("cdn.example.com"))
+		Expect(sources(effective.BlockList[1])).To(Equal([]string{"ClusterDnsPolicy/baseline"}))
+	})
+
+	It("should give duplicate rules the action of the most specific policy", func() {
+		effective := MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.BlockList[0].Action.Type).To(Equal(dnsv1beta1.BlockActionRefused))
+		Expect(effective.BlockList[1].Action.Type).To(Equal(dnsv1beta1.BlockActionNXDomain))
+	})
+
+	It("should deny by default if any enforced policy does", func() {
+		security.Spec.DefaultAction = dnsv1beta1.DefaultActionDeny
+		effective := MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.DefaultAction).To(Equal(dnsv1beta1.DefaultActionDeny))
+		Expect(effective.Action.Type).To(Equal(dnsv1beta1.BlockActionNXDomain))
+
+		security.Spec.DryRun = true
+		effective = MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.DefaultAction).To(Equal(dnsv1beta1.DefaultActionAllow))
+		Expect(effective.DryRun).To(BeFalse())
+	})
+
+	It("should keep blocking until the last copy expires and allowing until the first", func() {
+		past := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+		later := metav1.NewTime(time.Now().Add(2 * time.Hour).Truncate(time.Second))
+		team.Spec.Priority = 10
+		team.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "evil.com", ExpiresAt: &past}}
+		team.Spec.AllowList = []dnsv1beta1.DomainRule{{Pattern: "api.example.com"}}
+		security.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "evil.com"}}
+		security.Spec.AllowList = []dnsv1beta1.DomainRule{{Pattern: "api.example.com", ExpiresAt: &past}}
+		effective := MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.BlockList).To(HaveLen(1))
+		Expect(sources(effective.BlockList[0])).To(Equal([]string{"DnsPolicy/default/frontend", "ClusterDnsPolicy/baseline"}))
+		Expect(effective.BlockList[0].ExpiresAt).To(BeNil())
+		Expect(effective.AllowList).To(HaveLen(1))
+		Expect(effective.AllowList[0].ExpiresAt).To(Equal(&past))
+
+		security.Spec.BlockList[0].ExpiresAt = &later
+		effective = MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.BlockList[0].ExpiresAt).To(Equal(&later))
+	})
+
+	It("should only allow names that every Deny-default policy allows", func() {
+		team.Spec.AllowList = append(team.Spec.AllowList, dnsv1beta1.DomainRule{Pattern: "evil.com"})
+		security.Spec.DefaultAction = dnsv1beta1.DefaultActionDeny
+		security.Spec.AllowList = []dnsv1beta1.DomainRule{{Pattern: "example.com", MatchType: dnsv1beta1.MatchTypeSuffix}}
+		effective := MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.DefaultAction).To(Equal(dnsv1beta1.DefaultActionDeny))
+		var allowed []string
+		for _, rule := range effective.AllowList {
+			allowed = append(allowed, rule.Pattern)
+		}
+		Expect(allowed).To(Equal([]string{"api.example.com", "cdn.example.com", "example.com"}))
+
+		// A second Deny-default policy narrows the allowed names further
+		team.Spec.DefaultAction = dnsv1beta1.DefaultActionDeny
+		effective = MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		allowed = nil
+		for _, rule := range effective.AllowList {
+			allowed = append(allowed, rule.Pattern)
+		}
+		Expect(allowed).To(Equal([]string{"api.example.com", "cdn.example.com"}))
+	})
+
+	DescribeTable("ruleCovers",
+		func(outer, rule dnsv1beta1.DomainRule, covers bool) {
+			Expect(ruleCovers(&outer, &rule)).To(Equal(covers))
+		},
+		Entry("the same name", dnsv1beta1.DomainRule{Pattern: "Example.com."}, dnsv1beta1.DomainRule{Pattern: "example.com"}, true),
+		Entry("a name below a suffix", dnsv1beta1.DomainRule{Pattern: "example.com", MatchType: dnsv1beta1.MatchTypeSuffix},
+			dnsv1beta1.DomainRule{Pattern: "*.api.example.com"}, true),
+		Entry("a name above a wildcard", dnsv1beta1.DomainRule{Pattern: "*.example.com"},
+			dnsv1beta1.DomainRule{Pattern: "example.com"}, false),
+		Entry("a name matching a wildcard", dnsv1beta1.DomainRule{Pattern: "*.example.com"},
+			dnsv1beta1.DomainRule{Pattern: "a.b.example.com"}, true),
+		Entry("a suffix at a wildcard's zone", dnsv1beta1.DomainRule{Pattern: "*.example.com"},
+			dnsv1beta1.DomainRule{Pattern: "example.com", MatchType: dnsv1beta1.MatchTypeSuffix}, false),
+		Entry("more query types", dnsv1beta1.DomainRule{Pattern: "example.com", QTypes: []string{"A"}},
+			dnsv1beta1.DomainRule{Pattern: "example.com"}, false),
+		Entry("a different expression", dnsv1beta1.DomainRule{Pattern: "^a", MatchType: dnsv1beta1.MatchTypeRegex},
+			dnsv1beta1.DomainRule{Pattern: "a.com"}, false),
+	)
+
+	It("should only dry-run the rules no enforced policy contributes", func() {
+		security.Spec.DryRun = true
+		effective := MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.BlockList[0].DryRun).To(BeFalse())
+		Expect(effective.BlockList[1].DryRun).To(BeTrue())
+
+		team.Spec.DryRun = true
+		effective = MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.DryRun).To(BeTrue())
+		Expect(effective.BlockList[1].DryRun).To(BeFalse())
+	})
+
+	It("should merge higher-priority policies first", func() {
+		security.Spec.Priority = 100
+		security.Spec.BlockList = []dnsv1beta1.DomainRule{{
+			Pattern: "tracking.ads.net",
+			Action:  &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionSinkhole, SinkholeIPv4: "10.0.0.53"},
+		}}
+		effective := MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.Sources[0].Name).To(Equal("baseline"))
+		Expect(effective.BlockList[0].Action.Type).To(Equal(dnsv1beta1.BlockActionSinkhole))
+	})
+
+	It("should shadow the policies merged after an Override policy", func() {
+		security.Spec.Priority = 100
+		security.Spec.MergeMode = dnsv1beta1.MergeModeOverride
+		effective := MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.Sources).To(Equal([]PolicySource{{Kind: KindClusterDnsPolicy, Name: "baseline", Priority: 100}}))
+		Expect(effective.Shadowed).To(Equal([]PolicySource{{Kind: KindDnsPolicy, Namespace: "default", Name: "frontend"}}))
+		Expect(effective.AllowList).To(BeEmpty())
+
+		security.Spec.Priority = -1
+		effective = MergePolicies([]*dnsv1beta1.DnsPolicy{team}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.Sources).To(HaveLen(2))
+		Expect(effective.Shadowed).To(BeEmpty())
+	})
+
+	It("should not let a DnsPolicy shadow a ClusterDnsPolicy", func() {
+		team.Spec.Priority = 100
+		team.Spec.MergeMode = dnsv1beta1.MergeModeOverride
+		other := team.DeepCopy()
+		other.Name = "backend"
+		other.Spec.Priority = 0
+		effective := MergePolicies([]*dnsv1beta1.DnsPolicy{team, other}, []*dnsv1beta1.ClusterDnsPolicy{security})
+		Expect(effective.Sources).To(Equal([]PolicySource{
+			{Kind: KindDnsPolicy, Namespace: "default", Name: "frontend", Priority: 100},
+			{Kind: KindClusterDnsPolicy, Name: "baseline"},
+		}))
+		Expect(effective.Shadowed).To(Equal([]PolicySource{{Kind: KindDnsPolicy, Namespace: "default", Name: "backend"}}))
+		Expect(effective.BlockList).To(HaveLen(2))
+	})
+
+	Context("shadowingSource", func() {
+		It("should name the first Override policy merged before the target", func() {
+			low := dnsPolicyInput(team)
+			security.Spec.Priority = 5
+			security.Spec.MergeMode = dnsv1beta1.MergeModeOverride
+			Expect(shadowingSource(low, []mergeInput{clusterPolicyInput(security)})).
+				To(Equal(&PolicySource{Kind: KindClusterDnsPolicy, Name: "baseline", Priority: 5}))
+
+			team.Spec.Priority = 10
+			Expect(shadowingSource(dnsPolicyInput(team), []mergeInput{clusterPolicyInput(security)})).To(BeNil())
+		})
+
+		It("should ignore DnsPolicies for a ClusterDnsPolicy target", func() {
+			team.Spec.Priority = 5
+			team.Spec.MergeMode = dnsv1beta1.MergeModeOverride
+			Expect(shadowingSource(clusterPolicyInput(security), []mergeInput{dnsPolicyInput(team)})).To(BeNil())
+		})
+	})
+
+	It("should return an empty allow-all policy when nothing applies", func() {
+		effective := MergePolicies(nil, nil)
+		Expect(effective.Sources).To(BeEmpty())
+		Expect(effective.BlockList).NotTo(BeNil())
+		Expect(effective.DefaultAction).To(Equal(dnsv1beta1.DefaultActionAllow))
+		Expect(effective.Action.Type).To(Equal(dnsv1beta1.BlockActionNXDomain))
+	})
+})
@@ -0,0 +1,280 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+const (
+	// ciliumServiceAccountLabel is the label Cilium gives endpoints for the
+	// service account of their pod.
+	ciliumServiceAccountLabel = "io.cilium.k8s.policy.serviceaccount"
+	// calicoServiceAccountLabel is the label Calico gives workload endpoints
+	// for the service account of their pod.
+	calicoServiceAccountLabel = "projectcalico.org/serviceaccount"
+)
+
+// networkPolicyKinds maps each provider to the kind of policy generated for it.
+var networkPolicyKinds = map[dnsv1beta1.NetworkPolicyProvider]schema.GroupVersionKind{
+	dnsv1beta1.NetworkPolicyProviderCilium: {Group: "cilium.io", Version: "v2", Kind: "CiliumNetworkPolicy"},
+	dnsv1beta1.NetworkPolicyProviderCalico: {Group: "projectcalico.org", Version: "v3", Kind: "NetworkPolicy"},
+}
+
+// calicoEnterpriseKind is only served by Calico Enterprise. Open-source
+// Calico also serves projectcalico.org/v3 NetworkPolicies, but their
+// destinations have no domains, so Calico counts as installed only with
+// this kind.
+var calicoEnterpriseKind = schema.GroupVersionKind{Group: "projectcalico.org", Version: "v3", Kind: "GlobalThreatFeed"}
+
+// FQDNAllowList returns the names a policy lets its pods connect to: the
+// Exact names of the AllowList and "*." patterns for its Suffix and
+// leading-label Wildcard rules. Allowed names covered by a block rule are
+// left out since blocking wins. Rules that CNIs cannot match, such as
+// Regex rules, rules limited to query types other than A and AAAA, and
+// expired rules, are counted as skipped.
+func FQDNAllowList(spec *dnsv1beta1.DnsPolicySpec, now time.Time) ([]string, int) {
+	var blocked []string
+	for i := range spec.BlockList {
+		if names, ok := fqdnNames(&spec.BlockList[i], now); ok && len(spec.BlockList[i].QTypes) == 0 {
+			blocked = append(blocked, names...)
+		}
+	}
+
+	var allowed []string
+	skipped := 0
+	for i := range spec.AllowList {
+		rule := &spec.AllowList[i]
+		names, ok := fqdnNames(rule, now)
+		if !ok || (len(rule.QTypes) > 0 && !slices.ContainsFunc(rule.QTypes, func(qtype string) bool {
+			return strings.EqualFold(qtype, "A") || strings.EqualFold(qtype, "AAAA")
+		})) {
+			skipped++
+			continue
+		}
+		for _, name := range names {
+			if coveringOwner(name, blocked) != "" {
+				skipped++
+				continue
+			}
+			allowed = append(allowed, name)
+		}
+	}
+	sort.Strings(allowed)
+	return slices.Compact(allowed), skipped
+}
+
+// fqdnNames returns the names and "*." patterns a rule expands to, or false
+// if the rule expired or has no FQDN equivalent.
+func fqdnNames(rule *dnsv1beta1.DomainRule, now time.Time) ([]string, bool) {
+	if rule.ExpiresAt != nil && !now.Before(rule.ExpiresAt.Time) {
+		return nil, false
+	}
+	pattern := CanonicalDomain(rule.Pattern)
+	switch rule.EffectiveMatchType() {
+	case dnsv1beta1.MatchTypeExact:
+		return []string{pattern}, true
+	case dnsv1beta1.MatchTypeSuffix:
+		return []string{pattern, "*." + pattern}, true
+	case dnsv1beta1.MatchTypeWildcard:
+		if strings.HasPrefix(pattern, "*.") && !strings.Contains(pattern[2:], "*") {
+			return []string{pattern}, true
+		}
+	}
+	return nil, false
+}
+
+// ciliumPolicySpec is the part of a CiliumNetworkPolicy spec the controller writes.
+type ciliumPolicySpec struct {
+	EndpointSelector metav1.LabelSelector `json:"endpointSelector"`
+	Egress           []ciliumEgressRule   `json:"egress"`
+}
+
+type ciliumEgressRule struct {
+	ToEndpoints []metav1.LabelSelector `json:"toEndpoints,omitempty"`
+	ToEntities  []string               `json:"toEntities,omitempty"`
+	ToFQDNs     []ciliumFQDNSelector   `json:"toFQDNs,omitempty"`
+	ToPorts     []ciliumPortRule       `json:"toPorts,omitempty"`
+}
+
+type ciliumFQDNSelector struct {
+	MatchName    string `json:"matchName,omitempty"`
+	MatchPattern string `json:"matchPattern,omitempty"`
+}
+
+type ciliumPortRule struct {
+	Ports []ciliumPort   `json:"ports"`
+	Rules *ciliumL7Rules `json:"rules,omitempty"`
+}
+
+type ciliumPort struct {
+	Port     string `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+type ciliumL7Rules struct {
+	DNS []ciliumFQDNSelector `json:"dns"`
+}
+
+// calicoPolicySpec is the part of a Calico NetworkPolicy spec the controller writes.
+type calicoPolicySpec struct {
+	Selector string       `json:"selector"`
+	Types    []string     `json:"types"`
+	Egress   []calicoRule `json:"egress"`
+}
+
+type calicoRule struct {
+	Action      string           `json:"action"`
+	Protocol    string           `json:"protocol,omitempty"`
+	Destination calicoEntityRule `json:"destination"`
+}
+
+type calicoEntityRule struct {
+	Selector          string   `json:"selector,omitempty"`
+	NamespaceSelector string   `json:"namespaceSelector,omitempty"`
+	Ports             []int64  `json:"ports,omitempty"`
+	Domains           []string `json:"domains,omitempty"`
+}
+
+// NetworkPolicySpec returns the spec of the network policy generated for a
+// provider. It selects the pods of the policy, lets them query kube-dns and
+// reach other pods of the cluster, and allows egress to the allowed names.
+// Both CNIs deny the rest of the egress of selected pods.
+func NetworkPolicySpec(provider dnsv1beta1.NetworkPolicyProvider, spec *dnsv1beta1.DnsPolicySpec,
+	allowed []string) (map[string]any, error) {
+	var generated any
+	switch provider {
+	case dnsv1beta1.NetworkPolicyProviderCilium:
+		generated = ciliumSpec(spec, allowed)
+	case dnsv1beta1.NetworkPolicyProviderCalico:
+		generated = calicoSpec(spec, allowed)
+	default:
+		return nil, fmt.Errorf("unknown network policy provider %q", provider)
+	}
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(generated)
+}
+
+func ciliumSpec(spec *dnsv1beta1.DnsPolicySpec, allowed []string) *ciliumPolicySpec {
+	var selector metav1.LabelSelector
+	if spec.TargetSelector != nil {
+		spec.TargetSelector.DeepCopyInto(&selector)
+	}
+	if names := spec.Subject.ServiceAccountNames(); len(names) > 0 {
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key: ciliumServiceAccountLabel, Operator: metav1.LabelSelectorOpIn, Values: names,
+		})
+	}
+
+	// The DNS proxy must see the queries to learn the addresses of the names
+	kubeDNS := ciliumEgressRule{
+		ToEndpoints: []metav1.LabelSelector{{MatchLabels: map[string]string{
+			"k8s:io.kubernetes.pod.namespace": "kube-system",
+			"k8s:k8s-app":                     "kube-dns",
+		}}},
+		ToPorts: []ciliumPortRule{{
+			Ports: []ciliumPort{{Port: "53", Protocol: "ANY"}},
+			Rules: &ciliumL7Rules{DNS: []ciliumFQDNSelector{{MatchPattern: "*"}}},
+		}},
+	}
+	generated := &ciliumPolicySpec{
+		EndpointSelector: selector,
+		Egress:           []ciliumEgressRule{kubeDNS, {ToEntities: []string{"cluster"}}},
+	}
+	if len(allowed) > 0 {
+		fqdns := ciliumEgressRule{}
+		for _, name := range allowed {
+			if strings.HasPrefix(name, "*.") {
+				fqdns.ToFQDNs = append(fqdns.ToFQDNs, ciliumFQDNSelector{MatchPattern: name})
+			} else {
+				fqdns.ToFQDNs = append(fqdns.ToFQDNs, ciliumFQDNSelector{MatchName: name})
+			}
+		}
+		generated.Egress = append(generated.Egress, fqdns)
+	}
+	return generated
+}
+
+func calicoSpec(spec *dnsv1beta1.DnsPolicySpec, allowed []string) *calicoPolicySpec {
+	generated := &calicoPolicySpec{
+		Selector: calicoSelector(spec.TargetSelector, spec.Subject.ServiceAccountNames()),
+		Types:    []string{"Egress"},
+		Egress: []calicoRule{
+			{Action: "Allow", Protocol: "UDP", Destination: calicoEntityRule{Ports: []int64{53}}},
+			{Action: "Allow", Protocol: "TCP", Destination: calicoEntityRule{Ports: []int64{53}}},
+			{Action: "Allow", Destination: calicoEntityRule{Selector: "all()", NamespaceSelector: "all()"}},
+		},
+	}
+	if len(allowed) > 0 {
+		generated.Egress = append(generated.Egress, calicoRule{
+			Action: "Allow", Destination: calicoEntityRule{Domains: allowed},
+		})
+	}
+	return generated
+}
+
+// calicoSelector writes a label selector and service accounts in Calico's
+// selector syntax.
+func calicoSelector(selector *metav1.LabelSelector, serviceAccounts []string) string {
+	var terms []string
+	if selector != nil {
+		keys := make([]string, 0, len(selector.MatchLabels))
+		for key := range selector.MatchLabels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			terms = append(terms, fmt.Sprintf("%s == '%s'", key, selector.MatchLabels[key]))
+		}
+		for _, requirement := range selector.MatchExpressions {
+			switch requirement.Operator {
+			case metav1.LabelSelectorOpIn:
+				terms = append(terms, fmt.Sprintf("%s in %s", requirement.Key, calicoSet(requirement.Values)))
+			case metav1.LabelSelectorOpNotIn:
+				terms = append(terms, fmt.Sprintf("%s not in %s", requirement.Key, calicoSet(requirement.Values)))
+			case metav1.LabelSelectorOpExists:
+				terms = append(terms, fmt.Sprintf("has(%s)", requirement.Key))
+			case metav1.LabelSelectorOpDoesNotExist:
+				terms = append(terms, fmt.Sprintf("!has(%s)", requirement.Key))
+			}
+		}
+	}
+	if len(serviceAccounts) > 0 {
+		terms = append(terms, fmt.Sprintf("%s in %s", calicoServiceAccountLabel, calicoSet(serviceAccounts)))
+	}
+	if len(terms) == 0 {
+		return "all()"
+	}
+	return strings.Join(terms, " && ")
+}
+
+func calicoSet(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, "'"+value+"'")
+	}
+	return "{" + strings.Join(quoted, ", ") + "}"
+}
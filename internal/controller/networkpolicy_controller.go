@@ -0,0 +1,319 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+const (
+	// conditionNetworkPoliciesGenerated reports whether spec.networkPolicy was generated.
+	conditionNetworkPoliciesGenerated = "NetworkPoliciesGenerated"
+
+	// networkPolicyLabel marks generated network policies with the name of their DnsPolicy.
+	networkPolicyLabel = "dns.dnspolicies.io/network-policy"
+)
+
+// NetworkPolicyReconciler generates CNI network policies for the DnsPolicies
+// that set spec.networkPolicy, so that their pods can only connect to the
+// allowed names. The generated policies are owned by the DnsPolicy.
+type NetworkPolicyReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Index    *PolicyIndex
+	Recorder record.EventRecorder
+	// Installed holds the providers whose policy kinds the cluster serves.
+	// SetupWithManager detects them with installedProviders.
+	Installed map[dnsv1beta1.NetworkPolicyProvider]bool
+}
+
+// +kubebuilder:rbac:groups=cilium.io,resources=ciliumnetworkpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=projectcalico.org,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile writes a network policy for every provider a DnsPolicy selects
+// and deletes those of providers it no longer selects. The rules come
+// from the indexed policy, which includes the rules of referenced lists.
+func (r *NetworkPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	var policy dnsv1beta1.DnsPolicy
+	if err := r.Get(ctx, req.NamespacedName, &policy); err != nil {
+		// Generated policies are garbage-collected with their owner
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !policy.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	indexed := r.Index.GetByName(req.NamespacedName)
+	if indexed == nil {
+		// indexChanges requeues the policy once the DnsPolicy reconciler
+		// has indexed it
+		return ctrl.Result{}, nil
+	}
+
+	wanted := map[dnsv1beta1.NetworkPolicyProvider]bool{}
+	if policy.Spec.NetworkPolicy != nil && !policy.Spec.DryRun {
+		for _, provider := range policy.Spec.NetworkPolicy.Providers {
+			wanted[provider] = true
+		}
+	}
+	now := time.Now()
+	allowed, skipped := FQDNAllowList(&indexed.Spec, now)
+
+	var generated []dnsv1beta1.GeneratedObject
+	var problems []string
+	for _, provider := range []dnsv1beta1.NetworkPolicyProvider{
+		dnsv1beta1.NetworkPolicyProviderCilium, dnsv1beta1.NetworkPolicyProviderCalico,
+	} {
+		gvk := networkPolicyKinds[provider]
+		switch {
+		case !wanted[provider] && r.Installed[provider]:
+			if err := r.deleteNetworkPolicy(ctx, &policy, provider); err != nil {
+				log.Error(err, "Failed to delete network policy", "kind", gvk.Kind)
+				return ctrl.Result{}, err
+			}
+		case !wanted[provider]:
+		case !r.Installed[provider] && provider == dnsv1beta1.NetworkPolicyProviderCalico:
+			problems = append(problems, fmt.Sprintf("Calico Enterprise is not installed: the cluster does not serve both %s "+
+				"and %s, and open-source Calico policies cannot allow domains", gvk.GroupKind(), calicoEnterpriseKind.GroupKind()))
+		case !r.Installed[provider]:
+			problems = append(problems, fmt.Sprintf("%s is not installed: the cluster does not serve %s", provider, gvk.GroupKind()))
+		default:
+			conflict, err := r.writeNetworkPolicy(ctx, &policy, provider, &indexed.Spec, allowed)
+			if err != nil {
+				log.Error(err, "Failed to write network policy", "kind", gvk.Kind)
+				r.Recorder.Event(&policy, corev1.EventTypeWarning, "NetworkPolicyFailed",
+					fmt.Sprintf("Failed to write %s: %v", gvk.Kind, err))
+				return ctrl.Result{}, err
+			}
+			if conflict {
+				problems = append(problems, fmt.Sprintf("%s %s exists and is not managed by this policy",
+					gvk.Kind, policy.Name))
+				continue
+			}
+			generated = append(generated, dnsv1beta1.GeneratedObject{
+				APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind, Name: policy.Name,
+			})
+		}
+	}
+
+	var status metav1.ConditionStatus
+	var reason, message string
+	switch {
+	case policy.Spec.NetworkPolicy == nil:
+	case policy.Spec.DryRun:
+		status, reason = metav1.ConditionFalse, "DryRun"
+		message = "network policies are not generated for dry-run policies"
+	case len(problems) > 0:
+		status, reason = metav1.ConditionFalse, "NotGenerated"
+		message = strings.Join(problems, "; ")
+	default:
+		status, reason = metav1.ConditionTrue, "Generated"
+		message = fmt.Sprintf("allowing egress to %d names, %d rules skipped", len(allowed), skipped)
+	}
+	if err := r.updateStatus(ctx, &policy, generated, status, reason, message); err != nil {
+		log.Error(err, "Failed to update DnsPolicy status")
+		return ctrl.Result{}, err
+	}
+
+	// Expired rules are only dropped when the policy is generated again
+	var result ctrl.Result
+	if len(wanted) > 0 {
+		result.RequeueAfter = nextRuleExpiry(&indexed.Spec, now)
+	}
+	return result, nil
+}
+
+// writeNetworkPolicy creates or updates the network policy of a provider.
+// It reports a conflict instead when an object of the same name exists
+// that the policy does not control.
+func (r *NetworkPolicyReconciler) writeNetworkPolicy(ctx context.Context, policy *dnsv1beta1.DnsPolicy,
+	provider dnsv1beta1.NetworkPolicyProvider, spec *dnsv1beta1.DnsPolicySpec, allowed []string) (bool, error) {
+	desired, err := NetworkPolicySpec(provider, spec, allowed)
+	if err != nil {
+		return false, err
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(networkPolicyKinds[provider])
+	obj.SetNamespace(policy.Namespace)
+	obj.SetName(policy.Name)
+	err = r.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+	if client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	if err == nil && !metav1.IsControlledBy(obj, policy) {
+		return true, nil
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, obj, func() error {
+		obj.SetLabels(map[string]string{networkPolicyLabel: policy.Name})
+		obj.Object["spec"] = desired
+		return controllerutil.SetControllerReference(policy, obj, r.Scheme)
+	})
+	return false, err
+}
+
+// deleteNetworkPolicy deletes the network policy of a provider if the policy controls it.
+func (r *NetworkPolicyReconciler) deleteNetworkPolicy(ctx context.Context, policy *dnsv1beta1.DnsPolicy,
+	provider dnsv1beta1.NetworkPolicyProvider) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(networkPolicyKinds[provider])
+	if err := r.Get(ctx, client.ObjectKey{Namespace: policy.Namespace, Name: policy.Name}, obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, policy) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, obj))
+}
+
+// updateStatus records the generated network policies and the
+// NetworkPoliciesGenerated condition, which is removed when status is
+// empty. It retries on conflicts with the DnsPolicy reconciler.
+func (r *NetworkPolicyReconciler) updateStatus(ctx context.Context, policy *dnsv1beta1.DnsPolicy,
+	generated []dnsv1beta1.GeneratedObject, status metav1.ConditionStatus, reason, message string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current := meta.FindStatusCondition(policy.Status.Conditions, conditionNetworkPoliciesGenerated)
+		unchanged := equality.Semantic.DeepEqual(policy.Status.NetworkPolicies, generated)
+		switch {
+		case status == "" && current == nil && unchanged:
+			return nil
+		case status == "":
+			meta.RemoveStatusCondition(&policy.Status.Conditions, conditionNetworkPoliciesGenerated)
+		case unchanged && current != nil && current.Status == status && current.Reason == reason &&
+			current.Message == message && current.ObservedGeneration == policy.Generation:
+			return nil
+		default:
+			setCondition(&policy.Status.Conditions, policy.Generation, conditionNetworkPoliciesGenerated,
+				status, reason, message)
+			if status == metav1.ConditionFalse && (current == nil || current.Message != message) {
+				r.Recorder.Event(policy, corev1.EventTypeWarning, "NetworkPolicies"+reason, message)
+			}
+		}
+		policy.Status.NetworkPolicies = generated
+		err := r.Status().Update(ctx, policy)
+		if apierrors.IsConflict(err) {
+			if getErr := r.Get(ctx, client.ObjectKeyFromObject(policy), policy); getErr != nil {
+				return getErr
+			}
+		}
+		return err
+	})
+}
+
+// indexChanges sends an event for every indexed DnsPolicy that generates
+// network policies whenever the index changes. This covers policies that
+// were reconciled before they were indexed, and policies whose rules
+// changed with the content of a referenced domain list.
+func (r *NetworkPolicyReconciler) indexChanges(events chan<- event.GenericEvent) manager.RunnableFunc {
+	return func(ctx context.Context) error {
+		_, changed := r.Index.Changes()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-changed:
+			}
+			_, changed = r.Index.Changes()
+			for _, policy := range r.Index.GetAll() {
+				if policy.Spec.NetworkPolicy == nil {
+					continue
+				}
+				select {
+				case events <- event.GenericEvent{Object: policy}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager. Only the
+// policy kinds the cluster serves are watched.
+func (r *NetworkPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.Recorder = mgr.GetEventRecorderFor("networkpolicy-controller")
+
+	events := make(chan event.GenericEvent)
+	if err := mgr.Add(r.indexChanges(events)); err != nil {
+		return err
+	}
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(&dnsv1beta1.DnsPolicy{}).
+		WatchesRawSource(source.Channel(events, &handler.EnqueueRequestForObject{})).
+		Named("networkpolicy")
+	var err error
+	if r.Installed, err = installedProviders(mgr.GetRESTMapper()); err != nil {
+		return err
+	}
+	for provider := range r.Installed {
+		owned := &unstructured.Unstructured{}
+		owned.SetGroupVersionKind(networkPolicyKinds[provider])
+		builder = builder.Owns(owned)
+	}
+	return builder.Complete(r)
+}
+
+// installedProviders returns the providers whose policy kinds the cluster
+// serves. Calico also needs calicoEnterpriseKind.
+func installedProviders(mapper meta.RESTMapper) (map[dnsv1beta1.NetworkPolicyProvider]bool, error) {
+	installed := map[dnsv1beta1.NetworkPolicyProvider]bool{}
+	for provider, gvk := range networkPolicyKinds {
+		kinds := []schema.GroupVersionKind{gvk}
+		if provider == dnsv1beta1.NetworkPolicyProviderCalico {
+			kinds = append(kinds, calicoEnterpriseKind)
+		}
+		served := true
+		for _, kind := range kinds {
+			if _, err := mapper.RESTMapping(kind.GroupKind(), kind.Version); err != nil {
+				if !meta.IsNoMatchError(err) {
+					return nil, err
+				}
+				served = false
+			}
+		}
+		if served {
+			installed[provider] = true
+		}
+	}
+	return installed, nil
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("Network policy generation", func() {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	It("should allow the names of the allow list that are not blocked", func() {
+		expired := metav1.NewTime(now.Add(-time.Hour))
+		allowed, skipped := FQDNAllowList(&dnsv1beta1.DnsPolicySpec{
+			DefaultAction: dnsv1beta1.DefaultActionDeny,
+			BlockList:     []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}},
+			AllowList: []dnsv1beta1.DomainRule{
+				{Pattern: "Example.com", MatchType: dnsv1beta1.MatchTypeSuffix},
+				{Pattern: "*.github.com"},
+				{Pattern: "ads.example.com"},
+				{Pattern: `^api[0-9]+\.example\.net$`, MatchType: dnsv1beta1.MatchTypeRegex},
+				{Pattern: "txt.example.net", QTypes: []string{"TXT"}},
+				{Pattern: "v6.example.net", QTypes: []string{"aaaa"}},
+				{Pattern: "old.example.net", ExpiresAt: &expired},
+			},
+		}, now)
+		Expect(allowed).To(Equal([]string{"*.example.com", "*.github.com", "example.com", "v6.example.net"}))
+		Expect(skipped).To(Equal(4))
+	})
+
+	It("should select the pods of the policy in Calico syntax", func() {
+		Expect(calicoSelector(&metav1.LabelSelector{
+			MatchLabels: map[string]string{"tier": "web", "app": "shop"},
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "env", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod", "staging"}},
+				{Key: "canary", Operator: metav1.LabelSelectorOpDoesNotExist},
+			},
+		}, []string{"payments"})).To(Equal("app == 'shop' && tier == 'web' && env in {'prod', 'staging'} && " +
+			"!has(canary) && projectcalico.org/serviceaccount in {'payments'}"))
+		Expect(calicoSelector(nil, nil)).To(Equal("all()"))
+	})
+
+	It("should write the allowed names as toFQDNs rules", func() {
+		spec, err := NetworkPolicySpec(dnsv1beta1.NetworkPolicyProviderCilium, &dnsv1beta1.DnsPolicySpec{
+			TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "shop"}},
+			Subject:        &dnsv1beta1.Subject{ServiceAccount: "payments"},
+		}, []string{"*.example.com", "example.com"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec["endpointSelector"]).To(Equal(map[string]any{
+			"matchLabels": map[string]any{"app": "shop"},
+			"matchExpressions": []any{map[string]any{
+				"key": ciliumServiceAccountLabel, "operator": "In", "values": []any{"payments"},
+			}},
+		}))
+		egress := spec["egress"].([]any)
+		Expect(egress).To(HaveLen(3))
+		Expect(egress[2]).To(Equal(map[string]any{"toFQDNs": []any{
+			map[string]any{"matchPattern": "*.example.com"},
+			map[string]any{"matchName": "example.com"},
+		}}))
+	})
+
+	It("should only count Calico as installed with Calico Enterprise", func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(networkPolicyKinds[dnsv1beta1.NetworkPolicyProviderCilium], meta.RESTScopeNamespace)
+		mapper.Add(networkPolicyKinds[dnsv1beta1.NetworkPolicyProviderCalico], meta.RESTScopeNamespace)
+		installed, err := installedProviders(mapper)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(installed).To(Equal(map[dnsv1beta1.NetworkPolicyProvider]bool{dnsv1beta1.NetworkPolicyProviderCilium: true}))
+
+		mapper.Add(calicoEnterpriseKind, meta.RESTScopeRoot)
+		installed, err = installedProviders(mapper)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(installed).To(HaveKey(dnsv1beta1.NetworkPolicyProviderCalico))
+	})
+
+	Context("reconciled", func() {
+		var (
+			c      client.Client
+			r      *NetworkPolicyReconciler
+			policy *dnsv1beta1.DnsPolicy
+			key    = types.NamespacedName{Namespace: "shop", Name: "egress"}
+		)
+
+		BeforeEach(func() {
+			testScheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+			Expect(dnsv1beta1.AddToScheme(testScheme)).To(Succeed())
+			for _, gvk := range networkPolicyKinds {
+				testScheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+				testScheme.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+			}
+			policy = &dnsv1beta1.DnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, UID: "egress-uid"},
+				Spec: dnsv1beta1.DnsPolicySpec{
+					TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "shop"}},
+					DefaultAction:  dnsv1beta1.DefaultActionDeny,
+					AllowList:      []dnsv1beta1.DomainRule{{Pattern: "api.stripe.com"}},
+					NetworkPolicy: &dnsv1beta1.NetworkPolicyGeneration{Providers: []dnsv1beta1.NetworkPolicyProvider{
+						dnsv1beta1.NetworkPolicyProviderCilium, dnsv1beta1.NetworkPolicyProviderCalico,
+					}},
+				},
+			}
+			index := NewPolicyIndex()
+			hash, err := SelectorHashFor(&policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			index.Upsert(policy, hash)
+			c = fake.NewClientBuilder().WithScheme(testScheme).WithObjects(policy).
+				WithStatusSubresource(&dnsv1beta1.DnsPolicy{}).Build()
+			r = &NetworkPolicyReconciler{
+				Client:   c,
+				Scheme:   testScheme,
+				Index:    index,
+				Recorder: record.NewFakeRecorder(100),
+				Installed: map[dnsv1beta1.NetworkPolicyProvider]bool{
+					dnsv1beta1.NetworkPolicyProviderCilium: true,
+				},
+			}
+		})
+
+		generated := func(provider dnsv1beta1.NetworkPolicyProvider) (*unstructured.Unstructured, error) {
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(networkPolicyKinds[provider])
+			return obj, c.Get(ctx, key, obj)
+		}
+		reconcileStatus := func() dnsv1beta1.DnsPolicyStatus {
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			var current dnsv1beta1.DnsPolicy
+			ExpectWithOffset(1, c.Get(ctx, key, &current)).To(Succeed())
+			return current.Status
+		}
+
+		It("should generate owned policies and list them in the status", func() {
+			status := reconcileStatus()
+
+			cilium, err := generated(dnsv1beta1.NetworkPolicyProviderCilium)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metav1.IsControlledBy(cilium, policy)).To(BeTrue())
+			fqdns, _, _ := unstructured.NestedSlice(cilium.Object, "spec", "egress")
+			Expect(fqdns).To(ContainElement(map[string]any{"toFQDNs": []any{map[string]any{"matchName": "api.stripe.com"}}}))
+
+			Expect(status.NetworkPolicies).To(Equal([]dnsv1beta1.GeneratedObject{
+				{APIVersion: "cilium.io/v2", Kind: "CiliumNetworkPolicy", Name: "egress"},
+			}))
+			condition := meta.FindStatusCondition(status.Conditions, conditionNetworkPoliciesGenerated)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Message).To(ContainSubstring("Calico Enterprise is not installed"))
+		})
+
+		It("should delete the generated policies when the policy opts out", func() {
+			reconcileStatus()
+			Expect(c.Get(ctx, key, policy)).To(Succeed())
+			policy.Spec.NetworkPolicy = nil
+			Expect(c.Update(ctx, policy)).To(Succeed())
+
+			status := reconcileStatus()
+			_, err := generated(dnsv1beta1.NetworkPolicyProviderCilium)
+			Expect(err).To(MatchError(ContainSubstring("not found")))
+			Expect(status.NetworkPolicies).To(BeEmpty())
+			Expect(meta.FindStatusCondition(status.Conditions, conditionNetworkPoliciesGenerated)).To(BeNil())
+		})
+
+		It("should not take over a policy it does not own", func() {
+			existing := &unstructured.Unstructured{}
+			existing.SetGroupVersionKind(networkPolicyKinds[dnsv1beta1.NetworkPolicyProviderCilium])
+			existing.SetNamespace(key.Namespace)
+			existing.SetName(key.Name)
+			Expect(c.Create(ctx, existing)).To(Succeed())
+
+			status := reconcileStatus()
+			Expect(status.NetworkPolicies).To(BeEmpty())
+			condition := meta.FindStatusCondition(status.Conditions, conditionNetworkPoliciesGenerated)
+			Expect(condition.Message).To(ContainSubstring("CiliumNetworkPolicy egress exists and is not managed by this policy"))
+		})
+
+		It("should requeue policies that generate network policies when the index changes", func() {
+			events := make(chan event.GenericEvent, 10)
+			runCtx, stop := context.WithCancel(ctx)
+			DeferCleanup(stop)
+			go func() { _ = r.indexChanges(events)(runCtx) }()
+
+			other := &dnsv1beta1.DnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "shop"},
+				Spec:       dnsv1beta1.DnsPolicySpec{BlockList: []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}}},
+			}
+			// Change the index until the runnable has picked up a change
+			var requeued []types.NamespacedName
+			Eventually(func() []types.NamespacedName {
+				other.Spec.BlockList[0].Pattern += ".net"
+				r.Index.Upsert(other.DeepCopy(), "plain")
+				for len(events) > 0 {
+					requeued = append(requeued, client.ObjectKeyFromObject((<-events).Object))
+				}
+				return requeued
+			}).Should(ContainElement(key))
+			Expect(requeued).NotTo(ContainElement(client.ObjectKeyFromObject(other)))
+		})
+	})
+})
@@ -0,0 +1,109 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// NormalizeSpec rewrites the rule lists of a spec into canonical form so that
+// semantically identical policies are stored, and hashed, identically.
+// Domain patterns are lowercased, lose their trailing dot and have unicode
+// labels converted to punycode. Regex patterns are left untouched. Each list
+// is then sorted by pattern and exact duplicates are dropped.
+func NormalizeSpec(spec *dnsv1beta1.DnsPolicySpec) {
+	spec.BlockList = normalizeRuleList(spec.BlockList)
+	spec.AllowList = normalizeRuleList(spec.AllowList)
+}
+
+// NormalizeClusterSpec is NormalizeSpec for a ClusterDnsPolicySpec.
+func NormalizeClusterSpec(spec *dnsv1beta1.ClusterDnsPolicySpec) {
+	spec.BlockList = normalizeRuleList(spec.BlockList)
+	spec.AllowList = normalizeRuleList(spec.AllowList)
+}
+
+// NormalizeDomainListSpec is NormalizeSpec for the rules of a DomainList or
+// ClusterDomainList.
+func NormalizeDomainListSpec(spec *dnsv1beta1.DomainListSpec) {
+	spec.Rules = normalizeRuleList(spec.Rules)
+}
+
+func normalizeRuleList(rules []dnsv1beta1.DomainRule) []dnsv1beta1.DomainRule {
+	if len(rules) == 0 {
+		return rules
+	}
+
+	for i := range rules {
+		if rules[i].EffectiveMatchType() != dnsv1beta1.MatchTypeRegex {
+			rules[i].Pattern = CanonicalDomain(rules[i].Pattern)
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Pattern < rules[j].Pattern
+	})
+
+	normalized := rules[:0]
+	for i := range rules {
+		if !containsRule(normalized, &rules[i]) {
+			normalized = append(normalized, rules[i])
+		}
+	}
+	return normalized
+}
+
+// containsRule reports whether an identical rule is already in sorted, which
+// holds rules ordered by pattern.
+func containsRule(sorted []dnsv1beta1.DomainRule, rule *dnsv1beta1.DomainRule) bool {
+	for i := len(sorted) - 1; i >= 0 && sorted[i].Pattern == rule.Pattern; i-- {
+		if equality.Semantic.DeepEqual(sorted[i], *rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanonicalDomain lowercases a domain or wildcard pattern, strips its trailing
+// dot and converts unicode labels to punycode. Labels that are not valid
+// IDNs are kept as they are so validation can report them.
+func CanonicalDomain(pattern string) string {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), "."), ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		if ascii, err := idna.Lookup.ToASCII(label); err == nil {
+			labels[i] = ascii
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
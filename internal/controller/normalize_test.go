@@ -0,0 +1,78 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("Policy normalization", func() {
+	DescribeTable("CanonicalDomain",
+		func(pattern, expected string) {
+			Expect(CanonicalDomain(pattern)).To(Equal(expected))
+		},
+		Entry("lowercases and strips the trailing dot", "Example.COM.", "example.com"),
+		Entry("keeps wildcards", "*.Example.com", "*.example.com"),
+		Entry("converts unicode labels to punycode", "*.Bücher.example", "*.xn--bcher-kva.example"),
+		Entry("keeps service labels", "_sip._tcp.example.com", "_sip._tcp.example.com"),
+	)
+
+	Context("NormalizeSpec", func() {
+		It("should sort and deduplicate rules without touching regex patterns", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				BlockList: []dnsv1beta1.DomainRule{
+					{Pattern: "tracking.ads.net"},
+					{Pattern: "Example.COM."},
+					{Pattern: `^Ads\.`, MatchType: dnsv1beta1.MatchTypeRegex},
+					{Pattern: "example.com"},
+					{Pattern: "example.com", QTypes: []string{"AAAA"}},
+				},
+			}
+			NormalizeSpec(spec)
+			Expect(spec.BlockList).To(Equal([]dnsv1beta1.DomainRule{
+				{Pattern: `^Ads\.`, MatchType: dnsv1beta1.MatchTypeRegex},
+				{Pattern: "example.com"},
+				{Pattern: "example.com", QTypes: []string{"AAAA"}},
+				{Pattern: "tracking.ads.net"},
+			}))
+		})
+
+		It("should make equivalent specs hash the same", func() {
+			a := &dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				BlockList:      []dnsv1beta1.DomainRule{{Pattern: "Example.COM."}, {Pattern: "*.ads.net"}},
+			}
+			b := &dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				BlockList:      []dnsv1beta1.DomainRule{{Pattern: "*.ads.net"}, {Pattern: "example.com"}, {Pattern: "example.com"}},
+			}
+			NormalizeSpec(a)
+			NormalizeSpec(b)
+
+			hashA, err := ComputeSpecHash(a)
+			Expect(err).NotTo(HaveOccurred())
+			hashB, err := ComputeSpecHash(b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hashA).To(Equal(hashB))
+		})
+	})
+})
@@ -0,0 +1,148 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	_ "embed"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnspolicyv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// PolicyDocumentSchemaVersion is the version of the wire schema of
+// PolicyDocument, EffectivePolicy and WatchResponse. It only changes when a
+// field changes incompatibly.
+const PolicyDocumentSchemaVersion = "v1"
+
+// PolicySchemaPath is where the API server publishes policySchema.
+const PolicySchemaPath = "/api/v1/schema"
+
+// policySchema is the JSON Schema of the documents served to sidecars.
+//
+//go:embed policy_schema.json
+var policySchema []byte
+
+// PolicyDocument is a single policy as served to sidecars. Unlike the CRD
+// object it carries no object metadata or conditions, only what a sidecar
+// needs to enforce the policy: referenced domain lists are inlined and
+// match types and actions are resolved.
+type PolicyDocument struct {
+	// SchemaVersion is PolicyDocumentSchemaVersion.
+	SchemaVersion string `json:"schemaVersion"`
+	// Source identifies the policy and its priority.
+	Source PolicySource `json:"source"`
+	// Generation is the metadata.generation of the policy.
+	Generation int64 `json:"generation"`
+	// SelectorHash is the status.selectorHash of a DnsPolicy.
+	SelectorHash string `json:"selectorHash,omitempty"`
+	// SpecHash changes whenever the compiled rules change.
+	SpecHash      string                         `json:"specHash"`
+	DryRun        bool                           `json:"dryrun"`
+	MergeMode     dnspolicyv1beta1.MergeMode     `json:"mergeMode"`
+	DefaultAction dnspolicyv1beta1.DefaultAction `json:"defaultAction"`
+	Action        *dnspolicyv1beta1.BlockAction  `json:"action"`
+	BlockList     []CompiledRule                 `json:"blockList"`
+	AllowList     []CompiledRule                 `json:"allowList"`
+}
+
+// CompiledRule is a rule of a PolicyDocument.
+type CompiledRule struct {
+	Pattern   string                     `json:"pattern"`
+	MatchType dnspolicyv1beta1.MatchType `json:"matchType"`
+	QTypes    []string                   `json:"qtypes,omitempty"`
+	// Action is set on BlockList rules, falling back to the policy action.
+	Action    *dnspolicyv1beta1.BlockAction `json:"action,omitempty"`
+	ExpiresAt *metav1.Time                  `json:"expiresAt,omitempty"`
+}
+
+// NewPolicyDocument returns the document of an indexed DnsPolicy.
+func NewPolicyDocument(policy *dnspolicyv1beta1.DnsPolicy) *PolicyDocument {
+	doc := newPolicyDocument(dnsPolicyInput(policy), policy.Generation, policy.Status.SpecHash)
+	doc.SelectorHash = policy.Status.SelectorHash
+	return doc
+}
+
+// NewClusterPolicyDocument returns the document of an indexed ClusterDnsPolicy.
+func NewClusterPolicyDocument(policy *dnspolicyv1beta1.ClusterDnsPolicy) *PolicyDocument {
+	return newPolicyDocument(clusterPolicyInput(policy), policy.Generation, policy.Status.SpecHash)
+}
+
+func newPolicyDocument(input mergeInput, generation int64, specHash string) *PolicyDocument {
+	spec := input.spec
+	action := ResolveAction(spec)
+	doc := &PolicyDocument{
+		SchemaVersion: PolicyDocumentSchemaVersion,
+		Source:        input.source,
+		Generation:    generation,
+		SpecHash:      specHash,
+		DryRun:        spec.DryRun,
+		MergeMode:     spec.MergeMode,
+		DefaultAction: spec.DefaultAction,
+		Action:        action,
+		BlockList:     compileRules(spec.BlockList, action),
+		AllowList:     compileRules(spec.AllowList, nil),
+	}
+	if doc.MergeMode == "" {
+		doc.MergeMode = dnspolicyv1beta1.MergeModeAppend
+	}
+	if doc.DefaultAction == "" {
+		doc.DefaultAction = dnspolicyv1beta1.DefaultActionAllow
+	}
+	return doc
+}
+
+// compileRules resolves the match type and, given the policy action, the
+// action of each rule.
+func compileRules(rules []dnspolicyv1beta1.DomainRule, action *dnspolicyv1beta1.BlockAction) []CompiledRule {
+	compiled := make([]CompiledRule, 0, len(rules))
+	for i := range rules {
+		rule := rules[i].DeepCopy()
+		switch {
+		case action != nil && rule.Action == nil:
+			rule.Action = action.DeepCopy()
+		case rule.Action != nil && rule.Action.Type == "":
+			rule.Action.Type = dnspolicyv1beta1.BlockActionNXDomain
+		}
+		compiled = append(compiled, CompiledRule{
+			Pattern:   rule.Pattern,
+			MatchType: rule.EffectiveMatchType(),
+			QTypes:    rule.QTypes,
+			Action:    rule.Action,
+			ExpiresAt: rule.ExpiresAt,
+		})
+	}
+	return compiled
+}
+
+// policyDocuments returns the documents of indexed DnsPolicies.
+func policyDocuments(policies []*dnspolicyv1beta1.DnsPolicy) []*PolicyDocument {
+	docs := make([]*PolicyDocument, 0, len(policies))
+	for _, policy := range policies {
+		docs = append(docs, NewPolicyDocument(policy))
+	}
+	return docs
+}
+
+// clusterPolicyDocuments returns the documents of indexed ClusterDnsPolicies.
+func clusterPolicyDocuments(policies []*dnspolicyv1beta1.ClusterDnsPolicy) []*PolicyDocument {
+	docs := make([]*PolicyDocument, 0, len(policies))
+	for _, policy := range policies {
+		docs = append(docs, NewClusterPolicyDocument(policy))
+	}
+	return docs
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// conformsTo checks the property names and required properties of value
+// against a schema of policySchema, following $ref into $defs.
+func conformsTo(defs map[string]any, schema map[string]any, value any, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		schema = defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		ExpectWithOffset(1, properties).NotTo(BeNil(), path)
+		for _, required := range schema["required"].([]any) {
+			ExpectWithOffset(1, value).To(HaveKey(required), path)
+		}
+		for key, field := range value {
+			ExpectWithOffset(1, properties).To(HaveKey(key), path)
+			conformsTo(defs, properties[key].(map[string]any), field, path+"."+key)
+		}
+	case []any:
+		for _, item := range value {
+			conformsTo(defs, schema["items"].(map[string]any), item, path+"[]")
+		}
+	}
+}
+
+var _ = Describe("PolicyDocument", func() {
+	expires := metav1.NewTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	policy := &dnsv1beta1.DnsPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "frontend", Namespace: "default", Generation: 3,
+			Annotations: map[string]string{"note": "left out"},
+			Finalizers:  []string{"dns.dnspolicies.io/finalizer"},
+		},
+		Spec: dnsv1beta1.DnsPolicySpec{
+			TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+			Priority:       10,
+			Action:         &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionRefused},
+			BlockList: []dnsv1beta1.DomainRule{
+				{Pattern: "*.ads.example.com", Description: "left out", ExpiresAt: &expires},
+				{Pattern: "tracker.example.com", QTypes: []string{"A"},
+					Action: &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionSinkhole, SinkholeIPv4: "0.0.0.0"}},
+			},
+			AllowList: []dnsv1beta1.DomainRule{{Pattern: "example.com", MatchType: dnsv1beta1.MatchTypeSuffix}},
+		},
+		Status: dnsv1beta1.DnsPolicyStatus{
+			SelectorHash: "selector",
+			SpecHash:     "spec",
+			Conditions:   []metav1.Condition{{Type: "Ready", Status: metav1.ConditionTrue}},
+		},
+	}
+
+	It("should carry the compiled rules without CRD internals", func() {
+		doc := NewPolicyDocument(policy)
+		Expect(doc.SchemaVersion).To(Equal(PolicyDocumentSchemaVersion))
+		Expect(doc.Source).To(Equal(PolicySource{Kind: KindDnsPolicy, Namespace: "default", Name: "frontend", Priority: 10}))
+		Expect(doc.Generation).To(Equal(int64(3)))
+		Expect(doc.SelectorHash).To(Equal("selector"))
+		Expect(doc.SpecHash).To(Equal("spec"))
+		Expect(doc.MergeMode).To(Equal(dnsv1beta1.MergeModeAppend))
+		Expect(doc.DefaultAction).To(Equal(dnsv1beta1.DefaultActionAllow))
+		Expect(doc.Action).To(Equal(&dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionRefused}))
+		Expect(doc.BlockList).To(Equal([]CompiledRule{
+			{Pattern: "*.ads.example.com", MatchType: dnsv1beta1.MatchTypeWildcard,
+				Action: &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionRefused}, ExpiresAt: &expires},
+			{Pattern: "tracker.example.com", MatchType: dnsv1beta1.MatchTypeExact, QTypes: []string{"A"},
+				Action: &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionSinkhole, SinkholeIPv4: "0.0.0.0"}},
+		}))
+		Expect(doc.AllowList).To(Equal([]CompiledRule{{Pattern: "example.com", MatchType: dnsv1beta1.MatchTypeSuffix}}))
+
+		data, err := json.Marshal(doc)
+		Expect(err).NotTo(HaveOccurred())
+		for _, internal := range []string{"annotations", "finalizers", "conditions", "description", "metadata"} {
+			Expect(string(data)).NotTo(ContainSubstring(internal))
+		}
+	})
+
+	It("should leave the selector hash out of cluster policies", func() {
+		doc := NewClusterPolicyDocument(&dnsv1beta1.ClusterDnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "baseline", Generation: 1},
+			Spec:       dnsv1beta1.ClusterDnsPolicySpec{DefaultAction: dnsv1beta1.DefaultActionDeny},
+		})
+		Expect(doc.Source).To(Equal(PolicySource{Kind: KindClusterDnsPolicy, Name: "baseline"}))
+		Expect(doc.SelectorHash).To(BeEmpty())
+		Expect(doc.DefaultAction).To(Equal(dnsv1beta1.DefaultActionDeny))
+		Expect(doc.BlockList).To(BeEmpty())
+		Expect(doc.BlockList).NotTo(BeNil())
+	})
+
+	It("should publish a JSON Schema that the documents conform to", func() {
+		server := NewAPIServer(NewPolicyIndex(), ":0")
+		hash, err := SelectorHashFor(&policy.Spec)
+		Expect(err).NotTo(HaveOccurred())
+		server.Index.Upsert(policy, hash)
+		Expect(server.Index.UpsertCluster(&dnsv1beta1.ClusterDnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "baseline"},
+		}, []string{"default"})).To(Succeed())
+
+		get := func(url string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+			ExpectWithOffset(1, rec.Code).To(Equal(http.StatusOK))
+			return rec
+		}
+		rec := get(PolicySchemaPath)
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/schema+json"))
+		var schema map[string]any
+		Expect(json.Unmarshal(rec.Body.Bytes(), &schema)).To(Succeed())
+		defs := schema["$defs"].(map[string]any)
+		Expect(defs["PolicyDocument"].(map[string]any)["properties"]).To(
+			HaveKeyWithValue("schemaVersion", HaveKeyWithValue("const", PolicyDocumentSchemaVersion)))
+
+		var doc any
+		Expect(json.Unmarshal(get("/api/policies?namespace=default&hash="+hash).Body.Bytes(), &doc)).To(Succeed())
+		conformsTo(defs, schema, doc, "document")
+
+		var resp any
+		Expect(json.Unmarshal(get("/api/v1/resolve?namespace=default&labels=app=frontend").Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp).To(HaveKeyWithValue("clusterPolicies", HaveLen(1)))
+		conformsTo(defs, defs["ResolveResponse"].(map[string]any), resp, "resolve")
+
+		var effective any
+		Expect(json.Unmarshal(get("/api/v1/effective?namespace=default&labels=app=frontend").Body.Bytes(), &effective)).To(Succeed())
+		Expect(effective).To(HaveKeyWithValue("schemaVersion", PolicyDocumentSchemaVersion))
+		conformsTo(defs, defs["EffectivePolicy"].(map[string]any), effective, "effective")
+
+		var watch any
+		Expect(json.Unmarshal(get("/api/v1/watch?namespace=default&labels=app=frontend").Body.Bytes(), &watch)).To(Succeed())
+		Expect(watch).To(HaveKeyWithValue("schemaVersion", PolicyDocumentSchemaVersion))
+		conformsTo(defs, defs["WatchResponse"].(map[string]any), watch, "watch")
+	})
+})
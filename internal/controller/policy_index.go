@@ -17,37 +17,120 @@ limitations under the License.
 package controller
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strconv"
 	"sync"
 
-	dnspolicyv1alpha1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1alpha1"
+	dnspolicyv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
-// PolicyIndex maintains an in-memory index of DNS policies by their selector hash.
+// policyKey groups the indexed policies of a namespace that select the same
+// pods. Several policies may share a key; they are merged for the sidecar.
+type policyKey struct {
+	Namespace    string
+	SelectorHash string
+}
+
+// PolicyIndex maintains an in-memory index of DNS policies by namespace and selector hash.
 // This allows efficient O(1) lookups for clients querying by hash.
 type PolicyIndex struct {
 	mu sync.RWMutex
 
-	// hashToPolicy maps selector hash to the policy
-	// Single policy per hash as per requirements
-	hashToPolicy map[string]*dnspolicyv1alpha1.DnsPolicy
+	// keyToPolicies maps namespace and selector hash to the policies
+	// selecting those pods, by policy name
+	keyToPolicies map[policyKey]map[string]*dnspolicyv1beta1.DnsPolicy
 
 	// nameToHash maps policy namespaced name to its selector hash
 	// Used for reverse lookups during updates/deletes
 	nameToHash map[types.NamespacedName]string
+
+	// nameToDocument maps policy namespaced name to its encoded
+	// PolicyDocument, so that polling sidecars do not re-encode it
+	nameToDocument map[types.NamespacedName]encodedDocument
+
+	// keyToSelector maps namespace and selector hash to the parsed TargetSelector
+	// Used to resolve a pod's labels against every policy
+	keyToSelector map[policyKey]labels.Selector
+
+	// clusterPolicies maps ClusterDnsPolicy name to the policy and the
+	// namespaces its NamespaceSelector matched when it was reconciled
+	clusterPolicies map[string]*clusterEntry
+
+	// revision counts the changes to indexed policies
+	revision uint64
+	// changed is closed and replaced on every change to wake up watchers
+	changed chan struct{}
+}
+
+// clusterEntry is an indexed ClusterDnsPolicy.
+type clusterEntry struct {
+	policy     *dnspolicyv1beta1.ClusterDnsPolicy
+	namespaces sets.Set[string]
+	selector   labels.Selector
+}
+
+// encodedDocument is the JSON encoding of a PolicyDocument and its ETag.
+type encodedDocument struct {
+	data []byte
+	etag string
+}
+
+// encodeDocument encodes doc and derives its ETag from a hash of the
+// encoding, which covers every field of the document.
+func encodeDocument(doc *PolicyDocument) (encodedDocument, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return encodedDocument{}, err
+	}
+	sum := sha256.Sum256(data)
+	return encodedDocument{data: data, etag: strconv.Quote(hex.EncodeToString(sum[:16]))}, nil
 }
 
 // NewPolicyIndex creates a new empty policy index.
 func NewPolicyIndex() *PolicyIndex {
 	return &PolicyIndex{
-		hashToPolicy: make(map[string]*dnspolicyv1alpha1.DnsPolicy),
-		nameToHash:   make(map[types.NamespacedName]string),
+		keyToPolicies:   make(map[policyKey]map[string]*dnspolicyv1beta1.DnsPolicy),
+		nameToHash:      make(map[types.NamespacedName]string),
+		nameToDocument:  make(map[types.NamespacedName]encodedDocument),
+		keyToSelector:   make(map[policyKey]labels.Selector),
+		clusterPolicies: make(map[string]*clusterEntry),
+		changed:         make(chan struct{}),
 	}
 }
 
+// Changes returns the current revision of the index and a channel that is
+// closed when the index changes next. Re-indexing a policy whose spec and
+// selector hash are unchanged is not a change.
+func (pi *PolicyIndex) Changes() (uint64, <-chan struct{}) {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return pi.revision, pi.changed
+}
+
+// notifyLocked wakes up the watchers of the index. The caller must hold
+// the write lock.
+func (pi *PolicyIndex) notifyLocked() {
+	pi.revision++
+	close(pi.changed)
+	pi.changed = make(chan struct{})
+}
+
 // Upsert adds or updates a policy in the index.
 // If the selector hash changed, it removes the old entry and adds the new one.
-func (pi *PolicyIndex) Upsert(policy *dnspolicyv1alpha1.DnsPolicy, selectorHash string) {
+func (pi *PolicyIndex) Upsert(policy *dnspolicyv1beta1.DnsPolicy, selectorHash string) {
+	// Encode outside the lock; a policy that cannot be encoded is served
+	// as an error by Document
+	document, _ := encodeDocument(NewPolicyDocument(policy))
+
 	pi.mu.Lock()
 	defer pi.mu.Unlock()
 
@@ -55,16 +138,35 @@ func (pi *PolicyIndex) Upsert(policy *dnspolicyv1alpha1.DnsPolicy, selectorHash
 		Namespace: policy.Namespace,
 		Name:      policy.Name,
 	}
+	key := policyKey{Namespace: policy.Namespace, SelectorHash: selectorHash}
+
+	oldHash, exists := pi.nameToHash[namespacedName]
+	if !exists || oldHash != selectorHash ||
+		!equality.Semantic.DeepEqual(pi.keyToPolicies[key][policy.Name].Spec, policy.Spec) {
+		defer pi.notifyLocked()
+	}
 
 	// Check if this policy was previously indexed with a different hash
-	if oldHash, exists := pi.nameToHash[namespacedName]; exists && oldHash != selectorHash {
+	if exists && oldHash != selectorHash {
 		// Remove old hash entry
-		delete(pi.hashToPolicy, oldHash)
+		pi.removeLocked(policyKey{Namespace: policy.Namespace, SelectorHash: oldHash}, policy.Name)
 	}
 
 	// Add/update the policy
-	pi.hashToPolicy[selectorHash] = policy.DeepCopy()
+	if pi.keyToPolicies[key] == nil {
+		pi.keyToPolicies[key] = make(map[string]*dnspolicyv1beta1.DnsPolicy)
+	}
+	pi.keyToPolicies[key][policy.Name] = policy.DeepCopy()
 	pi.nameToHash[namespacedName] = selectorHash
+	pi.nameToDocument[namespacedName] = document
+
+	// Subject-only policies have no selector to match labels against
+	delete(pi.keyToSelector, key)
+	if ts := policy.Spec.TargetSelector; ts != nil && (len(ts.MatchLabels) > 0 || len(ts.MatchExpressions) > 0) {
+		if selector, err := metav1.LabelSelectorAsSelector(ts); err == nil {
+			pi.keyToSelector[key] = selector
+		}
+	}
 }
 
 // Delete removes a policy from the index.
@@ -74,39 +176,257 @@ func (pi *PolicyIndex) Delete(namespacedName types.NamespacedName) {
 
 	// Find the hash for this policy
 	if hash, exists := pi.nameToHash[namespacedName]; exists {
-		// Remove from both maps
-		delete(pi.hashToPolicy, hash)
+		// Remove from all maps
+		pi.removeLocked(policyKey{Namespace: namespacedName.Namespace, SelectorHash: hash}, namespacedName.Name)
 		delete(pi.nameToHash, namespacedName)
+		delete(pi.nameToDocument, namespacedName)
+		pi.notifyLocked()
+	}
+}
+
+// removeLocked drops a policy from its key, and the key itself once no
+// policy is left. The caller must hold the write lock.
+func (pi *PolicyIndex) removeLocked(key policyKey, name string) {
+	delete(pi.keyToPolicies[key], name)
+	if len(pi.keyToPolicies[key]) == 0 {
+		delete(pi.keyToPolicies, key)
+		delete(pi.keyToSelector, key)
 	}
 }
 
-// Get retrieves a policy by its selector hash.
-// Returns nil if no policy matches the hash.
-func (pi *PolicyIndex) Get(selectorHash string) *dnspolicyv1alpha1.DnsPolicy {
+// Get retrieves the policies of a namespace with the given selector hash,
+// ordered by name. Returns nil if no policy matches the hash.
+func (pi *PolicyIndex) Get(namespace, selectorHash string) []*dnspolicyv1beta1.DnsPolicy {
 	pi.mu.RLock()
 	defer pi.mu.RUnlock()
 
-	if policy, exists := pi.hashToPolicy[selectorHash]; exists {
-		return policy.DeepCopy()
+	var policies []*dnspolicyv1beta1.DnsPolicy
+	for _, policy := range pi.keyToPolicies[policyKey{Namespace: namespace, SelectorHash: selectorHash}] {
+		policies = append(policies, policy.DeepCopy())
 	}
-	return nil
+	sortPolicies(policies)
+	return policies
+}
+
+// GetByHash returns the policies of every namespace with the given selector
+// hash, ordered by namespace and name. It serves clients that do not send a
+// namespace.
+func (pi *PolicyIndex) GetByHash(selectorHash string) []*dnspolicyv1beta1.DnsPolicy {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+
+	var policies []*dnspolicyv1beta1.DnsPolicy
+	for key, group := range pi.keyToPolicies {
+		if key.SelectorHash != selectorHash {
+			continue
+		}
+		for _, policy := range group {
+			policies = append(policies, policy.DeepCopy())
+		}
+	}
+	sortPolicies(policies)
+	return policies
+}
+
+// Match returns every policy whose TargetSelector matches the given pod
+// labels, ordered by namespace and name. Policies that also have a Subject
+// are left out because the pod's identity is unknown; Resolve checks both.
+func (pi *PolicyIndex) Match(podLabels labels.Labels) []*dnspolicyv1beta1.DnsPolicy {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+
+	var policies []*dnspolicyv1beta1.DnsPolicy
+	for key, selector := range pi.keyToSelector {
+		if !selector.Matches(podLabels) {
+			continue
+		}
+		for _, policy := range pi.keyToPolicies[key] {
+			if policy.Spec.Subject.IsEmpty() {
+				policies = append(policies, policy.DeepCopy())
+			}
+		}
+	}
+	sortPolicies(policies)
+	return policies
+}
+
+// Resolve returns every policy in the pod's namespace that applies to it,
+// ordered by name. A policy applies when its TargetSelector, if set, matches
+// the pod labels and every entry of its Subject, if set, matches the pod's
+// service account or namespace.
+func (pi *PolicyIndex) Resolve(namespace, serviceAccount string, podLabels labels.Labels) []*dnspolicyv1beta1.DnsPolicy {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+
+	var policies []*dnspolicyv1beta1.DnsPolicy
+	for key, group := range pi.keyToPolicies {
+		if key.Namespace != namespace {
+			continue
+		}
+		selector, hasSelector := pi.keyToSelector[key]
+		if hasSelector && !selector.Matches(podLabels) {
+			continue
+		}
+		for _, policy := range group {
+			if (!hasSelector || !policy.Spec.Subject.IsEmpty()) &&
+				!subjectMatches(policy.Spec.Subject, namespace, serviceAccount) {
+				continue
+			}
+			policies = append(policies, policy.DeepCopy())
+		}
+	}
+	sortPolicies(policies)
+	return policies
+}
+
+// sortPolicies orders policies by namespace and name.
+func sortPolicies(policies []*dnspolicyv1beta1.DnsPolicy) {
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Namespace != policies[j].Namespace {
+			return policies[i].Namespace < policies[j].Namespace
+		}
+		return policies[i].Name < policies[j].Name
+	})
+}
+
+// subjectMatches reports whether a pod matches the namespace and service
+// accounts of a subject. An empty subject never matches. NamespaceSelector
+// is not checked here: the ClusterDnsPolicy reconciler resolves it to the
+// indexed namespaces and DnsPolicies may not use it.
+func subjectMatches(subject *dnspolicyv1beta1.Subject, namespace, serviceAccount string) bool {
+	if subject.IsEmpty() {
+		return false
+	}
+	if subject.Namespace != "" && subject.Namespace != namespace {
+		return false
+	}
+	if names := subject.ServiceAccountNames(); len(names) > 0 && !slices.Contains(names, serviceAccount) {
+		return false
+	}
+	return true
+}
+
+// GetByName returns the indexed policy with the given name, or nil.
+func (pi *PolicyIndex) GetByName(namespacedName types.NamespacedName) *dnspolicyv1beta1.DnsPolicy {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+
+	hash, exists := pi.nameToHash[namespacedName]
+	if !exists {
+		return nil
+	}
+	key := policyKey{Namespace: namespacedName.Namespace, SelectorHash: hash}
+	return pi.keyToPolicies[key][namespacedName.Name].DeepCopy()
+}
+
+// Document returns the encoded PolicyDocument of an indexed policy and its
+// ETag. data is nil if the policy is not indexed or could not be encoded.
+func (pi *PolicyIndex) Document(namespacedName types.NamespacedName) (data []byte, etag string) {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+
+	document := pi.nameToDocument[namespacedName]
+	return document.data, document.etag
 }
 
 // GetAll returns all indexed policies.
-func (pi *PolicyIndex) GetAll() []*dnspolicyv1alpha1.DnsPolicy {
+func (pi *PolicyIndex) GetAll() []*dnspolicyv1beta1.DnsPolicy {
 	pi.mu.RLock()
 	defer pi.mu.RUnlock()
 
-	policies := make([]*dnspolicyv1alpha1.DnsPolicy, 0, len(pi.hashToPolicy))
-	for _, policy := range pi.hashToPolicy {
-		policies = append(policies, policy.DeepCopy())
+	policies := make([]*dnspolicyv1beta1.DnsPolicy, 0, len(pi.nameToHash))
+	for _, group := range pi.keyToPolicies {
+		for _, policy := range group {
+			policies = append(policies, policy.DeepCopy())
+		}
+	}
+	return policies
+}
+
+// UpsertCluster adds or updates a ClusterDnsPolicy together with the
+// namespaces its NamespaceSelector currently matches.
+func (pi *PolicyIndex) UpsertCluster(policy *dnspolicyv1beta1.ClusterDnsPolicy, namespaces []string) error {
+	// An unset TargetSelector selects every pod
+	selector := labels.Everything()
+	if policy.Spec.TargetSelector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(policy.Spec.TargetSelector); err != nil {
+			return err
+		}
+	}
+
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+
+	if previous, exists := pi.clusterPolicies[policy.Name]; !exists || !previous.namespaces.Equal(sets.New(namespaces...)) ||
+		!equality.Semantic.DeepEqual(previous.policy.Spec, policy.Spec) {
+		defer pi.notifyLocked()
+	}
+	pi.clusterPolicies[policy.Name] = &clusterEntry{
+		policy:     policy.DeepCopy(),
+		namespaces: sets.New(namespaces...),
+		selector:   selector,
+	}
+	return nil
+}
+
+// GetCluster returns the indexed ClusterDnsPolicy with the given name, or nil.
+func (pi *PolicyIndex) GetCluster(name string) *dnspolicyv1beta1.ClusterDnsPolicy {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+
+	entry, exists := pi.clusterPolicies[name]
+	if !exists {
+		return nil
+	}
+	return entry.policy.DeepCopy()
+}
+
+// DeleteCluster removes a ClusterDnsPolicy from the index.
+func (pi *PolicyIndex) DeleteCluster(name string) {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+
+	if _, exists := pi.clusterPolicies[name]; exists {
+		delete(pi.clusterPolicies, name)
+		pi.notifyLocked()
 	}
+}
+
+// ResolveCluster returns every ClusterDnsPolicy that applies to a pod,
+// ordered by name. A cluster policy applies when it selects the pod's
+// namespace, its TargetSelector matches the pod labels and its Subject, if
+// set, matches the pod's service account.
+func (pi *PolicyIndex) ResolveCluster(namespace, serviceAccount string, podLabels labels.Labels) []*dnspolicyv1beta1.ClusterDnsPolicy {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+
+	var policies []*dnspolicyv1beta1.ClusterDnsPolicy
+	for _, entry := range pi.clusterPolicies {
+		if !entry.namespaces.Has(namespace) || !entry.selector.Matches(podLabels) {
+			continue
+		}
+		if subject := entry.policy.Spec.Subject; !subject.IsEmpty() && !subjectMatches(subject, namespace, serviceAccount) {
+			continue
+		}
+		policies = append(policies, entry.policy.DeepCopy())
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Name < policies[j].Name
+	})
 	return policies
 }
 
+// ClusterSize returns the number of ClusterDnsPolicies in the index.
+func (pi *PolicyIndex) ClusterSize() int {
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	return len(pi.clusterPolicies)
+}
+
 // Size returns the number of policies in the index.
 func (pi *PolicyIndex) Size() int {
 	pi.mu.RLock()
 	defer pi.mu.RUnlock()
-	return len(pi.hashToPolicy)
+	return len(pi.nameToHash)
 }
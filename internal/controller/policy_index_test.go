@@ -0,0 +1,197 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("PolicyIndex", func() {
+	newPolicy := func(name string, selector *metav1.LabelSelector) *dnsv1beta1.DnsPolicy {
+		return &dnsv1beta1.DnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       dnsv1beta1.DnsPolicySpec{TargetSelector: selector},
+		}
+	}
+
+	Context("Match", func() {
+		It("should resolve pod labels against matchLabels and matchExpressions", func() {
+			index := NewPolicyIndex()
+			policies := []*dnsv1beta1.DnsPolicy{
+				newPolicy("frontend", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}),
+				newPolicy("web-tier", &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "edge"}},
+					{Key: "environment", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"dev"}},
+				}}),
+				newPolicy("backend", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "backend"}}),
+			}
+			for _, policy := range policies {
+				hash, err := SelectorHashFor(&policy.Spec)
+				Expect(err).NotTo(HaveOccurred())
+				index.Upsert(policy, hash)
+			}
+
+			names := func(podLabels map[string]string) []string {
+				var result []string
+				for _, policy := range index.Match(labels.Set(podLabels)) {
+					result = append(result, policy.Name)
+				}
+				return result
+			}
+			Expect(names(map[string]string{"app": "frontend", "tier": "edge", "environment": "prod"})).
+				To(Equal([]string{"frontend", "web-tier"}))
+			Expect(names(map[string]string{"app": "frontend", "tier": "edge", "environment": "dev"})).
+				To(Equal([]string{"frontend"}))
+			Expect(names(map[string]string{"app": "worker"})).To(BeEmpty())
+		})
+
+		It("should forget the selector of a deleted policy", func() {
+			index := NewPolicyIndex()
+			policy := newPolicy("frontend", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}})
+			hash, err := SelectorHashFor(&policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			index.Upsert(policy, hash)
+
+			index.Delete(client.ObjectKeyFromObject(policy))
+			Expect(index.Match(labels.Set{"app": "frontend"})).To(BeEmpty())
+		})
+
+		It("should keep every policy that selects the same pods", func() {
+			index := NewPolicyIndex()
+			selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}
+			team, security := newPolicy("team", selector), newPolicy("security", selector)
+			hash, err := SelectorHashFor(&team.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			index.Upsert(team, hash)
+			index.Upsert(security, hash)
+
+			Expect(index.Get("default", hash)).To(HaveLen(2))
+			Expect(index.Match(labels.Set{"app": "frontend"})).To(HaveLen(2))
+			Expect(index.Size()).To(Equal(2))
+
+			index.Delete(client.ObjectKeyFromObject(team))
+			Expect(index.Match(labels.Set{"app": "frontend"})).To(HaveLen(1))
+			Expect(index.Get("default", hash)[0].Name).To(Equal("security"))
+		})
+	})
+
+	Context("Changes", func() {
+		It("should notify watchers of changes but not of unchanged upserts", func() {
+			index := NewPolicyIndex()
+			policy := newPolicy("frontend", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}})
+			hash, err := SelectorHashFor(&policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			revision, changed := index.Changes()
+			index.Upsert(policy, hash)
+			Expect(changed).To(BeClosed())
+
+			next, changed := index.Changes()
+			Expect(next).To(Equal(revision + 1))
+			index.Upsert(policy, hash)
+			Expect(changed).NotTo(BeClosed())
+
+			policy.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}}
+			index.Upsert(policy, hash)
+			Expect(changed).To(BeClosed())
+
+			_, changed = index.Changes()
+			index.Delete(client.ObjectKeyFromObject(policy))
+			Expect(changed).To(BeClosed())
+			_, changed = index.Changes()
+			index.Delete(client.ObjectKeyFromObject(policy))
+			Expect(changed).NotTo(BeClosed())
+		})
+	})
+
+	Context("Document", func() {
+		It("should encode each policy once per upsert", func() {
+			index := NewPolicyIndex()
+			policy := newPolicy("frontend", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}})
+			key := client.ObjectKeyFromObject(policy)
+			index.Upsert(policy, "hash")
+
+			data, etag := index.Document(key)
+			expected, err := json.Marshal(NewPolicyDocument(policy))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(MatchJSON(expected))
+			Expect(etag).NotTo(BeEmpty())
+
+			policy.Spec.DryRun = true
+			index.Upsert(policy, "hash")
+			changed, changedETag := index.Document(key)
+			Expect(changed).NotTo(Equal(data))
+			Expect(changedETag).NotTo(Equal(etag))
+
+			index.Delete(key)
+			data, _ = index.Document(key)
+			Expect(data).To(BeNil())
+		})
+	})
+
+	Context("ResolveCluster", func() {
+		It("should match cluster policies by namespace and pod labels", func() {
+			index := NewPolicyIndex()
+			Expect(index.UpsertCluster(&dnsv1beta1.ClusterDnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "baseline"},
+			}, []string{"default", "payments"})).To(Succeed())
+			Expect(index.UpsertCluster(&dnsv1beta1.ClusterDnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "frontend"},
+				Spec: dnsv1beta1.ClusterDnsPolicySpec{
+					TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				},
+			}, []string{"default"})).To(Succeed())
+
+			names := func(namespace string, podLabels map[string]string) []string {
+				var result []string
+				for _, policy := range index.ResolveCluster(namespace, "default", labels.Set(podLabels)) {
+					result = append(result, policy.Name)
+				}
+				return result
+			}
+			Expect(names("default", map[string]string{"app": "frontend"})).To(Equal([]string{"baseline", "frontend"}))
+			Expect(names("payments", map[string]string{"app": "frontend"})).To(Equal([]string{"baseline"}))
+			Expect(names("kube-system", nil)).To(BeEmpty())
+
+			index.DeleteCluster("baseline")
+			Expect(names("payments", map[string]string{"app": "frontend"})).To(BeEmpty())
+			Expect(index.ClusterSize()).To(Equal(1))
+		})
+
+		It("should match any of the subject's service accounts", func() {
+			index := NewPolicyIndex()
+			Expect(index.UpsertCluster(&dnsv1beta1.ClusterDnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "pci"},
+				Spec: dnsv1beta1.ClusterDnsPolicySpec{
+					Subject: &dnsv1beta1.Subject{ServiceAccounts: []string{"payments", "billing"}},
+				},
+			}, []string{"default"})).To(Succeed())
+
+			Expect(index.ResolveCluster("default", "billing", labels.Set{})).To(HaveLen(1))
+			Expect(index.ResolveCluster("default", "frontend", labels.Set{})).To(BeEmpty())
+		})
+	})
+})
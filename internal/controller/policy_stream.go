@@ -0,0 +1,408 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	dnspolicyv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// PolicyStreamMethod is the full gRPC method name of the policy stream.
+const PolicyStreamMethod = "/dnsmesh.v1.PolicyDiscoveryService/StreamPolicies"
+
+// JSONCodec encodes the messages of the policy stream as JSON, so clients
+// need no generated protobuf code. Clients select it with
+// grpc.ForceCodec(JSONCodec{}).
+type JSONCodec struct{}
+
+// Marshal encodes v as JSON.
+func (JSONCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal decodes JSON data into v.
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// Name returns the content subtype of the codec, application/grpc+json.
+func (JSONCodec) Name() string { return "json" }
+
+// StreamRequest is sent by a subscriber: first with its identity, then once
+// for every StreamResponse to acknowledge (ACK) or reject (NACK) it.
+type StreamRequest struct {
+	// Node identifies the subscriber, e.g. its pod name. Required on the
+	// first request.
+	Node string `json:"node,omitempty"`
+	// Pod is the identity the policy is resolved for. Required on the first
+	// request and ignored afterwards.
+	Pod *ResolveRequest `json:"pod,omitempty"`
+	// VersionInfo is the last version the subscriber applied.
+	VersionInfo string `json:"versionInfo,omitempty"`
+	// ResponseNonce is the nonce of the response being acknowledged.
+	ResponseNonce string `json:"responseNonce,omitempty"`
+	// ErrorDetail rejects the response when set; the subscriber keeps
+	// enforcing VersionInfo.
+	ErrorDetail string `json:"errorDetail,omitempty"`
+}
+
+// StreamResponse sends a version of the effective policy. The first
+// response, and any response after the subscriber rejected every version,
+// holds the full Policy; the others hold a Delta from the last version the
+// subscriber applied.
+type StreamResponse struct {
+	VersionInfo string           `json:"versionInfo"`
+	Nonce       string           `json:"nonce"`
+	Policy      *EffectivePolicy `json:"policy,omitempty"`
+	Delta       *PolicyDelta     `json:"delta,omitempty"`
+}
+
+// PolicyDelta is the change from BaseVersion to a new version of an
+// effective policy. Rules are identified by pattern, match type and query
+// types: an upserted rule replaces the rule with the same identity. The
+// other fields are small and always sent in full.
+type PolicyDelta struct {
+	BaseVersion       string                         `json:"baseVersion"`
+	UpsertedBlockList []EffectiveRule                `json:"upsertedBlockList,omitempty"`
+	RemovedBlockList  []dnspolicyv1beta1.DomainRule  `json:"removedBlockList,omitempty"`
+	UpsertedAllowList []EffectiveRule                `json:"upsertedAllowList,omitempty"`
+	RemovedAllowList  []dnspolicyv1beta1.DomainRule  `json:"removedAllowList,omitempty"`
+	Sources           []PolicySource                 `json:"sources"`
+	Shadowed          []PolicySource                 `json:"shadowed,omitempty"`
+	DefaultAction     dnspolicyv1beta1.DefaultAction `json:"defaultAction"`
+	Action            *dnspolicyv1beta1.BlockAction  `json:"action"`
+	DryRun            bool                           `json:"dryrun"`
+}
+
+// SubscriberStatus is what the controller knows about a connected subscriber.
+type SubscriberStatus struct {
+	Node        string         `json:"node"`
+	Pod         ResolveRequest `json:"pod"`
+	ConnectedAt time.Time      `json:"connectedAt"`
+	// SentVersion is the last version sent to the subscriber.
+	SentVersion string `json:"sentVersion,omitempty"`
+	// AppliedVersion is the last version the subscriber acknowledged.
+	AppliedVersion string     `json:"appliedVersion,omitempty"`
+	AppliedAt      *time.Time `json:"appliedAt,omitempty"`
+	// Error is the reason the subscriber gave for rejecting SentVersion.
+	Error string `json:"error,omitempty"`
+}
+
+// policyDiscoveryServer is the handler type of the service descriptor.
+type policyDiscoveryServer interface {
+	streamPolicies(stream grpc.ServerStream) error
+}
+
+// policyDiscoveryServiceDesc describes the service without generated code.
+var policyDiscoveryServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dnsmesh.v1.PolicyDiscoveryService",
+	HandlerType: (*policyDiscoveryServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName: "StreamPolicies",
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			return srv.(policyDiscoveryServer).streamPolicies(stream)
+		},
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+}
+
+// PolicyStreamServer pushes effective policies to subscribed sidecars over
+// a bidirectional gRPC stream, in the manner of the Envoy xDS protocol.
+// Each sidecar subscribes with its pod identity and receives its effective
+// policy, then a delta whenever the policy changes. Only one response is
+// in flight per stream: the next one is sent once the subscriber ACKs or
+// NACKs the last.
+type PolicyStreamServer struct {
+	Index  *PolicyIndex
+	Server *grpc.Server
+	Addr   string
+	// Auth, when set, requires the ServiceAccount token of the subscribing
+	// pod in the authorization metadata and resolves its own policy only.
+	Auth *PodAuthenticator
+
+	nonce       atomic.Uint64
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	// shutdown is closed when the server stops to end open streams.
+	shutdown chan struct{}
+}
+
+// subscriber is the state of one open stream, guarded by the server's mu.
+type subscriber struct {
+	status SubscriberStatus
+}
+
+// NewPolicyStreamServer creates a gRPC server for the policy stream. opts
+// are added to the server options, e.g. grpc.Creds to serve TLS.
+func NewPolicyStreamServer(index *PolicyIndex, addr string, opts ...grpc.ServerOption) *PolicyStreamServer {
+	s := &PolicyStreamServer{
+		Index:       index,
+		Addr:        addr,
+		subscribers: map[*subscriber]struct{}{},
+		shutdown:    make(chan struct{}),
+	}
+	s.Server = grpc.NewServer(append([]grpc.ServerOption{grpc.ForceServerCodec(JSONCodec{})}, opts...)...)
+	s.Server.RegisterService(&policyDiscoveryServiceDesc, s)
+	return s
+}
+
+// Start serves the policy stream until the context is cancelled.
+func (s *PolicyStreamServer) Start(ctx context.Context) error {
+	log := ctrl.LoggerFrom(ctx).WithName("policy-stream")
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	log.Info("Starting policy stream server", "addr", s.Addr)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Server.Serve(listener) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Info("Shutting down policy stream server")
+	close(s.shutdown)
+	s.Server.GracefulStop()
+	return nil
+}
+
+// Subscribers returns the status of every open stream, ordered by node.
+func (s *PolicyStreamServer) Subscribers() []SubscriberStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscribers := make([]SubscriberStatus, 0, len(s.subscribers))
+	for sub := range s.subscribers {
+		subscribers = append(subscribers, sub.status)
+	}
+	sort.Slice(subscribers, func(i, j int) bool {
+		if subscribers[i].Node != subscribers[j].Node {
+			return subscribers[i].Node < subscribers[j].Node
+		}
+		return subscribers[i].ConnectedAt.Before(subscribers[j].ConnectedAt)
+	})
+	return subscribers
+}
+
+// authorize authenticates the subscribing pod and checks that it asks for
+// its own policy. It returns the pod's full identity.
+func (s *PolicyStreamServer) authorize(ctx context.Context, pod ResolveRequest) (ResolveRequest, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token = bearerToken(values[0])
+		}
+	}
+	caller, err := s.Auth.Authenticate(ctx, token)
+	if err == nil {
+		pod, err = caller.Authorize(pod)
+	}
+	switch {
+	case err == nil:
+		return pod, nil
+	case errors.Is(err, errUnauthenticated):
+		return pod, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, errForbidden):
+		return pod, status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return pod, status.Error(codes.Unavailable, err.Error())
+	}
+}
+
+// update changes the status of a subscriber under the lock.
+func (s *PolicyStreamServer) update(sub *subscriber, change func(*SubscriberStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(&sub.status)
+}
+
+// streamPolicies serves one subscriber.
+func (s *PolicyStreamServer) streamPolicies(stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	log := ctrl.LoggerFrom(ctx).WithName("policy-stream")
+
+	var first StreamRequest
+	if err := stream.RecvMsg(&first); err != nil {
+		return err
+	}
+	if first.Node == "" || first.Pod == nil || first.Pod.Namespace == "" {
+		return status.Error(codes.InvalidArgument, "the first request must set node and pod.namespace")
+	}
+	pod := *first.Pod
+	if s.Auth != nil {
+		identity, err := s.authorize(ctx, pod)
+		if err != nil {
+			return err
+		}
+		pod = identity
+	}
+	podLabels := labels.Set(pod.Labels)
+
+	sub := &subscriber{status: SubscriberStatus{Node: first.Node, Pod: pod, ConnectedAt: time.Now()}}
+	s.mu.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, sub)
+		s.mu.Unlock()
+	}()
+
+	// Receive ACKs and NACKs while responses are sent
+	requests := make(chan *StreamRequest)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			req := &StreamRequest{}
+			if err := stream.RecvMsg(req); err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		// applied is the last policy the subscriber acknowledged
+		applied        *EffectivePolicy
+		appliedVersion string
+		// sent is the last policy sent; pendingNonce is set until it is answered
+		sent         *EffectivePolicy
+		sentVersion  string
+		pendingNonce string
+	)
+	for {
+		var changed <-chan struct{}
+		if pendingNonce == "" {
+			_, changed = s.Index.Changes()
+			effective := MergePolicies(
+				s.Index.Resolve(pod.Namespace, pod.ServiceAccount, podLabels),
+				s.Index.ResolveCluster(pod.Namespace, pod.ServiceAccount, podLabels),
+			)
+			version, err := policyVersion(effective)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if version != sentVersion {
+				resp := &StreamResponse{VersionInfo: version, Nonce: strconv.FormatUint(s.nonce.Add(1), 10)}
+				if applied == nil {
+					resp.Policy = effective
+				} else {
+					resp.Delta = diffPolicies(applied, appliedVersion, effective)
+				}
+				if err := stream.SendMsg(resp); err != nil {
+					return err
+				}
+				sent, sentVersion, pendingNonce = effective, version, resp.Nonce
+				s.update(sub, func(st *SubscriberStatus) { st.SentVersion = version })
+			}
+		}
+
+		select {
+		case req := <-requests:
+			if pendingNonce == "" || req.ResponseNonce != pendingNonce {
+				// A stale answer to an earlier response
+				continue
+			}
+			pendingNonce = ""
+			if req.ErrorDetail != "" {
+				log.Info("Subscriber rejected policy", "node", first.Node, "version", sentVersion,
+					"error", req.ErrorDetail)
+				s.update(sub, func(st *SubscriberStatus) { st.Error = req.ErrorDetail })
+				continue
+			}
+			applied, appliedVersion = sent, sentVersion
+			now := time.Now()
+			s.update(sub, func(st *SubscriberStatus) {
+				st.AppliedVersion, st.AppliedAt, st.Error = appliedVersion, &now, ""
+			})
+		case <-changed:
+		case err := <-errc:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-s.shutdown:
+			return status.Error(codes.Unavailable, "the controller is shutting down")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// diffPolicies returns the delta that turns base into next.
+func diffPolicies(base *EffectivePolicy, baseVersion string, next *EffectivePolicy) *PolicyDelta {
+	delta := &PolicyDelta{
+		BaseVersion:   baseVersion,
+		Sources:       next.Sources,
+		Shadowed:      next.Shadowed,
+		DefaultAction: next.DefaultAction,
+		Action:        next.Action,
+		DryRun:        next.DryRun,
+	}
+	delta.UpsertedBlockList, delta.RemovedBlockList = diffRules(base.BlockList, next.BlockList)
+	delta.UpsertedAllowList, delta.RemovedAllowList = diffRules(base.AllowList, next.AllowList)
+	return delta
+}
+
+// diffRules returns the rules of next that are new or changed and the
+// identity of the rules of base that next no longer has.
+func diffRules(base, next []EffectiveRule) ([]EffectiveRule, []dnspolicyv1beta1.DomainRule) {
+	previous := make(map[string]*EffectiveRule, len(base))
+	for i := range base {
+		previous[mergeKey(&base[i].DomainRule)] = &base[i]
+	}
+	var upserted []EffectiveRule
+	for i := range next {
+		key := mergeKey(&next[i].DomainRule)
+		if rule, ok := previous[key]; !ok || !equality.Semantic.DeepEqual(*rule, next[i]) {
+			upserted = append(upserted, next[i])
+		}
+		delete(previous, key)
+	}
+	var removed []dnspolicyv1beta1.DomainRule
+	for i := range base {
+		if _, ok := previous[mergeKey(&base[i].DomainRule)]; ok {
+			removed = append(removed, dnspolicyv1beta1.DomainRule{
+				Pattern: base[i].Pattern, MatchType: base[i].MatchType, QTypes: base[i].QTypes,
+			})
+		}
+	}
+	return upserted, removed
+}
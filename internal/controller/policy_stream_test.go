@@ -0,0 +1,243 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("PolicyStreamServer", func() {
+	var (
+		server *PolicyStreamServer
+		policy *dnsv1beta1.DnsPolicy
+		conn   *grpc.ClientConn
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	upsert := func() {
+		hash, err := SelectorHashFor(&policy.Spec)
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		server.Index.Upsert(policy.DeepCopy(), hash)
+	}
+
+	BeforeEach(func() {
+		policy = &dnsv1beta1.DnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+			Spec: dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				BlockList:      []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}},
+			},
+		}
+		server = NewPolicyStreamServer(NewPolicyIndex(), "")
+		upsert()
+
+		listener := bufconn.Listen(1 << 20)
+		go func() { _ = server.Server.Serve(listener) }()
+		DeferCleanup(server.Server.Stop)
+
+		var err error
+		conn, err = grpc.NewClient("passthrough:///bufconn",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.ForceCodec(JSONCodec{})),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(conn.Close)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+	})
+
+	subscribe := func(node string) grpc.ClientStream {
+		stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, PolicyStreamMethod)
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		ExpectWithOffset(1, stream.SendMsg(&StreamRequest{
+			Node: node,
+			Pod:  &ResolveRequest{Namespace: "default", Labels: map[string]string{"app": "frontend"}},
+		})).To(Succeed())
+		return stream
+	}
+	receive := func(stream grpc.ClientStream) *StreamResponse {
+		resp := &StreamResponse{}
+		ExpectWithOffset(1, stream.RecvMsg(resp)).To(Succeed())
+		return resp
+	}
+	subscriber := func() SubscriberStatus {
+		subscribers := server.Subscribers()
+		ExpectWithOffset(1, subscribers).To(HaveLen(1))
+		return subscribers[0]
+	}
+
+	It("should send the full policy on connect and deltas after an ACK", func() {
+		stream := subscribe("frontend-1")
+		first := receive(stream)
+		Expect(first.Delta).To(BeNil())
+		Expect(first.Policy.BlockList).To(HaveLen(1))
+		Expect(first.Policy.Sources).To(Equal([]PolicySource{{Kind: KindDnsPolicy, Namespace: "default", Name: "frontend"}}))
+		Eventually(subscriber).Should(HaveField("SentVersion", first.VersionInfo))
+
+		Expect(stream.SendMsg(&StreamRequest{VersionInfo: first.VersionInfo, ResponseNonce: first.Nonce})).To(Succeed())
+		Eventually(subscriber).Should(HaveField("AppliedVersion", first.VersionInfo))
+
+		policy.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "tracker.example.com"}}
+		policy.Spec.AllowList = []dnsv1beta1.DomainRule{{Pattern: "api.example.com"}}
+		upsert()
+		second := receive(stream)
+		Expect(second.Policy).To(BeNil())
+		Expect(second.VersionInfo).NotTo(Equal(first.VersionInfo))
+		Expect(second.Delta.BaseVersion).To(Equal(first.VersionInfo))
+		Expect(second.Delta.UpsertedBlockList).To(ConsistOf(HaveField("Pattern", "tracker.example.com")))
+		Expect(second.Delta.RemovedBlockList).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}}))
+		Expect(second.Delta.UpsertedAllowList).To(ConsistOf(HaveField("Pattern", "api.example.com")))
+		Expect(second.Delta.Sources).To(HaveLen(1))
+
+		Expect(stream.SendMsg(&StreamRequest{VersionInfo: second.VersionInfo, ResponseNonce: second.Nonce})).To(Succeed())
+		Eventually(subscriber).Should(HaveField("AppliedVersion", second.VersionInfo))
+
+		// Both the full policy and the delta match the published schema
+		var schema map[string]any
+		Expect(json.Unmarshal(policySchema, &schema)).To(Succeed())
+		defs := schema["$defs"].(map[string]any)
+		for _, resp := range []*StreamResponse{first, second} {
+			data, err := json.Marshal(resp)
+			Expect(err).NotTo(HaveOccurred())
+			var value any
+			Expect(json.Unmarshal(data, &value)).To(Succeed())
+			conformsTo(defs, defs["StreamResponse"].(map[string]any), value, "stream")
+		}
+	})
+
+	It("should hold the next version until the last one is answered", func() {
+		stream := subscribe("frontend-1")
+		first := receive(stream)
+
+		policy.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "tracker.example.com"}}
+		upsert()
+		policy.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "malware.example.com"}}
+		upsert()
+
+		received := make(chan *StreamResponse)
+		go func() {
+			defer GinkgoRecover()
+			resp := &StreamResponse{}
+			if stream.RecvMsg(resp) == nil {
+				received <- resp
+			}
+		}()
+		Consistently(received, "100ms").ShouldNot(Receive())
+
+		// A stale nonce does not count as an answer
+		Expect(stream.SendMsg(&StreamRequest{ResponseNonce: "stale"})).To(Succeed())
+		Consistently(received, "100ms").ShouldNot(Receive())
+
+		Expect(stream.SendMsg(&StreamRequest{VersionInfo: first.VersionInfo, ResponseNonce: first.Nonce})).To(Succeed())
+		var latest *StreamResponse
+		Eventually(received).Should(Receive(&latest))
+		Expect(latest.Delta.UpsertedBlockList).To(ConsistOf(HaveField("Pattern", "malware.example.com")))
+	})
+
+	It("should track a NACK and resend the full policy on the next change", func() {
+		stream := subscribe("frontend-1")
+		first := receive(stream)
+		Expect(stream.SendMsg(&StreamRequest{ResponseNonce: first.Nonce, ErrorDetail: "unsupported action"})).To(Succeed())
+		Eventually(subscriber).Should(HaveField("Error", "unsupported action"))
+		Expect(subscriber().AppliedVersion).To(BeEmpty())
+
+		policy.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "tracker.example.com"}}
+		upsert()
+		second := receive(stream)
+		Expect(second.Delta).To(BeNil())
+		Expect(second.Policy.BlockList).To(ConsistOf(HaveField("Pattern", "tracker.example.com")))
+
+		Expect(stream.SendMsg(&StreamRequest{VersionInfo: second.VersionInfo, ResponseNonce: second.Nonce})).To(Succeed())
+		Eventually(subscriber).Should(And(HaveField("AppliedVersion", second.VersionInfo), HaveField("Error", "")))
+	})
+
+	It("should list subscribers over the HTTP API until they disconnect", func() {
+		apiServer := NewAPIServer(server.Index, "")
+		list := func() []SubscriberStatus {
+			rec := httptest.NewRecorder()
+			apiServer.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/subscribers", nil))
+			if apiServer.Stream == nil {
+				ExpectWithOffset(1, rec.Code).To(Equal(http.StatusNotFound))
+				return nil
+			}
+			ExpectWithOffset(1, rec.Code).To(Equal(http.StatusOK))
+			var subscribers []SubscriberStatus
+			ExpectWithOffset(1, json.Unmarshal(rec.Body.Bytes(), &subscribers)).To(Succeed())
+			return subscribers
+		}
+		Expect(list()).To(BeNil())
+
+		apiServer.Stream = server
+		stream := subscribe("frontend-1")
+		receive(stream)
+		Expect(list()).To(ConsistOf(And(
+			HaveField("Node", "frontend-1"),
+			HaveField("Pod.Namespace", "default"),
+		)))
+
+		Expect(stream.CloseSend()).To(Succeed())
+		Eventually(list).Should(BeEmpty())
+	})
+
+	It("should require the subscriber's token when authentication is enabled", func() {
+		var reviews int
+		server.Auth = newFakeAuthenticator(&reviews)
+		open := func(ctx context.Context, pod ResolveRequest) error {
+			stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, PolicyStreamMethod)
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			ExpectWithOffset(1, stream.SendMsg(&StreamRequest{Node: "frontend-1", Pod: &pod})).To(Succeed())
+			return stream.RecvMsg(&StreamResponse{})
+		}
+		authorized := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer frontend-token")
+
+		Expect(status.Code(open(ctx, ResolveRequest{Namespace: "default"}))).To(Equal(codes.Unauthenticated))
+		Expect(status.Code(open(authorized, ResolveRequest{Namespace: "payments"}))).To(Equal(codes.PermissionDenied))
+		Expect(open(authorized, ResolveRequest{Namespace: "default"})).To(Succeed())
+		Eventually(subscriber).Should(HaveField("Pod.Labels", HaveKeyWithValue("app", "frontend")))
+	})
+
+	It("should reject a first request without a pod identity", func() {
+		stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, PolicyStreamMethod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.SendMsg(&StreamRequest{Node: "frontend-1"})).To(Succeed())
+		err = stream.RecvMsg(&StreamResponse{})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		Expect(server.Subscribers()).To(BeEmpty())
+	})
+})
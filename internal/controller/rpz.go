@@ -0,0 +1,254 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+const (
+	// DefaultRPZZone is the origin of exported zones that do not set one.
+	DefaultRPZZone = "rpz.local"
+	// DefaultRPZKey is the ConfigMap key of exported zones that do not set one.
+	DefaultRPZKey = "db.rpz"
+
+	// rpzTTL is the TTL of every record and the negative TTL of the zone.
+	rpzTTL = 300
+)
+
+// RPZ policy actions, written as the target of a CNAME record.
+const (
+	rpzNXDomain    = "."
+	rpzNoData      = "*."
+	rpzDrop        = "rpz-drop."
+	rpzPassthrough = "rpz-passthru."
+)
+
+// RPZRecords is an effective policy rendered as the records of a Response
+// Policy Zone, without the SOA and NS records.
+type RPZRecords struct {
+	// Text holds one line per record. Rules that RPZ cannot express, and
+	// those that are only logged, are written as comments.
+	Text string
+	// Records counts the records written.
+	Records int
+	// Skipped counts the rules left out.
+	Skipped int
+}
+
+// RenderRPZ writes an effective policy as an RPZ zone file for zone. Rules
+// expired at now are left out.
+func RenderRPZ(effective *EffectivePolicy, zone string, serial uint32, now time.Time) []byte {
+	return RPZZoneFile(zone, serial, BuildRPZRecords(effective, now))
+}
+
+// RPZZoneFile wraps records in a zone file for zone with the given serial.
+func RPZZoneFile(zone string, serial uint32, records RPZRecords) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ORIGIN %s.\n", strings.TrimSuffix(zone, "."))
+	fmt.Fprintf(&b, "$TTL %d\n", rpzTTL)
+	fmt.Fprintf(&b, "@ IN SOA localhost. hostmaster.localhost. %d 3600 600 86400 %d\n", serial, rpzTTL)
+	b.WriteString("@ IN NS localhost.\n")
+	b.WriteString(records.Text)
+	return []byte(b.String())
+}
+
+// BuildRPZRecords translates the rules of an effective policy into RPZ
+// records:
+//
+//   - blocked names answer NXDOMAIN with CNAME ".", NODATA with CNAME "*.",
+//     REFUSED with rpz-drop (RPZ cannot refuse) and Sinkhole with A and
+//     AAAA records;
+//   - Suffix rules cover the name and "*." below it, Wildcard rules with a
+//     leading "*" become RPZ wildcards, and Exact rules the name itself;
+//   - DefaultAction Deny blocks "*", and allowed names pass through unless
+//     a block rule covers them, since blocking wins over allowing.
+//
+// Regex rules, rules limited to qtypes and other wildcards have no RPZ
+// equivalent and are skipped. Dry-run rules are written as comments. When
+// rules produce the same owner name the one merged first wins.
+func BuildRPZRecords(effective *EffectivePolicy, now time.Time) RPZRecords {
+	w := &rpzWriter{written: map[string]bool{}}
+	if len(effective.Sources) > 0 {
+		w.comment("generated from %s", sourcesNote(effective.Sources))
+	}
+
+	var blockOwners []string
+	for i := range effective.BlockList {
+		rule := &effective.BlockList[i]
+		owners, ok := w.ruleOwners(rule, now)
+		if !ok {
+			continue
+		}
+		dryRun := effective.DryRun || rule.DryRun
+		if !dryRun {
+			blockOwners = append(blockOwners, owners...)
+		}
+		for _, owner := range owners {
+			w.block(owner, rule.Action, sourcesNote(rule.Sources), dryRun)
+		}
+	}
+
+	if effective.DefaultAction != dnsv1beta1.DefaultActionDeny {
+		return w.records()
+	}
+	for i := range effective.AllowList {
+		rule := &effective.AllowList[i]
+		owners, ok := w.ruleOwners(rule, now)
+		if !ok {
+			continue
+		}
+		for _, owner := range owners {
+			if blocker := coveringOwner(owner, blockOwners); blocker != "" {
+				w.comment("skipped allowList %s: blocked by %s", owner, blocker)
+				w.skipped++
+				continue
+			}
+			w.record(owner, "CNAME", rpzPassthrough, sourcesNote(rule.Sources), effective.DryRun || rule.DryRun)
+		}
+	}
+	w.block("*", effective.Action, "defaultAction Deny", effective.DryRun)
+	return w.records()
+}
+
+// rpzWriter collects the records of a zone.
+type rpzWriter struct {
+	b       strings.Builder
+	written map[string]bool
+	count   int
+	skipped int
+}
+
+func (w *rpzWriter) records() RPZRecords {
+	return RPZRecords{Text: w.b.String(), Records: w.count, Skipped: w.skipped}
+}
+
+func (w *rpzWriter) comment(format string, args ...any) {
+	fmt.Fprintf(&w.b, "; "+format+"\n", args...)
+}
+
+// ruleOwners returns the owner names a rule expands to, or writes why it is
+// skipped and returns false.
+func (w *rpzWriter) ruleOwners(rule *EffectiveRule, now time.Time) ([]string, bool) {
+	reason := ""
+	var owners []string
+	pattern := CanonicalDomain(rule.Pattern)
+	switch matchType := rule.EffectiveMatchType(); {
+	case rule.ExpiresAt != nil && !now.Before(rule.ExpiresAt.Time):
+		return nil, false
+	case len(rule.QTypes) > 0:
+		reason = "RPZ cannot match query types"
+	case matchType == dnsv1beta1.MatchTypeExact:
+		owners = []string{pattern}
+	case matchType == dnsv1beta1.MatchTypeSuffix:
+		owners = []string{pattern, "*." + pattern}
+	case matchType == dnsv1beta1.MatchTypeWildcard && strings.HasPrefix(pattern, "*.") &&
+		!strings.Contains(pattern[2:], "*"):
+		owners = []string{pattern}
+	default:
+		reason = fmt.Sprintf("RPZ cannot express %s patterns", matchType)
+	}
+	if reason != "" {
+		w.comment("skipped %s: %s", rule.Pattern, reason)
+		w.skipped++
+		return nil, false
+	}
+	return owners, true
+}
+
+// block writes the records answering queries for owner with action.
+func (w *rpzWriter) block(owner string, action *dnsv1beta1.BlockAction, note string, dryRun bool) {
+	actionType := dnsv1beta1.BlockActionNXDomain
+	if action != nil && action.Type != "" {
+		actionType = action.Type
+	}
+	switch actionType {
+	case dnsv1beta1.BlockActionNoData:
+		w.record(owner, "CNAME", rpzNoData, note, dryRun)
+	case dnsv1beta1.BlockActionRefused:
+		w.record(owner, "CNAME", rpzDrop, note, dryRun)
+	case dnsv1beta1.BlockActionSinkhole:
+		if w.written[owner] {
+			return
+		}
+		if action.SinkholeIPv4 != "" {
+			w.write(owner, "A", action.SinkholeIPv4, note, dryRun)
+		}
+		if action.SinkholeIPv6 != "" {
+			w.write(owner, "AAAA", action.SinkholeIPv6, note, dryRun)
+		}
+		w.written[owner] = !dryRun
+	default:
+		w.record(owner, "CNAME", rpzNXDomain, note, dryRun)
+	}
+}
+
+// record writes a single record unless owner already has records. Dry-run
+// records leave owner free for an enforced rule merged later.
+func (w *rpzWriter) record(owner, recordType, data, note string, dryRun bool) {
+	if w.written[owner] {
+		return
+	}
+	w.write(owner, recordType, data, note, dryRun)
+	w.written[owner] = !dryRun
+}
+
+// write writes a record followed by note as a comment. Dry-run records are
+// written as comments only.
+func (w *rpzWriter) write(owner, recordType, data, note string, dryRun bool) {
+	line := fmt.Sprintf("%s IN %s %s", owner, recordType, data)
+	if dryRun {
+		w.comment("dry run: %s", line)
+		return
+	}
+	fmt.Fprintf(&w.b, "%s ; %s\n", line, note)
+	w.count++
+}
+
+// sourcesNote lists the policies a rule came from.
+func sourcesNote(sources []PolicySource) string {
+	names := make([]string, 0, len(sources))
+	for _, source := range sources {
+		names = append(names, source.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// coveringOwner returns the first block owner whose records answer queries
+// for owner instead of owner's own records: the same name, or a wildcard
+// above it. RPZ prefers exact names and longer wildcards, so a passthrough
+// for owner would otherwise win over the block.
+func coveringOwner(owner string, blockOwners []string) string {
+	name, isWildcard := strings.CutPrefix(owner, "*.")
+	for _, blocked := range blockOwners {
+		if blocked == owner {
+			return blocked
+		}
+		parent, ok := strings.CutPrefix(blocked, "*.")
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(name, "."+parent) || (isWildcard && name == parent) {
+			return blocked
+		}
+	}
+	return ""
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("RPZ export", func() {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	render := func(spec dnsv1beta1.DnsPolicySpec) []string {
+		policy := &dnsv1beta1.DnsPolicy{ObjectMeta: metav1.ObjectMeta{Name: "ads", Namespace: "default"}, Spec: spec}
+		records := BuildRPZRecords(MergePolicies([]*dnsv1beta1.DnsPolicy{policy}, nil), now)
+		return strings.Split(strings.TrimSuffix(records.Text, "\n"), "\n")
+	}
+
+	It("should translate block rules and actions", func() {
+		expired := metav1.NewTime(now.Add(-time.Hour))
+		Expect(render(dnsv1beta1.DnsPolicySpec{
+			BlockList: []dnsv1beta1.DomainRule{
+				{Pattern: "Ads.Example.com."},
+				{Pattern: "tracker.example.net", MatchType: dnsv1beta1.MatchTypeSuffix,
+					Action: &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionNoData}},
+				{Pattern: "*.doubleclick.net", Action: &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionRefused}},
+				{Pattern: "sinkhole.example.org", Action: &dnsv1beta1.BlockAction{
+					Type: dnsv1beta1.BlockActionSinkhole, SinkholeIPv4: "10.0.0.1", SinkholeIPv6: "fd00::1",
+				}},
+				{Pattern: `^ads[0-9]+\.example\.com$`, MatchType: dnsv1beta1.MatchTypeRegex},
+				{Pattern: "txt.example.com", QTypes: []string{"TXT"}},
+				{Pattern: "old.example.com", ExpiresAt: &expired},
+			},
+		})).To(Equal([]string{
+			"; generated from DnsPolicy/default/ads",
+			"ads.example.com IN CNAME . ; DnsPolicy/default/ads",
+			"tracker.example.net IN CNAME *. ; DnsPolicy/default/ads",
+			"*.tracker.example.net IN CNAME *. ; DnsPolicy/default/ads",
+			"*.doubleclick.net IN CNAME rpz-drop. ; DnsPolicy/default/ads",
+			"sinkhole.example.org IN A 10.0.0.1 ; DnsPolicy/default/ads",
+			"sinkhole.example.org IN AAAA fd00::1 ; DnsPolicy/default/ads",
+			`; skipped ^ads[0-9]+\.example\.com$: RPZ cannot express Regex patterns`,
+			"; skipped txt.example.com: RPZ cannot match query types",
+		}))
+	})
+
+	It("should pass allowed names through a default deny unless they are blocked", func() {
+		Expect(render(dnsv1beta1.DnsPolicySpec{
+			DefaultAction: dnsv1beta1.DefaultActionDeny,
+			BlockList:     []dnsv1beta1.DomainRule{{Pattern: "*.ads.example.com"}},
+			AllowList: []dnsv1beta1.DomainRule{
+				{Pattern: "example.com", MatchType: dnsv1beta1.MatchTypeSuffix},
+				{Pattern: "cdn.ads.example.com"},
+			},
+		})).To(Equal([]string{
+			"; generated from DnsPolicy/default/ads",
+			"*.ads.example.com IN CNAME . ; DnsPolicy/default/ads",
+			"example.com IN CNAME rpz-passthru. ; DnsPolicy/default/ads",
+			"*.example.com IN CNAME rpz-passthru. ; DnsPolicy/default/ads",
+			"; skipped allowList cdn.ads.example.com: blocked by *.ads.example.com",
+			"* IN CNAME . ; defaultAction Deny",
+		}))
+	})
+
+	It("should write dry-run rules as comments", func() {
+		records := render(dnsv1beta1.DnsPolicySpec{
+			DryRun:    true,
+			BlockList: []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}},
+		})
+		Expect(records).To(ContainElement("; dry run: ads.example.com IN CNAME ."))
+	})
+
+	Context("into a ConfigMap", func() {
+		var (
+			c      client.Client
+			r      *DnsPolicyReconciler
+			policy *dnsv1beta1.DnsPolicy
+		)
+
+		BeforeEach(func() {
+			testScheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+			Expect(dnsv1beta1.AddToScheme(testScheme)).To(Succeed())
+			policy = &dnsv1beta1.DnsPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "ads", Namespace: "default", UID: "ads-uid"},
+				Spec: dnsv1beta1.DnsPolicySpec{
+					BlockList: []dnsv1beta1.DomainRule{{Pattern: "ads.example.com"}},
+					Export: &dnsv1beta1.PolicyExport{
+						RPZ: &dnsv1beta1.RPZExport{Zone: "rpz.example.com", ConfigMapName: "ads-rpz"},
+					},
+				},
+			}
+			c = fake.NewClientBuilder().WithScheme(testScheme).WithObjects(policy).Build()
+			r = &DnsPolicyReconciler{Client: c, Scheme: testScheme}
+		})
+
+		exported := func() *corev1.ConfigMap {
+			var configMap corev1.ConfigMap
+			ExpectWithOffset(1, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "ads-rpz"}, &configMap)).To(Succeed())
+			return &configMap
+		}
+
+		It("should write the zone and keep its serial while the records are unchanged", func() {
+			status, reason, _, err := r.exportRPZ(ctx, policy, &policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(metav1.ConditionTrue))
+			Expect(reason).To(Equal("Exported"))
+			configMap := exported()
+			Expect(metav1.IsControlledBy(configMap, policy)).To(BeTrue())
+			Expect(configMap.Data[DefaultRPZKey]).To(ContainSubstring("\nads.example.com IN CNAME . ; DnsPolicy/default/ads\n"))
+			serial := configMap.Annotations[rpzSerialAnnotation]
+
+			_, _, _, err = r.exportRPZ(ctx, policy, &policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exported().Annotations[rpzSerialAnnotation]).To(Equal(serial))
+
+			policy.Spec.BlockList = append(policy.Spec.BlockList, dnsv1beta1.DomainRule{Pattern: "tracker.example.com"})
+			_, _, _, err = r.exportRPZ(ctx, policy, &policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exported().Annotations[rpzSerialAnnotation]).NotTo(Equal(serial))
+		})
+
+		It("should delete the ConfigMap when the export is removed", func() {
+			_, _, _, err := r.exportRPZ(ctx, policy, &policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			policy.Spec.Export = nil
+			status, _, _, err := r.exportRPZ(ctx, policy, &policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(BeEmpty())
+			var configMaps corev1.ConfigMapList
+			Expect(c.List(ctx, &configMaps)).To(Succeed())
+			Expect(configMaps.Items).To(BeEmpty())
+		})
+
+		It("should not take over a ConfigMap it does not own", func() {
+			Expect(c.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "ads-rpz", Namespace: "default"}})).To(Succeed())
+			status, reason, _, err := r.exportRPZ(ctx, policy, &policy.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(metav1.ConditionFalse))
+			Expect(reason).To(Equal("ConfigMapConflict"))
+			Expect(exported().Data).To(BeEmpty())
+		})
+	})
+})
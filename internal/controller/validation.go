@@ -0,0 +1,433 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+// MaxRulesPerList is the largest BlockList or AllowList a policy may carry.
+// Sidecars load every rule into memory, so huge lists belong in a feed.
+const MaxRulesPerList = 1000
+
+// maxDomainLength is the longest presentation-format name allowed by RFC 1035.
+const maxDomainLength = 253
+
+// dnsLabel matches a single label of a domain pattern. Underscores are
+// accepted for service names such as _sip._tcp.example.com.
+var dnsLabel = regexp.MustCompile(`^[a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?$`)
+
+// ValidateSpec checks everything about a spec that the CRD schema cannot:
+// a valid selector or subject must be set, a subject may not select other
+// namespaces by label, lists must stay within MaxRulesPerList,
+// patterns must be well formed for their match type, list references must
+// be unique, block actions must be consistent, exports must name a
+// valid zone and ConfigMap key and network policies require a default deny.
+func ValidateSpec(spec *dnsv1beta1.DnsPolicySpec) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if canonicalizeSelector(spec.TargetSelector) == nil && spec.Subject.IsEmpty() {
+		errs = append(errs, field.Required(specPath.Child("targetSelector"),
+			"targetSelector or subject must be set"))
+	}
+	if spec.TargetSelector != nil {
+		errs = append(errs, metav1validation.ValidateLabelSelector(spec.TargetSelector,
+			metav1validation.LabelSelectorValidationOptions{}, specPath.Child("targetSelector"))...)
+	}
+	if spec.Subject != nil {
+		errs = append(errs, validateSubject(spec.Subject, specPath.Child("subject"))...)
+		if spec.Subject.NamespaceSelector != nil {
+			errs = append(errs, field.Forbidden(specPath.Child("subject", "namespaceSelector"),
+				"a DnsPolicy only applies to its own namespace; use a ClusterDnsPolicy to select namespaces by label"))
+		}
+	}
+
+	errs = append(errs, validateRules(spec.BlockList, specPath.Child("blockList"))...)
+	errs = append(errs, validateRules(spec.AllowList, specPath.Child("allowList"))...)
+	errs = append(errs, validateDomainListRefs(spec.BlockListRefs, specPath.Child("blockListRefs"))...)
+	errs = append(errs, validateDomainListRefs(spec.AllowListRefs, specPath.Child("allowListRefs"))...)
+
+	errs = append(errs, validateActions(spec, specPath)...)
+	if spec.Export != nil && spec.Export.RPZ != nil {
+		errs = append(errs, validateRPZExport(spec.Export.RPZ, specPath.Child("export", "rpz"))...)
+	}
+	if spec.NetworkPolicy != nil && spec.DefaultAction != dnsv1beta1.DefaultActionDeny {
+		errs = append(errs, field.Forbidden(specPath.Child("networkPolicy"),
+			"network policies can only allow names, so they require defaultAction Deny"))
+	}
+	return errs
+}
+
+func validateRPZExport(export *dnsv1beta1.RPZExport, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if export.Zone != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(strings.TrimSuffix(export.Zone, ".")) {
+			errs = append(errs, field.Invalid(path.Child("zone"), export.Zone, msg))
+		}
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(export.ConfigMapName) {
+		errs = append(errs, field.Invalid(path.Child("configMapName"), export.ConfigMapName, msg))
+	}
+	if export.Key != "" {
+		for _, msg := range validation.IsConfigMapKey(export.Key) {
+			errs = append(errs, field.Invalid(path.Child("key"), export.Key, msg))
+		}
+	}
+	return errs
+}
+
+// ValidateDomainListSpec checks the rules of a DomainList like the BlockList
+// of a policy, and that its sources read from its own namespace.
+func ValidateDomainListSpec(spec *dnsv1beta1.DomainListSpec) field.ErrorList {
+	return validateDomainListSpec(spec, false)
+}
+
+// ValidateClusterDomainListSpec is ValidateDomainListSpec for a
+// ClusterDomainList, whose sources must name their namespace.
+func ValidateClusterDomainListSpec(spec *dnsv1beta1.DomainListSpec) field.ErrorList {
+	return validateDomainListSpec(spec, true)
+}
+
+func validateDomainListSpec(spec *dnsv1beta1.DomainListSpec, clusterScoped bool) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+	rulesPath := specPath.Child("rules")
+
+	errs = append(errs, validateRules(spec.Rules, rulesPath)...)
+	for i := range spec.Rules {
+		if action := spec.Rules[i].Action; action != nil {
+			if err := validateBlockAction(action); err != nil {
+				errs = append(errs, field.Invalid(rulesPath.Index(i).Child("action"), action.Type, err.Error()))
+			}
+		}
+	}
+	for i := range spec.Sources {
+		errs = append(errs, validateSource(&spec.Sources[i], specPath.Child("sources").Index(i), clusterScoped)...)
+	}
+	return errs
+}
+
+// validateSource checks that a list source names exactly one object key or
+// URL in a supported format.
+func validateSource(source *dnsv1beta1.DomainListSource, path *field.Path, clusterScoped bool) field.ErrorList {
+	var errs field.ErrorList
+	switch format := source.EffectiveFormat(); format {
+	case dnsv1beta1.DomainListFormatPlain, dnsv1beta1.DomainListFormatHosts,
+		dnsv1beta1.DomainListFormatAdBlock, dnsv1beta1.DomainListFormatDnsmasq:
+	default:
+		errs = append(errs, field.NotSupported(path.Child("format"), format, []dnsv1beta1.DomainListFormat{
+			dnsv1beta1.DomainListFormatPlain, dnsv1beta1.DomainListFormatHosts,
+			dnsv1beta1.DomainListFormatAdBlock, dnsv1beta1.DomainListFormatDnsmasq,
+		}))
+	}
+
+	// The second field set, in field order, is reported as forbidden.
+	var set []string
+	if source.ConfigMapKeyRef != nil {
+		set = append(set, "configMapKeyRef")
+	}
+	if source.SecretKeyRef != nil {
+		set = append(set, "secretKeyRef")
+	}
+	if source.HTTP != nil {
+		set = append(set, "http")
+	}
+	var ref *dnsv1beta1.SourceKeyReference
+	var refPath *field.Path
+	switch {
+	case len(set) > 1:
+		return append(errs, field.Forbidden(path.Child(set[1]),
+			"only one of configMapKeyRef, secretKeyRef and http may be set"))
+	case source.ConfigMapKeyRef != nil:
+		ref, refPath = source.ConfigMapKeyRef, path.Child("configMapKeyRef")
+	case source.SecretKeyRef != nil:
+		ref, refPath = source.SecretKeyRef, path.Child("secretKeyRef")
+	case source.HTTP != nil && !clusterScoped:
+		return append(errs, field.Forbidden(path.Child("http"),
+			"a DomainList cannot download lists; use a ClusterDomainList"))
+	case source.HTTP != nil:
+		return append(errs, validateHTTPSource(source.HTTP, path.Child("http"))...)
+	default:
+		return append(errs, field.Required(path, "one of configMapKeyRef, secretKeyRef and http must be set"))
+	}
+
+	for _, msg := range validation.IsDNS1123Subdomain(ref.Name) {
+		errs = append(errs, field.Invalid(refPath.Child("name"), ref.Name, msg))
+	}
+	for _, msg := range validation.IsConfigMapKey(ref.Key) {
+		errs = append(errs, field.Invalid(refPath.Child("key"), ref.Key, msg))
+	}
+	switch {
+	case clusterScoped && ref.Namespace == "":
+		errs = append(errs, field.Required(refPath.Child("namespace"), "a ClusterDomainList must name the namespace of its sources"))
+	case !clusterScoped && ref.Namespace != "":
+		errs = append(errs, field.Forbidden(refPath.Child("namespace"),
+			"a DomainList reads sources from its own namespace; use a ClusterDomainList to read from another namespace"))
+	}
+	if ref.Namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(ref.Namespace) {
+			errs = append(errs, field.Invalid(refPath.Child("namespace"), ref.Namespace, msg))
+		}
+	}
+	return errs
+}
+
+// validateHTTPSource checks the URLs, interval, checksum and size limit of
+// an HTTP source.
+func validateHTTPSource(source *dnsv1beta1.HTTPSource, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateFeedURL(source.URL, path.Child("url"))...)
+	if source.Interval != nil && source.Interval.Duration < MinFeedInterval {
+		errs = append(errs, field.Invalid(path.Child("interval"), source.Interval.Duration.String(),
+			fmt.Sprintf("must be at least %s", MinFeedInterval)))
+	}
+	switch {
+	case source.SHA256 != "" && source.SHA256URL != "":
+		errs = append(errs, field.Forbidden(path.Child("sha256URL"), "only one of sha256 and sha256URL may be set"))
+	case source.SHA256 != "" && !isSHA256Hex(strings.ToLower(source.SHA256)):
+		errs = append(errs, field.Invalid(path.Child("sha256"), source.SHA256, "must be 64 hexadecimal characters"))
+	case source.SHA256URL != "":
+		errs = append(errs, validateFeedURL(source.SHA256URL, path.Child("sha256URL"))...)
+	}
+	if source.MaxSize != nil {
+		if size := source.MaxSize.Value(); size <= 0 || size > MaxFeedMaxSize {
+			errs = append(errs, field.Invalid(path.Child("maxSize"), source.MaxSize.String(),
+				fmt.Sprintf("must be greater than 0 and at most %d bytes", MaxFeedMaxSize)))
+		}
+	}
+	return errs
+}
+
+func validateFeedURL(raw string, path *field.Path) field.ErrorList {
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		return field.ErrorList{field.Invalid(path, raw, err.Error())}
+	case u.Scheme != "http" && u.Scheme != "https":
+		return field.ErrorList{field.Invalid(path, raw, "must be an http or https URL")}
+	case u.Host == "":
+		return field.ErrorList{field.Invalid(path, raw, "must name a host")}
+	case strings.EqualFold(u.Hostname(), "localhost"):
+		return field.ErrorList{field.Invalid(path, raw, "must not name the local host")}
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !isFeedAddress(addr) {
+		return field.ErrorList{field.Invalid(path, raw, "must not be a loopback, link-local or unspecified address")}
+	}
+	return nil
+}
+
+// ValidateClusterSpec runs the checks of ValidateSpec on a ClusterDnsPolicySpec.
+// Both selectors are optional: an unset selector selects everything.
+func ValidateClusterSpec(spec *dnsv1beta1.ClusterDnsPolicySpec) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if spec.NamespaceSelector != nil {
+		errs = append(errs, metav1validation.ValidateLabelSelector(spec.NamespaceSelector,
+			metav1validation.LabelSelectorValidationOptions{}, specPath.Child("namespaceSelector"))...)
+	}
+	if spec.TargetSelector != nil {
+		errs = append(errs, metav1validation.ValidateLabelSelector(spec.TargetSelector,
+			metav1validation.LabelSelectorValidationOptions{}, specPath.Child("targetSelector"))...)
+	}
+	if spec.Subject != nil {
+		errs = append(errs, validateSubject(spec.Subject, specPath.Child("subject"))...)
+	}
+
+	errs = append(errs, validateRules(spec.BlockList, specPath.Child("blockList"))...)
+	errs = append(errs, validateRules(spec.AllowList, specPath.Child("allowList"))...)
+
+	errs = append(errs, validateActions(clusterPolicySpec(spec), specPath)...)
+	return errs
+}
+
+// validateSubject checks the names and selector of a subject.
+func validateSubject(subject *dnsv1beta1.Subject, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if subject.ServiceAccount != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(subject.ServiceAccount) {
+			errs = append(errs, field.Invalid(path.Child("serviceAccount"), subject.ServiceAccount, msg))
+		}
+	}
+	for i, name := range subject.ServiceAccounts {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			errs = append(errs, field.Invalid(path.Child("serviceAccounts").Index(i), name, msg))
+		}
+	}
+	if subject.Namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(subject.Namespace) {
+			errs = append(errs, field.Invalid(path.Child("namespace"), subject.Namespace, msg))
+		}
+	}
+	if subject.NamespaceSelector != nil {
+		errs = append(errs, metav1validation.ValidateLabelSelector(subject.NamespaceSelector,
+			metav1validation.LabelSelectorValidationOptions{}, path.Child("namespaceSelector"))...)
+	}
+	return errs
+}
+
+// validateDomainListRefs checks the kind and name of each list reference and
+// that no list is referenced twice.
+func validateDomainListRefs(refs []dnsv1beta1.DomainListReference, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	seen := make(map[dnsv1beta1.DomainListReference]bool, len(refs))
+	for i := range refs {
+		ref := dnsv1beta1.DomainListReference{Kind: refs[i].EffectiveKind(), Name: refs[i].Name}
+		if ref.Kind != dnsv1beta1.DomainListKindNamespaced && ref.Kind != dnsv1beta1.DomainListKindCluster {
+			errs = append(errs, field.NotSupported(path.Index(i).Child("kind"), ref.Kind,
+				[]dnsv1beta1.DomainListKind{dnsv1beta1.DomainListKindNamespaced, dnsv1beta1.DomainListKindCluster}))
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(ref.Name) {
+			errs = append(errs, field.Invalid(path.Index(i).Child("name"), ref.Name, msg))
+		}
+		if seen[ref] {
+			errs = append(errs, field.Duplicate(path.Index(i), ref.Name))
+		}
+		seen[ref] = true
+	}
+	return errs
+}
+
+// validateRules checks the size of a rule list and the pattern of each rule.
+func validateRules(rules []dnsv1beta1.DomainRule, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if len(rules) > MaxRulesPerList {
+		errs = append(errs, field.TooMany(path, len(rules), MaxRulesPerList))
+		return errs
+	}
+	for i := range rules {
+		if err := ValidatePattern(rules[i].Pattern, rules[i].EffectiveMatchType()); err != nil {
+			errs = append(errs, field.Invalid(path.Index(i).Child("pattern"), rules[i].Pattern, err.Error()))
+		}
+	}
+	return errs
+}
+
+// ValidatePattern checks that a rule pattern is well formed for its match type.
+// Regex patterns must compile as RE2. All other patterns must be domain names
+// of valid labels, and '*' may only stand for a whole label in Wildcard rules.
+func ValidatePattern(pattern string, matchType dnsv1beta1.MatchType) error {
+	if matchType == dnsv1beta1.MatchTypeRegex {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid regular expression: %w", err)
+		}
+		return nil
+	}
+
+	name := strings.TrimSuffix(pattern, ".")
+	if name == "" {
+		return fmt.Errorf("pattern must not be empty")
+	}
+	if len(name) > maxDomainLength {
+		return fmt.Errorf("pattern must be no more than %d characters", maxDomainLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "*" {
+			if matchType != dnsv1beta1.MatchTypeWildcard {
+				return fmt.Errorf("'*' is only allowed with matchType %s", dnsv1beta1.MatchTypeWildcard)
+			}
+			continue
+		}
+		if strings.Contains(label, "*") {
+			return fmt.Errorf("'*' must be a whole label, e.g. *.example.com, got %q", label)
+		}
+		if !dnsLabel.MatchString(label) {
+			return fmt.Errorf("%q is not a valid domain label", label)
+		}
+	}
+	return nil
+}
+
+// ValidateActions checks the policy-wide and per-rule block actions of a spec.
+func ValidateActions(spec *dnsv1beta1.DnsPolicySpec) error {
+	return validateActions(spec, field.NewPath("spec")).ToAggregate()
+}
+
+func validateActions(spec *dnsv1beta1.DnsPolicySpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if spec.Action != nil {
+		if err := validateBlockAction(spec.Action); err != nil {
+			errs = append(errs, field.Invalid(path.Child("action"), spec.Action.Type, err.Error()))
+		}
+	}
+
+	for i := range spec.BlockList {
+		if action := spec.BlockList[i].Action; action != nil {
+			if err := validateBlockAction(action); err != nil {
+				errs = append(errs, field.Invalid(path.Child("blockList").Index(i).Child("action"), action.Type, err.Error()))
+			}
+		}
+	}
+	for i := range spec.AllowList {
+		if spec.AllowList[i].Action != nil {
+			errs = append(errs, field.Forbidden(path.Child("allowList").Index(i).Child("action"),
+				"actions are only allowed on blockList rules"))
+		}
+	}
+	return errs
+}
+
+// validateBlockAction checks that sinkhole addresses are set exactly when the
+// action type is Sinkhole and that they belong to the right address family.
+func validateBlockAction(action *dnsv1beta1.BlockAction) error {
+	if action.Type != dnsv1beta1.BlockActionSinkhole {
+		if action.SinkholeIPv4 != "" || action.SinkholeIPv6 != "" {
+			return fmt.Errorf("sinkhole addresses require type %s, got %q", dnsv1beta1.BlockActionSinkhole, action.Type)
+		}
+		return nil
+	}
+
+	if action.SinkholeIPv4 == "" && action.SinkholeIPv6 == "" {
+		return fmt.Errorf("type %s requires sinkholeIPv4 or sinkholeIPv6", dnsv1beta1.BlockActionSinkhole)
+	}
+	if action.SinkholeIPv4 != "" {
+		addr, err := netip.ParseAddr(action.SinkholeIPv4)
+		if err != nil || !addr.Is4() {
+			return fmt.Errorf("sinkholeIPv4 %q is not a valid IPv4 address", action.SinkholeIPv4)
+		}
+	}
+	if action.SinkholeIPv6 != "" {
+		addr, err := netip.ParseAddr(action.SinkholeIPv6)
+		if err != nil || !addr.Is6() || addr.Is4In6() {
+			return fmt.Errorf("sinkholeIPv6 %q is not a valid IPv6 address", action.SinkholeIPv6)
+		}
+	}
+	return nil
+}
+
+// ResolveAction returns the policy-wide block action with defaults applied.
+func ResolveAction(spec *dnsv1beta1.DnsPolicySpec) *dnsv1beta1.BlockAction {
+	resolved := &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionNXDomain}
+	if spec.Action != nil {
+		resolved = spec.Action.DeepCopy()
+		if resolved.Type == "" {
+			resolved.Type = dnsv1beta1.BlockActionNXDomain
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("Policy validation", func() {
+	Context("ValidateSpec", func() {
+		It("should accept a well-formed spec", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				BlockList: []dnsv1beta1.DomainRule{
+					{Pattern: "*.malicious-site.com"},
+					{Pattern: "_sip._tcp.example.com."},
+					{Pattern: `^ads[0-9]+\.example\.net$`, MatchType: dnsv1beta1.MatchTypeRegex},
+				},
+				AllowList: []dnsv1beta1.DomainRule{{Pattern: "example.com", MatchType: dnsv1beta1.MatchTypeSuffix}},
+			}
+			Expect(ValidateSpec(spec)).To(BeEmpty())
+		})
+
+		It("should require a selector or subject", func() {
+			errs := ValidateSpec(&dnsv1beta1.DnsPolicySpec{})
+			Expect(errs.ToAggregate()).To(MatchError(ContainSubstring("spec.targetSelector")))
+		})
+
+		It("should reject oversized lists", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				BlockList:      make([]dnsv1beta1.DomainRule, MaxRulesPerList+1),
+			}
+			Expect(ValidateSpec(spec).ToAggregate()).To(MatchError(ContainSubstring("spec.blockList: Too many")))
+		})
+
+		It("should report the path of an invalid pattern", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				Subject:   &dnsv1beta1.Subject{ServiceAccount: "payments"},
+				AllowList: []dnsv1beta1.DomainRule{{Pattern: "api.example.com"}, {Pattern: "api.*example.com"}},
+			}
+			Expect(ValidateSpec(spec).ToAggregate()).To(MatchError(ContainSubstring("spec.allowList[1].pattern")))
+		})
+
+		It("should reject invalid service account names", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				Subject: &dnsv1beta1.Subject{ServiceAccounts: []string{"payments", "Not_Valid"}},
+			}
+			Expect(ValidateSpec(spec).ToAggregate()).To(MatchError(ContainSubstring("spec.subject.serviceAccounts[1]")))
+		})
+
+		It("should validate the RPZ export", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				Subject: &dnsv1beta1.Subject{ServiceAccount: "payments"},
+				Export: &dnsv1beta1.PolicyExport{
+					RPZ: &dnsv1beta1.RPZExport{Zone: "rpz_zone", ConfigMapName: "Payments", Key: "db/rpz"},
+				},
+			}
+			errs := ValidateSpec(spec).ToAggregate()
+			Expect(errs).To(MatchError(ContainSubstring("spec.export.rpz.zone")))
+			Expect(errs).To(MatchError(ContainSubstring("spec.export.rpz.configMapName")))
+			Expect(errs).To(MatchError(ContainSubstring("spec.export.rpz.key")))
+		})
+
+		It("should require a default deny for network policies", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				Subject: &dnsv1beta1.Subject{ServiceAccount: "payments"},
+				NetworkPolicy: &dnsv1beta1.NetworkPolicyGeneration{
+					Providers: []dnsv1beta1.NetworkPolicyProvider{dnsv1beta1.NetworkPolicyProviderCilium},
+				},
+			}
+			Expect(ValidateSpec(spec).ToAggregate()).To(MatchError(ContainSubstring("spec.networkPolicy")))
+			spec.DefaultAction = dnsv1beta1.DefaultActionDeny
+			Expect(ValidateSpec(spec)).To(BeEmpty())
+		})
+
+		It("should forbid a subject namespaceSelector", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				Subject: &dnsv1beta1.Subject{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pci": "true"}},
+				},
+			}
+			Expect(ValidateSpec(spec).ToAggregate()).To(MatchError(ContainSubstring("spec.subject.namespaceSelector: Forbidden")))
+		})
+	})
+
+	Context("ValidateClusterSpec", func() {
+		It("should accept a spec without selectors", func() {
+			spec := &dnsv1beta1.ClusterDnsPolicySpec{
+				BlockList: []dnsv1beta1.DomainRule{{Pattern: "*.malicious-site.com"}},
+			}
+			Expect(ValidateClusterSpec(spec)).To(BeEmpty())
+		})
+
+		It("should report the path of an invalid namespace selector", func() {
+			spec := &dnsv1beta1.ClusterDnsPolicySpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "environment", Operator: metav1.LabelSelectorOpIn},
+				}},
+			}
+			Expect(ValidateClusterSpec(spec).ToAggregate()).To(MatchError(ContainSubstring("spec.namespaceSelector")))
+		})
+	})
+
+	Context("ValidateDomainListSpec", func() {
+		It("should require exactly one object key per source", func() {
+			spec := &dnsv1beta1.DomainListSpec{Sources: []dnsv1beta1.DomainListSource{
+				{Format: dnsv1beta1.DomainListFormatHosts},
+				{
+					ConfigMapKeyRef: &dnsv1beta1.SourceKeyReference{Name: "feeds", Key: "hosts"},
+					SecretKeyRef:    &dnsv1beta1.SourceKeyReference{Name: "feeds", Key: "hosts"},
+				},
+			}}
+			errs := ValidateDomainListSpec(spec).ToAggregate()
+			Expect(errs).To(MatchError(ContainSubstring("spec.sources[0]: Required value")))
+			Expect(errs).To(MatchError(ContainSubstring("spec.sources[1].secretKeyRef: Forbidden")))
+		})
+
+		It("should only allow a ClusterDomainList to download lists", func() {
+			spec := &dnsv1beta1.DomainListSpec{Sources: []dnsv1beta1.DomainListSource{
+				{HTTP: &dnsv1beta1.HTTPSource{URL: "https://mirror.internal/threats.txt"}},
+			}}
+			Expect(ValidateDomainListSpec(spec).ToAggregate()).To(
+				MatchError(ContainSubstring("spec.sources[0].http: Forbidden")))
+			Expect(ValidateClusterDomainListSpec(spec)).To(BeEmpty())
+
+			for _, url := range []string{"http://169.254.169.254/latest/meta-data", "http://localhost:8081/", "http://[::1]/", "http://0.0.0.0/"} {
+				spec.Sources[0].HTTP.URL = url
+				Expect(ValidateClusterDomainListSpec(spec).ToAggregate()).To(
+					MatchError(ContainSubstring("spec.sources[0].http.url: Invalid value")), url)
+			}
+		})
+
+		It("should validate HTTP sources", func() {
+			spec := &dnsv1beta1.DomainListSpec{Sources: []dnsv1beta1.DomainListSource{
+				{HTTP: &dnsv1beta1.HTTPSource{
+					URL:       "https://mirror.internal/threats.txt",
+					Interval:  &metav1.Duration{Duration: 15 * time.Minute},
+					SHA256URL: "https://mirror.internal/threats.txt.sha256",
+					MaxSize:   resource.NewQuantity(1<<20, resource.BinarySI),
+				}},
+			}}
+			Expect(ValidateClusterDomainListSpec(spec)).To(BeEmpty())
+
+			spec.Sources = append(spec.Sources,
+				dnsv1beta1.DomainListSource{
+					ConfigMapKeyRef: &dnsv1beta1.SourceKeyReference{Name: "feeds", Key: "hosts"},
+					HTTP:            &dnsv1beta1.HTTPSource{URL: "https://mirror.internal/threats.txt"},
+				},
+				dnsv1beta1.DomainListSource{HTTP: &dnsv1beta1.HTTPSource{
+					URL:      "ftp://mirror.internal/threats.txt",
+					Interval: &metav1.Duration{Duration: time.Second},
+					SHA256:   "not-a-checksum",
+					MaxSize:  resource.NewQuantity(MaxFeedMaxSize+1, resource.BinarySI),
+				}},
+			)
+			errs := ValidateClusterDomainListSpec(spec).ToAggregate()
+			Expect(errs).To(MatchError(ContainSubstring("spec.sources[1].http: Forbidden")))
+			Expect(errs).To(MatchError(ContainSubstring("spec.sources[2].http.url: Invalid value")))
+			Expect(errs).To(MatchError(ContainSubstring("spec.sources[2].http.interval: Invalid value")))
+			Expect(errs).To(MatchError(ContainSubstring("spec.sources[2].http.sha256: Invalid value")))
+			Expect(errs).To(MatchError(ContainSubstring("spec.sources[2].http.maxSize: Invalid value")))
+		})
+
+		It("should only allow a ClusterDomainList to read other namespaces", func() {
+			spec := &dnsv1beta1.DomainListSpec{Sources: []dnsv1beta1.DomainListSource{{
+				ConfigMapKeyRef: &dnsv1beta1.SourceKeyReference{Name: "feeds", Namespace: "security", Key: "hosts"},
+			}}}
+			Expect(ValidateDomainListSpec(spec).ToAggregate()).To(
+				MatchError(ContainSubstring("spec.sources[0].configMapKeyRef.namespace: Forbidden")))
+			Expect(ValidateClusterDomainListSpec(spec)).To(BeEmpty())
+
+			spec.Sources[0].ConfigMapKeyRef.Namespace = ""
+			Expect(ValidateClusterDomainListSpec(spec).ToAggregate()).To(
+				MatchError(ContainSubstring("spec.sources[0].configMapKeyRef.namespace: Required value")))
+		})
+	})
+
+	DescribeTable("ValidatePattern should reject",
+		func(pattern string, matchType dnsv1beta1.MatchType) {
+			Expect(ValidatePattern(pattern, matchType)).NotTo(Succeed())
+		},
+		Entry("an empty name", ".", dnsv1beta1.MatchTypeExact),
+		Entry("an empty label", "api..example.com", dnsv1beta1.MatchTypeExact),
+		Entry("a label starting with a hyphen", "-api.example.com", dnsv1beta1.MatchTypeExact),
+		Entry("spaces", "api example.com", dnsv1beta1.MatchTypeExact),
+		Entry("a wildcard inside a label", "ads*.example.com", dnsv1beta1.MatchTypeWildcard),
+		Entry("a double wildcard", "**.example.com", dnsv1beta1.MatchTypeWildcard),
+		Entry("a wildcard in an exact rule", "*.example.com", dnsv1beta1.MatchTypeExact),
+		Entry("a wildcard in a suffix rule", "*.example.com", dnsv1beta1.MatchTypeSuffix),
+		Entry("an invalid regular expression", "(ads", dnsv1beta1.MatchTypeRegex),
+	)
+
+	Context("ValidateActions", func() {
+		It("should accept a sinkhole rule for a blocked pattern", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				Action: &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionRefused},
+				BlockList: []dnsv1beta1.DomainRule{{
+					Pattern: "*.malicious-site.com",
+					Action: &dnsv1beta1.BlockAction{
+						Type:         dnsv1beta1.BlockActionSinkhole,
+						SinkholeIPv4: "10.0.0.53",
+						SinkholeIPv6: "fd00::53",
+					},
+				}},
+			}
+			Expect(ValidateActions(spec)).To(Succeed())
+		})
+
+		DescribeTable("should reject invalid actions",
+			func(action dnsv1beta1.BlockAction) {
+				spec := &dnsv1beta1.DnsPolicySpec{Action: &action}
+				Expect(ValidateActions(spec)).NotTo(Succeed())
+			},
+			Entry("sinkhole without address", dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionSinkhole}),
+			Entry("IPv6 address as IPv4", dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionSinkhole, SinkholeIPv4: "fd00::53"}),
+			Entry("IPv4 address as IPv6", dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionSinkhole, SinkholeIPv6: "10.0.0.53"}),
+			Entry("address without sinkhole", dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionNXDomain, SinkholeIPv4: "10.0.0.53"}),
+		)
+
+		It("should reject invalid rule actions", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				BlockList: []dnsv1beta1.DomainRule{{
+					Pattern: "tracking.ads.net",
+					Action:  &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionSinkhole},
+				}},
+			}
+			Expect(ValidateActions(spec)).To(MatchError(ContainSubstring("blockList[0].action")))
+		})
+
+		It("should reject actions on allowList rules", func() {
+			spec := &dnsv1beta1.DnsPolicySpec{
+				AllowList: []dnsv1beta1.DomainRule{{
+					Pattern: "api.example.com",
+					Action:  &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionRefused},
+				}},
+			}
+			Expect(ValidateActions(spec)).NotTo(Succeed())
+		})
+	})
+
+	Context("ResolveAction", func() {
+		It("should default to NXDOMAIN", func() {
+			Expect(ResolveAction(&dnsv1beta1.DnsPolicySpec{}).Type).To(Equal(dnsv1beta1.BlockActionNXDomain))
+		})
+	})
+})
@@ -0,0 +1,111 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+	"github.com/WoodProgrammer/dns-mesh-controller/internal/controller"
+)
+
+// log is for logging in this package.
+var clusterdnspolicylog = logf.Log.WithName("clusterdnspolicy-resource")
+
+// SetupClusterDnsPolicyWebhookWithManager registers the webhooks for ClusterDnsPolicy in the manager.
+func SetupClusterDnsPolicyWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&dnsv1beta1.ClusterDnsPolicy{}).
+		WithValidator(&ClusterDnsPolicyCustomValidator{}).
+		WithDefaulter(&ClusterDnsPolicyCustomDefaulter{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-dns-dnspolicies-io-v1beta1-clusterdnspolicy,mutating=true,failurePolicy=fail,sideEffects=None,groups=dns.dnspolicies.io,resources=clusterdnspolicies,verbs=create;update,versions=v1beta1,name=mclusterdnspolicy-v1beta1.kb.io,admissionReviewVersions=v1
+
+// ClusterDnsPolicyCustomDefaulter stores ClusterDnsPolicy rule lists in
+// canonical form, like DnsPolicyCustomDefaulter.
+type ClusterDnsPolicyCustomDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &ClusterDnsPolicyCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind ClusterDnsPolicy.
+func (d *ClusterDnsPolicyCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	clusterdnspolicy, ok := obj.(*dnsv1beta1.ClusterDnsPolicy)
+	if !ok {
+		return fmt.Errorf("expected a ClusterDnsPolicy object but got %T", obj)
+	}
+	clusterdnspolicylog.Info("Defaulting for ClusterDnsPolicy", "name", clusterdnspolicy.GetName())
+
+	controller.NormalizeClusterSpec(&clusterdnspolicy.Spec)
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-dns-dnspolicies-io-v1beta1-clusterdnspolicy,mutating=false,failurePolicy=fail,sideEffects=None,groups=dns.dnspolicies.io,resources=clusterdnspolicies,verbs=create;update,versions=v1beta1,name=vclusterdnspolicy-v1beta1.kb.io,admissionReviewVersions=v1
+
+// ClusterDnsPolicyCustomValidator rejects ClusterDnsPolicy specs the
+// controller would refuse to index.
+type ClusterDnsPolicyCustomValidator struct{}
+
+var _ webhook.CustomValidator = &ClusterDnsPolicyCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type ClusterDnsPolicy.
+func (v *ClusterDnsPolicyCustomValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	clusterdnspolicy, ok := obj.(*dnsv1beta1.ClusterDnsPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterDnsPolicy object but got %T", obj)
+	}
+	clusterdnspolicylog.Info("Validation for ClusterDnsPolicy upon creation", "name", clusterdnspolicy.GetName())
+
+	return nil, validateClusterDnsPolicy(clusterdnspolicy)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type ClusterDnsPolicy.
+func (v *ClusterDnsPolicyCustomValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	clusterdnspolicy, ok := newObj.(*dnsv1beta1.ClusterDnsPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterDnsPolicy object for the newObj but got %T", newObj)
+	}
+	clusterdnspolicylog.Info("Validation for ClusterDnsPolicy upon update", "name", clusterdnspolicy.GetName())
+
+	// Let policies that are being deleted drop their finalizer
+	if !clusterdnspolicy.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	return nil, validateClusterDnsPolicy(clusterdnspolicy)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type ClusterDnsPolicy.
+func (v *ClusterDnsPolicyCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateClusterDnsPolicy runs the spec checks shared with the reconciler.
+func validateClusterDnsPolicy(clusterdnspolicy *dnsv1beta1.ClusterDnsPolicy) error {
+	errs := controller.ValidateClusterSpec(&clusterdnspolicy.Spec)
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(dnsv1beta1.GroupVersion.WithKind("ClusterDnsPolicy").GroupKind(), clusterdnspolicy.Name, errs)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("ClusterDnsPolicy Webhook", func() {
+	var (
+		ctx       context.Context
+		obj       *dnsv1beta1.ClusterDnsPolicy
+		validator ClusterDnsPolicyCustomValidator
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		obj = &dnsv1beta1.ClusterDnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "baseline"},
+			Spec: dnsv1beta1.ClusterDnsPolicySpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"environment": "production"}},
+				BlockList:         []dnsv1beta1.DomainRule{{Pattern: "*.malicious-site.com"}},
+			},
+		}
+		validator = ClusterDnsPolicyCustomValidator{}
+	})
+
+	Context("When creating ClusterDnsPolicy under Defaulting Webhook", func() {
+		It("Should normalize the rule lists", func() {
+			obj.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "Tracking.Ads.NET."}, {Pattern: "tracking.ads.net"}}
+
+			defaulter := ClusterDnsPolicyCustomDefaulter{}
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.BlockList).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "tracking.ads.net"}}))
+		})
+	})
+
+	Context("When creating or updating ClusterDnsPolicy under Validating Webhook", func() {
+		It("Should admit a policy without selectors", func() {
+			obj.Spec.NamespaceSelector = nil
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+		})
+
+		It("Should deny creation if the namespace selector has an invalid expression", func() {
+			obj.Spec.NamespaceSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "environment", Operator: metav1.LabelSelectorOpExists, Values: []string{"production"}},
+			}}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("spec.namespaceSelector")))
+		})
+
+		It("Should deny an update with a malformed pattern", func() {
+			updated := obj.DeepCopy()
+			updated.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "ads*.example.com"}}
+			_, err := validator.ValidateUpdate(ctx, obj, updated)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("spec.blockList[0].pattern")))
+		})
+	})
+})
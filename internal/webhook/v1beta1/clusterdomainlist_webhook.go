@@ -0,0 +1,107 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+	"github.com/WoodProgrammer/dns-mesh-controller/internal/controller"
+)
+
+// log is for logging in this package.
+var clusterdomainlistlog = logf.Log.WithName("clusterdomainlist-resource")
+
+// SetupClusterDomainListWebhookWithManager registers the webhooks for ClusterDomainList in the manager.
+func SetupClusterDomainListWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&dnsv1beta1.ClusterDomainList{}).
+		WithValidator(&ClusterDomainListCustomValidator{}).
+		WithDefaulter(&ClusterDomainListCustomDefaulter{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-dns-dnspolicies-io-v1beta1-clusterdomainlist,mutating=true,failurePolicy=fail,sideEffects=None,groups=dns.dnspolicies.io,resources=clusterdomainlists,verbs=create;update,versions=v1beta1,name=mclusterdomainlist-v1beta1.kb.io,admissionReviewVersions=v1
+
+// ClusterDomainListCustomDefaulter stores ClusterDomainList rules in canonical form, like
+// DnsPolicyCustomDefaulter.
+type ClusterDomainListCustomDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &ClusterDomainListCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind ClusterDomainList.
+func (d *ClusterDomainListCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	clusterdomainlist, ok := obj.(*dnsv1beta1.ClusterDomainList)
+	if !ok {
+		return fmt.Errorf("expected a ClusterDomainList object but got %T", obj)
+	}
+	clusterdomainlistlog.Info("Defaulting for ClusterDomainList", "name", clusterdomainlist.GetName())
+
+	controller.NormalizeDomainListSpec(&clusterdomainlist.Spec)
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-dns-dnspolicies-io-v1beta1-clusterdomainlist,mutating=false,failurePolicy=fail,sideEffects=None,groups=dns.dnspolicies.io,resources=clusterdomainlists,verbs=create;update,versions=v1beta1,name=vclusterdomainlist-v1beta1.kb.io,admissionReviewVersions=v1
+
+// ClusterDomainListCustomValidator rejects ClusterDomainList rules that the controller would
+// skip when expanding the policies referencing the list.
+type ClusterDomainListCustomValidator struct{}
+
+var _ webhook.CustomValidator = &ClusterDomainListCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type ClusterDomainList.
+func (v *ClusterDomainListCustomValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	clusterdomainlist, ok := obj.(*dnsv1beta1.ClusterDomainList)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterDomainList object but got %T", obj)
+	}
+	clusterdomainlistlog.Info("Validation for ClusterDomainList upon creation", "name", clusterdomainlist.GetName())
+
+	return nil, validateClusterDomainList(clusterdomainlist)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type ClusterDomainList.
+func (v *ClusterDomainListCustomValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	clusterdomainlist, ok := newObj.(*dnsv1beta1.ClusterDomainList)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterDomainList object for the newObj but got %T", newObj)
+	}
+	clusterdomainlistlog.Info("Validation for ClusterDomainList upon update", "name", clusterdomainlist.GetName())
+
+	return nil, validateClusterDomainList(clusterdomainlist)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type ClusterDomainList.
+func (v *ClusterDomainListCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateClusterDomainList runs the rule checks shared with the reconciler.
+func validateClusterDomainList(clusterdomainlist *dnsv1beta1.ClusterDomainList) error {
+	errs := controller.ValidateClusterDomainListSpec(&clusterdomainlist.Spec)
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(dnsv1beta1.GroupVersion.WithKind("ClusterDomainList").GroupKind(), clusterdomainlist.Name, errs)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+	"github.com/WoodProgrammer/dns-mesh-controller/internal/controller"
+)
+
+var _ = Describe("ClusterDomainList Webhook", func() {
+	var (
+		ctx       context.Context
+		obj       *dnsv1beta1.ClusterDomainList
+		validator ClusterDomainListCustomValidator
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		obj = &dnsv1beta1.ClusterDomainList{
+			ObjectMeta: metav1.ObjectMeta{Name: "trackers"},
+			Spec: dnsv1beta1.DomainListSpec{
+				Rules: []dnsv1beta1.DomainRule{{Pattern: "tracker.example", MatchType: dnsv1beta1.MatchTypeSuffix}},
+			},
+		}
+		validator = ClusterDomainListCustomValidator{}
+	})
+
+	Context("When creating or updating ClusterDomainList under Validating Webhook", func() {
+		It("Should admit a valid list", func() {
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+		})
+
+		It("Should deny creation if the list is too long", func() {
+			obj.Spec.Rules = make([]dnsv1beta1.DomainRule, controller.MaxRulesPerList+1)
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("spec.rules: Too many")))
+		})
+	})
+})
@@ -0,0 +1,161 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+	"github.com/WoodProgrammer/dns-mesh-controller/internal/controller"
+)
+
+// log is for logging in this package.
+var dnspolicylog = logf.Log.WithName("dnspolicy-resource")
+
+// SetupDnsPolicyWebhookWithManager registers the webhooks for DnsPolicy in the manager.
+// v1beta1 is the conversion hub, so this also serves /convert for v1alpha1 objects.
+func SetupDnsPolicyWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&dnsv1beta1.DnsPolicy{}).
+		WithValidator(&DnsPolicyCustomValidator{Client: mgr.GetClient()}).
+		WithDefaulter(&DnsPolicyCustomDefaulter{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-dns-dnspolicies-io-v1beta1-dnspolicy,mutating=true,failurePolicy=fail,sideEffects=None,groups=dns.dnspolicies.io,resources=dnspolicies,verbs=create;update,versions=v1beta1,name=mdnspolicy-v1beta1.kb.io,admissionReviewVersions=v1
+
+// DnsPolicyCustomDefaulter stores DnsPolicy rule lists in canonical form so
+// that equivalent policies get the same SpecHash.
+type DnsPolicyCustomDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &DnsPolicyCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind DnsPolicy.
+func (d *DnsPolicyCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	dnspolicy, ok := obj.(*dnsv1beta1.DnsPolicy)
+	if !ok {
+		return fmt.Errorf("expected a DnsPolicy object but got %T", obj)
+	}
+	dnspolicylog.Info("Defaulting for DnsPolicy", "name", dnspolicy.GetName())
+
+	controller.NormalizeSpec(&dnspolicy.Spec)
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-dns-dnspolicies-io-v1beta1-dnspolicy,mutating=false,failurePolicy=fail,sideEffects=None,groups=dns.dnspolicies.io,resources=dnspolicies,verbs=create;update,versions=v1beta1,name=vdnspolicy-v1beta1.kb.io,admissionReviewVersions=v1
+
+// DnsPolicyCustomValidator rejects DnsPolicy specs the controller would refuse
+// to index, so that `kubectl apply` fails instead of the reconciler.
+type DnsPolicyCustomValidator struct {
+	// Client lists existing policies to warn about policies that will be
+	// merged and looks up referenced lists to warn about missing ones.
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &DnsPolicyCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type DnsPolicy.
+func (v *DnsPolicyCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	dnspolicy, ok := obj.(*dnsv1beta1.DnsPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a DnsPolicy object but got %T", obj)
+	}
+	dnspolicylog.Info("Validation for DnsPolicy upon creation", "name", dnspolicy.GetName())
+
+	return v.validateDnsPolicy(ctx, dnspolicy)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type DnsPolicy.
+func (v *DnsPolicyCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	dnspolicy, ok := newObj.(*dnsv1beta1.DnsPolicy)
+	if !ok {
+		return nil, fmt.Errorf("expected a DnsPolicy object for the newObj but got %T", newObj)
+	}
+	dnspolicylog.Info("Validation for DnsPolicy upon update", "name", dnspolicy.GetName())
+
+	// Let policies that are being deleted drop their finalizer
+	if !dnspolicy.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	return v.validateDnsPolicy(ctx, dnspolicy)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type DnsPolicy.
+func (v *DnsPolicyCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateDnsPolicy runs the spec checks shared with the reconciler and
+// warns when other policies select the same pods or a referenced list is
+// missing.
+func (v *DnsPolicyCustomValidator) validateDnsPolicy(ctx context.Context, dnspolicy *dnsv1beta1.DnsPolicy) (admission.Warnings, error) {
+	if errs := controller.ValidateSpec(&dnspolicy.Spec); len(errs) > 0 {
+		return nil, apierrors.NewInvalid(dnsv1beta1.GroupVersion.WithKind("DnsPolicy").GroupKind(), dnspolicy.Name, errs)
+	}
+	warnings, err := v.sharedSelectorWarnings(ctx, dnspolicy)
+	if err != nil {
+		return nil, err
+	}
+	// Lists may be created after the policy, so a missing one is not an error
+	_, unresolved, err := controller.ExpandDomainLists(ctx, v.Client, nil, dnspolicy.Namespace, &dnspolicy.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load referenced domain lists: %w", err)
+	}
+	for _, problem := range unresolved {
+		warnings = append(warnings, problem+"; its rules are left out until it is available")
+	}
+	return warnings, nil
+}
+
+// sharedSelectorWarnings returns a warning for every other DnsPolicy in the
+// same namespace with the same selector hash. Such policies are allowed and
+// merged for the sidecar, but authors should know their rules are combined.
+func (v *DnsPolicyCustomValidator) sharedSelectorWarnings(ctx context.Context, dnspolicy *dnsv1beta1.DnsPolicy) (admission.Warnings, error) {
+	selectorHash, err := controller.SelectorHashFor(&dnspolicy.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies dnsv1beta1.DnsPolicyList
+	if err := v.Client.List(ctx, &policies, client.InNamespace(dnspolicy.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list DnsPolicies: %w", err)
+	}
+
+	var warnings admission.Warnings
+	for i := range policies.Items {
+		other := &policies.Items[i]
+		if other.Name == dnspolicy.Name {
+			continue
+		}
+		otherHash, err := controller.SelectorHashFor(&other.Spec)
+		if err != nil || otherHash != selectorHash {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf(
+			"selects the same pods as DnsPolicy %s/%s (selector hash %s); the rules of both policies are merged",
+			other.Namespace, other.Name, selectorHash))
+	}
+	return warnings, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("DnsPolicy Webhook", func() {
+	var (
+		ctx       context.Context
+		obj       *dnsv1beta1.DnsPolicy
+		existing  *dnsv1beta1.DnsPolicy
+		validator DnsPolicyCustomValidator
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		existing = &dnsv1beta1.DnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+			Spec: dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				BlockList:      []dnsv1beta1.DomainRule{{Pattern: "*.malicious-site.com"}},
+			},
+		}
+		obj = &dnsv1beta1.DnsPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "default"},
+			Spec: dnsv1beta1.DnsPolicySpec{
+				TargetSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "backend"}},
+				BlockList:      []dnsv1beta1.DomainRule{{Pattern: "tracking.ads.net"}},
+			},
+		}
+		validator = DnsPolicyCustomValidator{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build(),
+		}
+	})
+
+	Context("When creating DnsPolicy under Defaulting Webhook", func() {
+		It("Should normalize the rule lists", func() {
+			obj.Spec.BlockList = []dnsv1beta1.DomainRule{{Pattern: "Tracking.Ads.NET."}, {Pattern: "*.Bücher.example"}, {Pattern: "tracking.ads.net"}}
+			obj.Spec.AllowList = []dnsv1beta1.DomainRule{{Pattern: "API.example.com"}}
+
+			defaulter := DnsPolicyCustomDefaulter{}
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.BlockList).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "*.xn--bcher-kva.example"}, {Pattern: "tracking.ads.net"}}))
+			Expect(obj.Spec.AllowList).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "api.example.com"}}))
+		})
+	})
+
+	Context("When creating or updating DnsPolicy under Validating Webhook", func() {
+		It("Should admit a valid policy", func() {
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+		})
+
+		It("Should deny creation if the selector and subject are empty", func() {
+			obj.Spec.TargetSelector = nil
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("spec.targetSelector")))
+		})
+
+		It("Should deny creation if the selector has an invalid expression", func() {
+			obj.Spec.TargetSelector.MatchExpressions = []metav1.LabelSelectorRequirement{
+				{Key: "tier", Operator: metav1.LabelSelectorOpExists, Values: []string{"web"}},
+			}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.targetSelector.matchExpressions[0].values")))
+		})
+
+		It("Should deny creation if a pattern is malformed", func() {
+			obj.Spec.BlockList = append(obj.Spec.BlockList, dnsv1beta1.DomainRule{Pattern: "ads*.example.com"})
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.blockList[1].pattern")))
+		})
+
+		It("Should warn if another policy in the namespace has the same selector hash", func() {
+			obj.Spec.TargetSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}
+			warnings, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring("DnsPolicy default/frontend")))
+		})
+
+		It("Should not warn about the same selector in another namespace", func() {
+			obj.Namespace = "payments"
+			obj.Spec.TargetSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+		})
+
+		It("Should not warn about the policy itself on update", func() {
+			updated := existing.DeepCopy()
+			updated.Spec.BlockList = append(updated.Spec.BlockList, dnsv1beta1.DomainRule{Pattern: "tracking.ads.net"})
+			Expect(validator.ValidateUpdate(ctx, existing, updated)).To(BeNil())
+		})
+
+		It("Should warn if a referenced list does not exist", func() {
+			validator.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(&dnsv1beta1.DomainList{
+				ObjectMeta: metav1.ObjectMeta{Name: "ads", Namespace: "default"},
+			}).Build()
+			obj.Spec.BlockListRefs = []dnsv1beta1.DomainListReference{
+				{Name: "ads"},
+				{Kind: dnsv1beta1.DomainListKindCluster, Name: "trackers"},
+			}
+			warnings, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring("ClusterDomainList trackers not found")))
+		})
+
+		It("Should deny a list referenced twice", func() {
+			obj.Spec.AllowListRefs = []dnsv1beta1.DomainListReference{
+				{Name: "partners"},
+				{Kind: dnsv1beta1.DomainListKindNamespaced, Name: "partners"},
+			}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("spec.allowListRefs[1]: Duplicate value")))
+		})
+
+		It("Should admit a policy that is being deleted", func() {
+			deleting := existing.DeepCopy()
+			deleting.Spec.TargetSelector = nil
+			now := metav1.Now()
+			deleting.DeletionTimestamp = &now
+			Expect(validator.ValidateUpdate(ctx, existing, deleting)).To(BeNil())
+		})
+	})
+})
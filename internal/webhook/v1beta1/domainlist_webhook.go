@@ -0,0 +1,107 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+	"github.com/WoodProgrammer/dns-mesh-controller/internal/controller"
+)
+
+// log is for logging in this package.
+var domainlistlog = logf.Log.WithName("domainlist-resource")
+
+// SetupDomainListWebhookWithManager registers the webhooks for DomainList in the manager.
+func SetupDomainListWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&dnsv1beta1.DomainList{}).
+		WithValidator(&DomainListCustomValidator{}).
+		WithDefaulter(&DomainListCustomDefaulter{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-dns-dnspolicies-io-v1beta1-domainlist,mutating=true,failurePolicy=fail,sideEffects=None,groups=dns.dnspolicies.io,resources=domainlists,verbs=create;update,versions=v1beta1,name=mdomainlist-v1beta1.kb.io,admissionReviewVersions=v1
+
+// DomainListCustomDefaulter stores DomainList rules in canonical form, like
+// DnsPolicyCustomDefaulter.
+type DomainListCustomDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &DomainListCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind DomainList.
+func (d *DomainListCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	domainlist, ok := obj.(*dnsv1beta1.DomainList)
+	if !ok {
+		return fmt.Errorf("expected a DomainList object but got %T", obj)
+	}
+	domainlistlog.Info("Defaulting for DomainList", "name", domainlist.GetName())
+
+	controller.NormalizeDomainListSpec(&domainlist.Spec)
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-dns-dnspolicies-io-v1beta1-domainlist,mutating=false,failurePolicy=fail,sideEffects=None,groups=dns.dnspolicies.io,resources=domainlists,verbs=create;update,versions=v1beta1,name=vdomainlist-v1beta1.kb.io,admissionReviewVersions=v1
+
+// DomainListCustomValidator rejects DomainList rules that the controller would
+// skip when expanding the policies referencing the list.
+type DomainListCustomValidator struct{}
+
+var _ webhook.CustomValidator = &DomainListCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type DomainList.
+func (v *DomainListCustomValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	domainlist, ok := obj.(*dnsv1beta1.DomainList)
+	if !ok {
+		return nil, fmt.Errorf("expected a DomainList object but got %T", obj)
+	}
+	domainlistlog.Info("Validation for DomainList upon creation", "name", domainlist.GetName())
+
+	return nil, validateDomainList(domainlist)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type DomainList.
+func (v *DomainListCustomValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	domainlist, ok := newObj.(*dnsv1beta1.DomainList)
+	if !ok {
+		return nil, fmt.Errorf("expected a DomainList object for the newObj but got %T", newObj)
+	}
+	domainlistlog.Info("Validation for DomainList upon update", "name", domainlist.GetName())
+
+	return nil, validateDomainList(domainlist)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type DomainList.
+func (v *DomainListCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateDomainList runs the rule checks shared with the reconciler.
+func validateDomainList(domainlist *dnsv1beta1.DomainList) error {
+	errs := controller.ValidateDomainListSpec(&domainlist.Spec)
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(dnsv1beta1.GroupVersion.WithKind("DomainList").GroupKind(), domainlist.Name, errs)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1beta1 "github.com/WoodProgrammer/dns-mesh-controller/api/v1beta1"
+)
+
+var _ = Describe("DomainList Webhook", func() {
+	var (
+		ctx       context.Context
+		obj       *dnsv1beta1.DomainList
+		validator DomainListCustomValidator
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		obj = &dnsv1beta1.DomainList{
+			ObjectMeta: metav1.ObjectMeta{Name: "ads", Namespace: "default"},
+			Spec: dnsv1beta1.DomainListSpec{
+				Rules: []dnsv1beta1.DomainRule{{Pattern: "*.doubleclick.net"}, {Pattern: "tracking.ads.net"}},
+			},
+		}
+		validator = DomainListCustomValidator{}
+	})
+
+	Context("When creating DomainList under Defaulting Webhook", func() {
+		It("Should normalize the rules", func() {
+			obj.Spec.Rules = []dnsv1beta1.DomainRule{{Pattern: "Tracking.Ads.NET."}, {Pattern: "tracking.ads.net"}}
+
+			defaulter := DomainListCustomDefaulter{}
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Rules).To(Equal([]dnsv1beta1.DomainRule{{Pattern: "tracking.ads.net"}}))
+		})
+	})
+
+	Context("When creating or updating DomainList under Validating Webhook", func() {
+		It("Should admit a valid list", func() {
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+		})
+
+		It("Should deny an update with a malformed pattern", func() {
+			updated := obj.DeepCopy()
+			updated.Spec.Rules = append(updated.Spec.Rules, dnsv1beta1.DomainRule{Pattern: "ads*.example.com"})
+			_, err := validator.ValidateUpdate(ctx, obj, updated)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("spec.rules[2].pattern")))
+		})
+
+		It("Should deny a sinkhole rule without an address", func() {
+			obj.Spec.Rules[0].Action = &dnsv1beta1.BlockAction{Type: dnsv1beta1.BlockActionSinkhole}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("spec.rules[0].action")))
+		})
+	})
+})